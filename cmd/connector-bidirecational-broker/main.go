@@ -19,7 +19,9 @@ type config struct {
 }
 
 type request struct {
-	forwarder   connectorv1.Forwarder
+	forwarder connectorv1.Forwarder
+	// async is only set when the sdk buffers queued messages on disk, see handleInboundRequest
+	async       connectorv1.AsyncForwarder
 	messageName string
 	body        []byte
 	headers     map[string]string
@@ -80,6 +82,14 @@ func (c connector) Start(ctx connectorv1.StartContext) error {
 		return err
 	}
 
+	// messages can only be acknowledged before they are delivered when the sdk buffers them on disk, which it does
+	// when the agent config sets a buffer_dir, otherwise they are forwarded synchronously
+	async, _ := ctx.Forwarder().(connectorv1.AsyncForwarder)
+	if async != nil && !async.HasBuffer() {
+		ctx.Log().Info("no buffer_dir in the agent config, inbound requests are forwarded synchronously")
+		async = nil
+	}
+
 	// iterate over the inbound message descriptors and setup each one
 	for _, descriptor := range ctx.InboundDescriptors() {
 		var subCfg *subscription
@@ -90,6 +100,7 @@ func (c connector) Start(ctx connectorv1.StartContext) error {
 		if err := c.broker.Subscribe(subCfg, func(msg *message) error {
 			if err := c.handleInboundRequest(&request{
 				forwarder:   ctx.Forwarder(),
+				async:       async,
 				messageName: descriptor.MessageName(),
 				body:        msg.body,
 				headers:     msg.headers,
@@ -125,9 +136,21 @@ func (c connector) Stop(_ connectorv1.StopContext) error {
 // INBOUND HANDLING
 /************************************************************************/
 
-// handleInboundRequest handles inbound requests from the server e.g. open api server
+// handleInboundRequest forwards the message of the broker to the agent, the message is acknowledged upstream once
+// it was delivered or, when the sdk buffers messages on disk, once ForwardAsync wrote it to the buffer. An error
+// rejects the message so the broker delivers it again e.g. when the queue is full
 func (c connector) handleInboundRequest(req *request, logger connectorv1.Logger) error {
-	_, err := req.forwarder.Forward(req.messageName, req.body, req.headers)
+	var err error
+	if req.async != nil {
+		err = req.async.ForwardAsync(req.messageName, req.body, req.headers,
+			connectorv1.WithDeliveryCallback(func(_ connectorv1.InboundResponse, err error) {
+				if err != nil {
+					logger.Error(err, "could not deliver inbound request: %s", req.messageName)
+				}
+			}))
+	} else {
+		_, err = req.forwarder.Forward(req.messageName, req.body, req.headers)
+	}
 	if err != nil {
 		logger.Error(err, "could not handle inbound request")
 		return err
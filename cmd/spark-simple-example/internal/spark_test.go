@@ -66,10 +66,13 @@ func Test_Should_Cancel(t *testing.T) {
 		return
 	}
 
-	assert.Nil(t, result)
+	if assert.NotNil(t, result) && assert.NotNil(t, result.Cancellation) {
+		assert.Equal(t, "stage-3", result.Cancellation.StageName)
+		assert.Equal(t, sparkv1.ErrorCodeCancelled, result.Error.ErrorCode)
+	}
 
 	worker.AssertStageCompleted("stage-1")
 	worker.AssertStageCompleted("stage-2")
-	worker.AssertStageFailed("stage-3")
+	worker.AssertStageCancelled("stage-3")
 	worker.AssertStageOrder("stage-1", "stage-2")
 }
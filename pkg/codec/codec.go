@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
 	"regexp"
 	"strings"
@@ -27,6 +28,10 @@ const (
 	MimeTypeImagePng    MimeType = "image/png"
 	MimeTypeImageGif    MimeType = "image/gif"
 	MimeTypeImageSvg    MimeType = "image/svg"
+	MimeTypeProtobuf    MimeType = "application/x-protobuf"
+	MimeTypeYaml        MimeType = "application/yaml"
+	MimeTypeCsv         MimeType = "text/csv"
+	MimeTypeMsgPack     MimeType = "application/msgpack"
 
 	TypeApplication = "application"
 	TypeImage       = "image"
@@ -55,11 +60,37 @@ func Encode(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
 
+// DetectMimeType returns the mime type of a value before it is encoded with Encode, raw bytes are json when they
+// are valid json and are otherwise sniffed for images, strings are text and every other value is json
+func DetectMimeType(v any) MimeType {
+	switch b := v.(type) {
+	case string:
+		return MimeTypeText
+	case []byte:
+		if len(b) > 0 && json.Valid(b) {
+			return MimeTypeJson
+		}
+		switch MimeType(http.DetectContentType(b)) {
+		case MimeTypeImagePng:
+			return MimeTypeImagePng
+		case MimeTypeImageJpeg:
+			return MimeTypeImageJpeg
+		case MimeTypeImageGif:
+			return MimeTypeImageGif
+		}
+		return MimeTypeOctetStream
+	default:
+		return MimeTypeJson
+	}
+}
+
 func DecodeValue(input []byte, mime MimeType) (any, error) {
 	var data any
 	return data, DecodeAndBind(input, mime, &data)
 }
 
+// DecodeAndBind decodes the input with the codec registered for the mime type into the target, raw bytes and
+// images can only be bound to byte slices and strings, inputs of mime types without a codec are decoded as json
 func DecodeAndBind(input []byte, mime MimeType, target any) error {
 	val := reflect.ValueOf(target)
 	if val.Kind() != reflect.Pointer || val.IsNil() {
@@ -72,11 +103,26 @@ func DecodeAndBind(input []byte, mime MimeType, target any) error {
 	}
 
 	// allow binding of raw bytes
-	_, ok := target.(*[]byte)
-	if mime.BaseType() == MimeTypeOctetStream || mime.BaseType().Type() == TypeImage || ok {
+	if _, ok := target.(*[]byte); ok {
 		elem.Set(reflect.ValueOf(input))
 		return nil
 	}
 
+	if c, ok := Lookup(mime); ok {
+		return c.Decode(input, target)
+	}
+
+	if mime.BaseType() == MimeTypeOctetStream || mime.BaseType().Type() == TypeImage {
+		switch {
+		case reflect.TypeOf(input).AssignableTo(elem.Type()):
+			elem.Set(reflect.ValueOf(input))
+		case elem.Kind() == reflect.String:
+			elem.SetString(string(input))
+		default:
+			return fmt.Errorf("%w: %s", ErrInvalidOctetStreamType, elem.Type())
+		}
+		return nil
+	}
+
 	return json.Unmarshal(input, target)
 }
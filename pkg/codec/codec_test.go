@@ -101,3 +101,40 @@ func TestType(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectMimeType(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    any
+		expected MimeType
+	}{
+		{name: "string", input: "my test string", expected: MimeTypeText},
+		{name: "struct", input: struct{ Foo string }{Foo: "bar"}, expected: MimeTypeJson},
+		{name: "map", input: map[string]any{"foo": "bar"}, expected: MimeTypeJson},
+		{name: "number", input: 123, expected: MimeTypeJson},
+		{name: "json bytes", input: []byte(`{"foo":"bar"}`), expected: MimeTypeJson},
+		{name: "png bytes", input: []byte("\x89PNG\x0D\x0A\x1A\x0A"), expected: MimeTypeImagePng},
+		{name: "gif bytes", input: []byte("GIF89a"), expected: MimeTypeImageGif},
+		{name: "raw bytes", input: []byte("my test"), expected: MimeTypeOctetStream},
+		{name: "empty bytes", input: []byte{}, expected: MimeTypeOctetStream},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, DetectMimeType(test.input))
+		})
+	}
+}
+
+func TestDecodeOctetStream(t *testing.T) {
+	t.Run("into string", func(t *testing.T) {
+		var out string
+		assert.NoError(t, DecodeAndBind([]byte("my test"), MimeTypeOctetStream, &out))
+		assert.Equal(t, "my test", out)
+	})
+
+	t.Run("into struct", func(t *testing.T) {
+		var out struct{ Foo string }
+		assert.ErrorIs(t, DecodeAndBind([]byte("my test"), MimeTypeOctetStream, &out), ErrInvalidOctetStreamType)
+	})
+}
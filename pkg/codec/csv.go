@@ -0,0 +1,314 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidCsvType = errors.New("csv values must be slices of structs, maps of strings or string records")
+)
+
+// csvCodec encodes slices of structs with a header row, columns are matched to fields by their csv tag or name
+type csvCodec struct{}
+
+// csvField an exported struct field and the column it is written to
+type csvField struct {
+	index  int
+	column string
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+/************************************************************************/
+// ENCODE
+/************************************************************************/
+
+func (csvCodec) Encode(v any) ([]byte, error) {
+	records, err := toCsvRecords(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toCsvRecords(v any) ([][]string, error) {
+	switch rows := v.(type) {
+	case [][]string:
+		return rows, nil
+	case []map[string]string:
+		return mapsToCsvRecords(rows), nil
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidCsvType, v)
+	}
+
+	elemType := val.Type().Elem()
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidCsvType, v)
+	}
+
+	fields := csvFields(elemType)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.column
+	}
+
+	records := [][]string{header}
+	for i := 0; i < val.Len(); i++ {
+		row := reflect.Indirect(val.Index(i))
+		record := make([]string, len(fields))
+		if row.IsValid() {
+			for j, f := range fields {
+				s, err := formatCsvValue(row.Field(f.index))
+				if err != nil {
+					return nil, err
+				}
+				record[j] = s
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func mapsToCsvRecords(rows []map[string]string) [][]string {
+	columns := map[string]bool{}
+	for _, row := range rows {
+		for k := range row {
+			columns[k] = true
+		}
+	}
+
+	header := make([]string, 0, len(columns))
+	for k := range columns {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+
+	records := [][]string{header}
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for i, column := range header {
+			record[i] = row[column]
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func formatCsvValue(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	default:
+		return fmt.Sprint(v.Interface()), nil
+	}
+}
+
+/************************************************************************/
+// DECODE
+/************************************************************************/
+
+func (csvCodec) Decode(data []byte, target any) error {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return err
+	}
+
+	switch t := target.(type) {
+	case *[][]string:
+		*t = records
+		return nil
+	case *[]map[string]string:
+		*t = csvRecordsToMaps(records)
+		return nil
+	case *any:
+		*t = csvRecordsToMaps(records)
+		return nil
+	}
+
+	val := reflect.ValueOf(target)
+	if val.Kind() != reflect.Pointer || val.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("%w: %T", ErrInvalidCsvType, target)
+	}
+
+	slice := val.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Pointer
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T", ErrInvalidCsvType, target)
+	}
+
+	out := reflect.MakeSlice(slice.Type(), 0, max(len(records)-1, 0))
+	if len(records) == 0 {
+		slice.Set(out)
+		return nil
+	}
+
+	// map the columns of the header to the fields of the struct
+	byColumn := map[string]int{}
+	for _, f := range csvFields(elemType) {
+		byColumn[strings.ToLower(f.column)] = f.index
+	}
+	columns := make([]int, len(records[0]))
+	for i, column := range records[0] {
+		idx, ok := byColumn[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			idx = -1
+		}
+		columns[i] = idx
+	}
+
+	for line, record := range records[1:] {
+		row := reflect.New(elemType).Elem()
+		for i, s := range record {
+			if i >= len(columns) || columns[i] < 0 {
+				continue
+			}
+			if err := parseCsvValue(row.Field(columns[i]), s); err != nil {
+				return fmt.Errorf("csv line %d, column %s: %w", line+2, records[0][i], err)
+			}
+		}
+		if isPtr {
+			row = row.Addr()
+		}
+		out = reflect.Append(out, row)
+	}
+
+	slice.Set(out)
+	return nil
+}
+
+func csvRecordsToMaps(records [][]string) []map[string]string {
+	rows := make([]map[string]string, 0, max(len(records)-1, 0))
+	if len(records) == 0 {
+		return rows
+	}
+
+	header := records[0]
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func parseCsvValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Pointer {
+		if s == "" {
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if s == "" && v.Kind() != reflect.String {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("%w: unsupported field type %s", ErrInvalidCsvType, v.Type())
+	}
+	return nil
+}
+
+// csvFields returns the exported fields of the struct, a csv tag of "-" skips the field
+func csvFields(t reflect.Type) []csvField {
+	var fields []csvField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		column := f.Name
+		if tag, ok := f.Tag.Lookup("csv"); ok {
+			if tag == "-" {
+				continue
+			}
+			if name := strings.Split(tag, ",")[0]; name != "" {
+				column = name
+			}
+		}
+		fields = append(fields, csvField{index: i, column: column})
+	}
+	return fields
+}
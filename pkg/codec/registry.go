@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidProtobufType = errors.New("protobuf values must implement proto.Message")
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// Codec encodes and decodes the values of a mime type
+type Codec interface {
+	Encode(v any) ([]byte, error)
+	Decode(data []byte, target any) error
+}
+
+type registry struct {
+	mu     sync.RWMutex
+	codecs map[MimeType]Codec
+}
+
+type jsonCodec struct{}
+
+type yamlCodec struct{}
+
+type protobufCodec struct{}
+
+type msgpackCodec struct{}
+
+var codecs = &registry{codecs: map[MimeType]Codec{}}
+
+func init() {
+	Register(MimeTypeJson, jsonCodec{})
+	// text values are json encoded strings
+	Register(MimeTypeText, jsonCodec{})
+	Register(MimeTypeYaml, yamlCodec{})
+	Register(MimeTypeProtobuf, protobufCodec{})
+	Register(MimeTypeMsgPack, msgpackCodec{})
+	Register(MimeTypeCsv, csvCodec{})
+}
+
+/************************************************************************/
+// REGISTRY
+/************************************************************************/
+
+// Register adds the codec for the base type of the mime type, a codec that was registered before for the same
+// base type is replaced
+func Register(mime MimeType, c Codec) {
+	codecs.mu.Lock()
+	defer codecs.mu.Unlock()
+
+	codecs.codecs[mime.BaseType()] = c
+}
+
+// Lookup returns the codec registered for the base type of the mime type
+func Lookup(mime MimeType) (Codec, bool) {
+	codecs.mu.RLock()
+	defer codecs.mu.RUnlock()
+
+	c, ok := codecs.codecs[mime.BaseType()]
+	return c, ok
+}
+
+// EncodeAs encodes the value with the codec of the mime type, raw bytes are returned as is and values of mime
+// types without a codec are encoded as json
+func EncodeAs(v any, mime MimeType) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	if c, ok := Lookup(mime); ok {
+		return c.Encode(v)
+	}
+	return json.Marshal(v)
+}
+
+/************************************************************************/
+// BUILT-IN CODECS
+/************************************************************************/
+
+func (jsonCodec) Encode(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, target any) error {
+	return json.Unmarshal(data, target)
+}
+
+func (yamlCodec) Encode(v any) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (yamlCodec) Decode(data []byte, target any) error {
+	return yaml.Unmarshal(data, target)
+}
+
+func (protobufCodec) Encode(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidProtobufType, v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Decode(data []byte, target any) error {
+	m, ok := target.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrInvalidProtobufType, target)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Encode uses the json tags of structs so values encode the same way they do as json
+func (msgpackCodec) Encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte, target any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(target)
+}
@@ -0,0 +1,149 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type registryTestRow struct {
+	Name    string    `json:"name" yaml:"name" csv:"name"`
+	Count   int       `json:"count" yaml:"count" csv:"count"`
+	Score   float64   `json:"score" yaml:"score" csv:"score"`
+	Active  bool      `json:"active" yaml:"active" csv:"active"`
+	Created time.Time `json:"created" yaml:"created" csv:"created"`
+	Note    *string   `json:"note,omitempty" yaml:"note,omitempty" csv:"note"`
+	Skipped string    `json:"-" yaml:"-" csv:"-"`
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Encode(v any) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Decode(data []byte, target any) error {
+	*target.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	note := "hello"
+	rows := []registryTestRow{
+		{Name: "a", Count: 1, Score: 1.5, Active: true, Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Note: &note},
+		{Name: "b, with comma", Count: 2, Created: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)},
+	}
+
+	for _, mime := range []MimeType{MimeTypeJson, MimeTypeYaml, MimeTypeMsgPack, MimeTypeCsv} {
+		t.Run(string(mime), func(t *testing.T) {
+			b, err := EncodeAs(rows, mime)
+			require.NoError(t, err)
+
+			var out []registryTestRow
+			require.NoError(t, DecodeAndBind(b, mime, &out))
+			// msgpack decodes times in the local time zone
+			for i := range out {
+				out[i].Created = out[i].Created.UTC()
+			}
+			assert.Equal(t, rows, out)
+		})
+	}
+}
+
+func TestRegistryCodecs(t *testing.T) {
+	t.Run("protobuf", func(t *testing.T) {
+		b, err := EncodeAs(wrapperspb.String("hello"), MimeTypeProtobuf)
+		require.NoError(t, err)
+
+		out := &wrapperspb.StringValue{}
+		require.NoError(t, DecodeAndBind(b, MimeTypeProtobuf, out))
+		assert.Equal(t, "hello", out.GetValue())
+	})
+
+	t.Run("protobuf requires messages", func(t *testing.T) {
+		_, err := EncodeAs("hello", MimeTypeProtobuf)
+		assert.ErrorIs(t, err, ErrInvalidProtobufType)
+
+		var out string
+		assert.ErrorIs(t, DecodeAndBind([]byte{0x0a}, MimeTypeProtobuf, &out), ErrInvalidProtobufType)
+	})
+
+	t.Run("msgpack uses json tags", func(t *testing.T) {
+		b, err := EncodeAs(registryTestRow{Name: "a"}, MimeTypeMsgPack)
+		require.NoError(t, err)
+
+		var out map[string]any
+		require.NoError(t, DecodeAndBind(b, MimeTypeMsgPack, &out))
+		assert.Equal(t, "a", out["name"])
+	})
+
+	t.Run("csv into maps", func(t *testing.T) {
+		var out any
+		require.NoError(t, DecodeAndBind([]byte("name,count\na,1\nb,2\n"), MimeTypeCsv, &out))
+		assert.Equal(t, []map[string]string{{"name": "a", "count": "1"}, {"name": "b", "count": "2"}}, out)
+
+		b, err := EncodeAs(out, MimeTypeCsv)
+		require.NoError(t, err)
+		assert.Equal(t, "count,name\n1,a\n2,b\n", string(b))
+	})
+
+	t.Run("csv into records", func(t *testing.T) {
+		var out [][]string
+		require.NoError(t, DecodeAndBind([]byte("name,count\na,1\n"), MimeTypeCsv, &out))
+		assert.Equal(t, [][]string{{"name", "count"}, {"a", "1"}}, out)
+	})
+
+	t.Run("csv matches columns case insensitive and ignores unknown columns", func(t *testing.T) {
+		var out []*registryTestRow
+		require.NoError(t, DecodeAndBind([]byte("NAME,unknown,Count\na,x,3\n"), MimeTypeCsv, &out))
+		require.Len(t, out, 1)
+		assert.Equal(t, "a", out[0].Name)
+		assert.Equal(t, 3, out[0].Count)
+	})
+
+	t.Run("csv reports invalid values", func(t *testing.T) {
+		var out []registryTestRow
+		assert.ErrorContains(t, DecodeAndBind([]byte("count\nabc\n"), MimeTypeCsv, &out), "csv line 2, column count")
+	})
+
+	t.Run("csv requires slices", func(t *testing.T) {
+		_, err := EncodeAs(registryTestRow{}, MimeTypeCsv)
+		assert.ErrorIs(t, err, ErrInvalidCsvType)
+
+		var out registryTestRow
+		assert.ErrorIs(t, DecodeAndBind([]byte("name\na\n"), MimeTypeCsv, &out), ErrInvalidCsvType)
+	})
+
+	t.Run("raw bytes are not encoded", func(t *testing.T) {
+		b, err := EncodeAs([]byte("raw"), MimeTypeYaml)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("raw"), b)
+	})
+
+	t.Run("unknown mime types use json", func(t *testing.T) {
+		b, err := EncodeAs(map[string]string{"foo": "bar"}, "application/unknown")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"foo":"bar"}`, string(b))
+	})
+}
+
+func TestRegister(t *testing.T) {
+	mime := MimeType("application/x-upper")
+	Register(mime.WithType("v1"), upperCodec{})
+
+	c, ok := Lookup(mime)
+	require.True(t, ok, "codecs must be registered by their base type")
+	assert.Equal(t, upperCodec{}, c)
+
+	b, err := EncodeAs("hello", mime)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(b))
+
+	var out string
+	require.NoError(t, DecodeAndBind(b, mime, &out))
+	assert.Equal(t, "hello", out)
+}
@@ -1,6 +1,9 @@
 package connectorv1
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 /************************************************************************/
 // CONFIGURATION
@@ -57,6 +60,42 @@ type (
 	Forwarder interface {
 		Forward(name string, body []byte, headers Headers, opts ...ForwardOption) (InboundResponse, error)
 	}
+
+	// AsyncForwarder a Forwarder that queues messages, the Forwarder of the StartContext implements it and can be
+	// asserted with ctx.Forwarder().(AsyncForwarder)
+	AsyncForwarder interface {
+		Forwarder
+		// ForwardAsync returns once the message is queued, or written to the buffer of the agent config when it is
+		// set, the message is delivered in the background and the result is passed to WithDeliveryCallback.
+		// Messages that are delivered from the buffer after a restart are sent with the timeout and retry of the
+		// agent config, their ForwardOptions for the timeout, the retry and the delivery callback are lost. On
+		// shutdown the queue is drained for up to the drain timeout of the agent config, messages that are still
+		// not delivered stay in the buffer or, without a buffer, are dropped and fail with ErrForwardQueueClosed
+		ForwardAsync(name string, body []byte, headers Headers, opts ...ForwardOption) error
+		// HasBuffer reports whether ForwardAsync writes messages to the buffer of the agent config before it returns,
+		// without a buffer queued messages are lost when the connector crashes
+		HasBuffer() bool
+		Stats() ForwarderStats
+	}
+
+	// DeliveryFunc receives the response of a message forwarded with ForwardAsync or the error if it can not be
+	// delivered
+	DeliveryFunc func(resp InboundResponse, err error)
+
+	// ForwarderStats the queue of ForwardAsync, Buffered is the number of messages in the buffer that are not
+	// delivered yet
+	ForwarderStats struct {
+		QueueDepth    int    `json:"queue_depth"`
+		QueueCapacity int    `json:"queue_capacity"`
+		Buffered      int    `json:"buffered"`
+		Delivered     uint64 `json:"delivered"`
+		Failed        uint64 `json:"failed"`
+	}
+)
+
+var (
+	ErrForwardQueueFull   = errors.New("forward queue is full")
+	ErrForwardQueueClosed = errors.New("forward queue is closed")
 )
 
 type HttpError struct {
@@ -154,5 +193,8 @@ type InboundConnector interface {
 
 type OutboundConnector interface {
 	Connector
+	// HandleOutboundRequest is called for the requests the agent sends to the outbound endpoint of the connector,
+	// bytes are returned to the agent with the mime type of the Content-Type header, other bodies are returned as
+	// json and an HttpError is returned with its status code
 	HandleOutboundRequest(request OutboundRequest) (any, Headers, error) // see line 26, please
 }
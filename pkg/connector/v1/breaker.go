@@ -0,0 +1,83 @@
+package connectorv1
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("forwarder circuit breaker is open, the agent is unavailable")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker opens once threshold attempts in a row failed, attempts fail fast while it is open and a single
+// trial attempt is allowed once the open timeout passed, the circuit closes again when the trial succeeds
+type circuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	openTimeout time.Duration
+	failures    int
+	state       circuitState
+	openedAt    time.Time
+	now         func() time.Time
+}
+
+// allow reports whether an attempt can be made, a nil circuit breaker always allows attempts
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// only the trial attempt is allowed until it completed
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.state = 0, circuitClosed
+}
+
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = circuitOpen, b.now()
+	}
+}
+
+func newCircuitBreaker(cfg agentCircuitBreaker) *circuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: cfg.FailureThreshold, openTimeout: cfg.OpenTimeout, now: time.Now}
+}
@@ -0,0 +1,236 @@
+package connectorv1
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sync"
+)
+
+const (
+	bufferFileName = "forward.buffer"
+	// bufferCompactRecords the buffer is compacted once it has at least this many records and more than twice as
+	// many records as pending messages
+	bufferCompactRecords = 1000
+)
+
+// forwardBuffer a write-ahead buffer of the messages of ForwardAsync, each line of the file is a record that adds
+// or removes a message. Messages are synced to disk before they are queued, the file is truncated once every
+// buffered message was delivered and compacted to the pending messages when most of its records are stale so it
+// does not grow while the agent never catches up completely
+type forwardBuffer struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	seq     uint64
+	pending map[uint64]*bufferedMessage
+	records int
+}
+
+// bufferRecord adds the message with the sequence number or removes it once it was delivered
+type bufferRecord struct {
+	Seq       uint64           `json:"seq"`
+	Delivered bool             `json:"delivered,omitempty"`
+	Message   *bufferedMessage `json:"message,omitempty"`
+}
+
+// bufferedMessage the message and the settings of the forwarder that are kept after a restart
+type bufferedMessage struct {
+	*forwardData
+	IdempotencyKey string `json:"idempotency_key"`
+	NonIdempotent  bool   `json:"non_idempotent,omitempty"`
+}
+
+// append writes the message to the buffer and returns its sequence number once it is synced to disk
+func (b *forwardBuffer) append(req *forwardData) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seq := b.seq + 1
+	m := &bufferedMessage{forwardData: req, IdempotencyKey: req.idempotencyKey, NonIdempotent: req.nonIdempotent}
+	err := b.write(bufferRecord{Seq: seq, Message: m})
+	if err == nil {
+		err = b.file.Sync()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	b.seq = seq
+	b.pending[seq] = m
+	return seq, nil
+}
+
+// remove marks the message as delivered, the record is not synced because a message that is delivered again after
+// a crash is discarded by the agent with its idempotency key
+func (b *forwardBuffer) remove(seq uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.write(bufferRecord{Seq: seq, Delivered: true}); err != nil {
+		return err
+	}
+	delete(b.pending, seq)
+
+	switch {
+	case len(b.pending) == 0:
+		b.records = 0
+		return b.file.Truncate(0)
+	case b.records >= bufferCompactRecords && b.records > 2*len(b.pending):
+		return b.compact()
+	default:
+		return nil
+	}
+}
+
+// compact rewrites the file with the records of the pending messages in the order they were buffered, the new file
+// replaces the old one once it is synced so a crash leaves either of them behind
+func (b *forwardBuffer) compact() error {
+	seqs := make([]uint64, 0, len(b.pending))
+	for seq := range b.pending {
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+
+	tmp, err := os.Create(b.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, seq := range seqs {
+		err = errors.Join(err, writeBufferRecord(w, bufferRecord{Seq: seq, Message: b.pending[seq]}))
+	}
+	if err = errors.Join(err, w.Flush(), tmp.Sync(), tmp.Close()); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if b.file != nil {
+		_ = b.file.Close()
+	}
+	b.file, b.records = file, len(seqs)
+	return nil
+}
+
+func (b *forwardBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+func (b *forwardBuffer) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.file.Close()
+}
+
+func (b *forwardBuffer) write(r bufferRecord) error {
+	if err := writeBufferRecord(b.file, r); err != nil {
+		return err
+	}
+	b.records++
+	return nil
+}
+
+func writeBufferRecord(w io.Writer, r bufferRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// openForwardBuffer opens the buffer in the directory and returns the messages that were not delivered before
+// the buffer was closed in the order they were buffered, the buffer is compacted to these messages
+func openForwardBuffer(dir string) (*forwardBuffer, []*queuedMessage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	file := path.Join(dir, bufferFileName)
+	recovered, err := readForwardBuffer(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// rewrite the pending messages so the buffer does not grow across restarts
+	b := &forwardBuffer{path: file, pending: make(map[uint64]*bufferedMessage, len(recovered))}
+	for _, m := range recovered {
+		b.pending[m.seq] = &bufferedMessage{
+			forwardData:    m.req,
+			IdempotencyKey: m.req.idempotencyKey,
+			NonIdempotent:  m.req.nonIdempotent,
+		}
+		b.seq = max(b.seq, m.seq)
+	}
+	if err := b.compact(); err != nil {
+		return nil, nil, err
+	}
+	return b, recovered, nil
+}
+
+// readForwardBuffer reads the records of the buffer, a record that can not be decoded was not written completely
+// and ends the buffer
+func readForwardBuffer(file string) ([]*queuedMessage, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		messages []*queuedMessage
+		index    = map[uint64]int{}
+		r        = bufio.NewReader(f)
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// json can not allocate the embedded message because its type is not exported
+		record := bufferRecord{Message: &bufferedMessage{forwardData: &forwardData{}}}
+		if err := json.Unmarshal(line, &record); err != nil {
+			break
+		}
+
+		switch {
+		case record.Delivered:
+			if i, ok := index[record.Seq]; ok {
+				messages[i] = nil
+			}
+		case record.Seq > 0:
+			req := record.Message.forwardData
+			req.idempotencyKey, req.nonIdempotent = record.Message.IdempotencyKey, record.Message.NonIdempotent
+			index[record.Seq] = len(messages)
+			messages = append(messages, &queuedMessage{seq: record.Seq, req: req})
+		}
+	}
+
+	pending := messages[:0]
+	for _, m := range messages {
+		if m != nil {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
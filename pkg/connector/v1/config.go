@@ -3,9 +3,9 @@ package connectorv1
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 	"github.com/sethvargo/go-envconfig"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -34,6 +34,8 @@ type connectorConfig struct {
 	Agent         *agent        `yaml:"agent"`
 	Health        *configHealth `yaml:"health"`
 	Log           *configLog    `yaml:"logging"`
+	// Outbound the endpoint the agent sends outbound requests to, see OutboundConnector
+	Outbound *configOutbound `yaml:"outbound"`
 }
 
 type ingressConfig struct {
@@ -74,6 +76,24 @@ type configHealth struct {
 	Interval time.Duration `yaml:"interval"`
 }
 
+type configOutbound struct {
+	Enabled bool   `yaml:"enabled"`
+	Bind    string `yaml:"bind"`
+	Port    int    `yaml:"port"`
+	Path    string `yaml:"path"`
+}
+
+func (o configOutbound) address() string {
+	return fmt.Sprintf("%s:%d", o.Bind, o.Port)
+}
+
+func (o configOutbound) path() string {
+	if o.Path == "" {
+		return defaultOutboundPath
+	}
+	return o.Path
+}
+
 type configLog struct {
 	Level string `env:"LOG_LEVEL" yaml:"level"`
 }
@@ -85,12 +105,119 @@ type agent struct {
 	Forwarder struct {
 		Path string `yaml:"path"`
 	} `yaml:"forwarder"`
+	// Timeout bounds each attempt to forward a message to the agent
+	Timeout        time.Duration        `env:"AGENT_TIMEOUT" yaml:"timeout"`
+	Retry          *agentRetry          `yaml:"retry"`
+	CircuitBreaker *agentCircuitBreaker `yaml:"circuit_breaker"`
+	Async          *agentAsync          `yaml:"async"`
+}
+
+// agentRetry retries forwarded messages with an exponential backoff, Times is the number of retries after the
+// first attempt. Messages are not retried unless a retry is configured
+type agentRetry struct {
+	Times             uint          `yaml:"times"`
+	FirstBackoffWait  time.Duration `yaml:"first_backoff_wait"`
+	BackoffMultiplier float64       `yaml:"backoff_multiplier"`
+	MaxBackoffWait    time.Duration `yaml:"max_backoff_wait"`
+}
+
+// agentCircuitBreaker fails forwarded messages fast once FailureThreshold attempts in a row failed, a trial
+// message is forwarded after OpenTimeout. The circuit breaker is disabled unless a positive threshold is configured
+type agentCircuitBreaker struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+}
+
+// agentAsync queues the messages forwarded with ForwardAsync, messages are written to the buffer in BufferDir
+// before ForwardAsync returns when it is set. Batches are sent to BatchPath when it is set and message by message
+// otherwise. On shutdown the queued messages are delivered for up to DrainTimeout
+type agentAsync struct {
+	QueueSize    int           `yaml:"queue_size"`
+	BatchSize    int           `yaml:"batch_size"`
+	BatchWait    time.Duration `yaml:"batch_wait"`
+	BatchPath    string        `yaml:"batch_path"`
+	BufferDir    string        `yaml:"buffer_dir"`
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+func (a *agent) timeout() time.Duration {
+	if a != nil && a.Timeout > 0 {
+		return a.Timeout
+	}
+	return defaultAgentTimeout
+}
+
+func (a *agent) retry() agentRetry {
+	r := agentRetry{
+		FirstBackoffWait:  defaultAgentFirstBackoffWait,
+		BackoffMultiplier: defaultAgentBackoffMultiplier,
+		MaxBackoffWait:    defaultAgentMaxBackoffWait,
+	}
+	if a == nil || a.Retry == nil {
+		return r
+	}
+
+	r.Times = a.Retry.Times
+	if a.Retry.FirstBackoffWait > 0 {
+		r.FirstBackoffWait = a.Retry.FirstBackoffWait
+	}
+	if a.Retry.BackoffMultiplier > 0 {
+		r.BackoffMultiplier = a.Retry.BackoffMultiplier
+	}
+	if a.Retry.MaxBackoffWait > 0 {
+		r.MaxBackoffWait = a.Retry.MaxBackoffWait
+	}
+	return r
+}
+
+func (a *agent) circuitBreaker() agentCircuitBreaker {
+	cb := agentCircuitBreaker{OpenTimeout: defaultAgentOpenTimeout}
+	if a == nil || a.CircuitBreaker == nil {
+		return cb
+	}
+
+	cb.FailureThreshold = a.CircuitBreaker.FailureThreshold
+	if a.CircuitBreaker.OpenTimeout > 0 {
+		cb.OpenTimeout = a.CircuitBreaker.OpenTimeout
+	}
+	return cb
+}
+
+func (a *agent) async() agentAsync {
+	as := agentAsync{
+		QueueSize:    defaultAsyncQueueSize,
+		BatchSize:    defaultAsyncBatchSize,
+		BatchWait:    defaultAsyncBatchWait,
+		DrainTimeout: defaultAsyncDrainTimeout,
+	}
+	if a == nil || a.Async == nil {
+		return as
+	}
+
+	if a.Async.QueueSize > 0 {
+		as.QueueSize = a.Async.QueueSize
+	}
+	if a.Async.BatchSize > 0 {
+		as.BatchSize = a.Async.BatchSize
+	}
+	if a.Async.BatchWait > 0 {
+		as.BatchWait = a.Async.BatchWait
+	}
+	if a.Async.DrainTimeout > 0 {
+		as.DrainTimeout = a.Async.DrainTimeout
+	}
+	as.BatchPath, as.BufferDir = a.Async.BatchPath, a.Async.BufferDir
+	return as
 }
 
 func (a agent) forwarderURL() string {
 	return fmt.Sprintf("http://%s:%d%s", a.Host, a.Port, a.Forwarder.Path)
 }
 
+func (a agent) batchURL(path string) string {
+	return fmt.Sprintf("http://%s:%d%s", a.Host, a.Port, path)
+}
+
 func loadConnectorConfig(opts *ConnectorOpts) (*Config, error) {
 	config := &Config{}
 
@@ -273,12 +400,17 @@ func loadUserConfig(opts *ConnectorOpts) (Bindable, error) {
 // Bindable
 /************************************************************************/
 
+// BindableType the format of the data of a Bindable, it is either one of the BindableType constants, a file
+// extension or a mime type with a codec registered in the codec package
 type BindableType string
 
 const (
-	BindableTypeJson    = "json"
-	BindableTypeYaml    = "yaml"
-	BindableTypeUnknown = ""
+	BindableTypeJson     = "json"
+	BindableTypeYaml     = "yaml"
+	BindableTypeCsv      = "csv"
+	BindableTypeMsgPack  = "msgpack"
+	BindableTypeProtobuf = "x-protobuf"
+	BindableTypeUnknown  = ""
 )
 
 type bindable struct {
@@ -295,13 +427,27 @@ func (r *bindable) Bind(target any) error {
 		return fmt.Errorf("expected bind parameter to be ptr; is %s", reflect.ValueOf(target).Kind().String())
 	}
 
-	switch r.dataType {
-	case BindableTypeYaml:
-		return yaml.Unmarshal(r.data, target)
+	c, ok := codec.Lookup(r.dataType.mimeType())
+	if !ok {
+		return fmt.Errorf("can not load config, unsupported extension: %s", r.dataType)
+	}
+	return c.Decode(r.data, target)
+}
+
+func (t BindableType) mimeType() codec.MimeType {
+	switch t {
 	case BindableTypeJson, BindableTypeUnknown:
-		return json.Unmarshal(r.data, target)
+		return codec.MimeTypeJson
+	case BindableTypeYaml, "yml":
+		return codec.MimeTypeYaml
+	case BindableTypeCsv:
+		return codec.MimeTypeCsv
+	case BindableTypeMsgPack:
+		return codec.MimeTypeMsgPack
+	case BindableTypeProtobuf:
+		return codec.MimeTypeProtobuf
 	default:
-		return fmt.Errorf("can not load config, unsupported extension: %s", r.dataType)
+		return codec.MimeType(t)
 	}
 }
 
@@ -2,6 +2,7 @@ package connectorv1
 
 import (
 	"fmt"
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -30,6 +31,15 @@ func verifyConnectorConfig(t *testing.T, conf *Config) {
 
 		assert.Equal(t, "127.0.0.1", conf.ConnectorConfig.Agent.Host)
 		assert.Equal(t, 8031, conf.ConnectorConfig.Agent.Port)
+		assert.Equal(t, time.Second*10, conf.ConnectorConfig.Agent.timeout())
+		assert.Equal(t, agentRetry{
+			Times:             5,
+			FirstBackoffWait:  time.Millisecond * 50,
+			BackoffMultiplier: 1.5,
+			MaxBackoffWait:    defaultAgentMaxBackoffWait,
+		}, conf.ConnectorConfig.Agent.retry())
+		assert.Equal(t, agentCircuitBreaker{FailureThreshold: 10, OpenTimeout: defaultAgentOpenTimeout},
+			conf.ConnectorConfig.Agent.circuitBreaker())
 
 		assert.Equal(t, "info", conf.ConnectorConfig.Log.Level)
 	})
@@ -47,7 +57,7 @@ func TestLoadConnectorConfigFromEnvPathFileName(t *testing.T) {
 	verifyConnectorConfig(t, conf)
 }
 
-const connectorConfigSecret = "aW5ncmVzczoKICAtIG5hbWU6ICJodHRwLTgwODAiCiAgICBlbmFibGVkOiB0cnVlCiAgICB0eXBlOiBodHRwCiAgICBiaW5kOgogICAgICBob3N0OiAwLjAuMC4wCiAgICAgIHBvcnQ6IDgwODAKICAgIGVuZHBvaW50OgogICAgICBob3N0OiBzb21lLWV4dGVybmFsLXVybC5jb20KICAgICAgcG9ydDogNDQzCiAgICAgIHBhdGg6IC92MS9lbmRwb2ludC8xMjM1NgogICAgICBwcm90b2NvbDogaHR0cHMKY29uZmlnOgogIGlkOiBjb25uZWN0b3Itc2ltcGxlLWV4YW1wbGVfMTIzNDUKICBuYW1lOiBjb25uZWN0b3Itc2ltcGxlLWV4YW1wbGUKICB0ZW5hbnQ6IHRlbmFudC1pZAogIGFyY19pZDogYXJjLWlkCiAgZW52aXJvbm1lbnRfaWQ6IGVudi1pZAogIHN0YWdlX2lkOiBzdGctaWQKICBoZWFsdGg6CiAgICBlbmFibGVkOiBmYWxzZQogICAgYmluZDogMC4wLjAuMAogICAgcG9ydDogODA4MQogICAgaW50ZXJ2YWw6IDMwcwogIGFnZW50OgogICAgaG9zdDogMTI3LjAuMC4xCiAgICBwb3J0OiA4MDMxCiAgICBmb3J3YXJkZXI6CiAgICAgIHBhdGg6IC92MS9jb25uZWN0b3ItZm9yd2FyZGVkLW1lc3NhZ2UKICBsb2dnaW5nOgogICAgbGV2ZWw6ICJpbmZvIgo="
+const connectorConfigSecret = "aW5ncmVzczoKICAtIG5hbWU6ICJodHRwLTgwODAiCiAgICBlbmFibGVkOiB0cnVlCiAgICB0eXBlOiBodHRwCiAgICBiaW5kOgogICAgICBob3N0OiAwLjAuMC4wCiAgICAgIHBvcnQ6IDgwODAKICAgIGVuZHBvaW50OgogICAgICBob3N0OiBzb21lLWV4dGVybmFsLXVybC5jb20KICAgICAgcG9ydDogNDQzCiAgICAgIHBhdGg6IC92MS9lbmRwb2ludC8xMjM1NgogICAgICBwcm90b2NvbDogaHR0cHMKY29uZmlnOgogIGlkOiBjb25uZWN0b3Itc2ltcGxlLWV4YW1wbGVfMTIzNDUKICBuYW1lOiBjb25uZWN0b3Itc2ltcGxlLWV4YW1wbGUKICB0ZW5hbnQ6IHRlbmFudC1pZAogIGFyY19pZDogYXJjLWlkCiAgZW52aXJvbm1lbnRfaWQ6IGVudi1pZAogIHN0YWdlX2lkOiBzdGctaWQKICBoZWFsdGg6CiAgICBlbmFibGVkOiBmYWxzZQogICAgYmluZDogMC4wLjAuMAogICAgcG9ydDogODA4MQogICAgaW50ZXJ2YWw6IDMwcwogIGFnZW50OgogICAgaG9zdDogMTI3LjAuMC4xCiAgICBwb3J0OiA4MDMxCiAgICBmb3J3YXJkZXI6CiAgICAgIHBhdGg6IC92MS9jb25uZWN0b3ItZm9yd2FyZGVkLW1lc3NhZ2UKICAgIHRpbWVvdXQ6IDEwcwogICAgcmV0cnk6CiAgICAgIHRpbWVzOiA1CiAgICAgIGZpcnN0X2JhY2tvZmZfd2FpdDogNTBtcwogICAgICBiYWNrb2ZmX211bHRpcGxpZXI6IDEuNQogICAgY2lyY3VpdF9icmVha2VyOgogICAgICBmYWlsdXJlX3RocmVzaG9sZDogMTAKICBsb2dnaW5nOgogICAgbGV2ZWw6ICJpbmZvIgo="
 
 func TestLoadConnectorConfigFromEnvVarSecret(t *testing.T) {
 	_ = os.Setenv("CONNECTOR_SECRET", connectorConfigSecret)
@@ -181,3 +191,37 @@ func TestLoadUserConfigFromOptionsBasePath(t *testing.T) {
 
 	verifyUserConfig(t, conf)
 }
+
+func TestBindableCodecs(t *testing.T) {
+	type userConfig struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	expected := userConfig{Name: "user config", Value: "user-defined config"}
+
+	tests := []struct {
+		name string
+		tp   BindableType
+		mime codec.MimeType
+	}{
+		{name: "msgpack", tp: BindableTypeMsgPack, mime: codec.MimeTypeMsgPack},
+		{name: "mime type", tp: BindableType(codec.MimeTypeMsgPack), mime: codec.MimeTypeMsgPack},
+		{name: "yml extension", tp: "yml", mime: codec.MimeTypeYaml},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := codec.EncodeAs(expected, test.mime)
+			assert.NoError(t, err)
+
+			var conf userConfig
+			assert.NoError(t, NewBindable(data, test.tp).Bind(&conf))
+			assert.Equal(t, expected, conf)
+		})
+	}
+
+	t.Run("unsupported type", func(t *testing.T) {
+		var conf userConfig
+		assert.ErrorContains(t, NewBindable([]byte("x"), "toml").Bind(&conf), "unsupported extension: toml")
+	})
+}
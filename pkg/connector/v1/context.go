@@ -10,13 +10,14 @@ import (
 /************************************************************************/
 
 type startContext struct {
-	userConfig         Bindable
-	inboundDescriptors []messageDescriptor
-	logger             Logger
-	forwarder          Forwarder
-	health             healthChecker
-	healthConfig       *configHealth
-	ingress            []ingressConfig
+	userConfig          Bindable
+	inboundDescriptors  []messageDescriptor
+	outboundDescriptors []messageDescriptor
+	logger              Logger
+	forwarder           Forwarder
+	health              healthChecker
+	healthConfig        *configHealth
+	ingress             []ingressConfig
 }
 
 func (c *startContext) Ingress(name string) (Ingress, error) {
@@ -37,8 +38,11 @@ func (c *startContext) InboundDescriptors() []InboundDescriptor {
 }
 
 func (c *startContext) OutboundDescriptors() []OutboundDescriptor {
-	//TODO implement me
-	return nil
+	descriptors := make([]OutboundDescriptor, len(c.outboundDescriptors))
+	for i := range c.outboundDescriptors {
+		descriptors[i] = c.outboundDescriptors[i]
+	}
+	return descriptors
 }
 
 func (c *startContext) Forwarder() Forwarder {
@@ -2,13 +2,47 @@ package connectorv1
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/google/uuid"
+)
+
+const (
+	agentTokenHeader  = "X-Token"
+	idempotencyHeader = "X-Idempotency-Key"
 )
 
-const agentTokenHeader = "X-Token"
+// payloadEncoding the encoding of the payload of forwarded messages, json payloads are not encoded
+type payloadEncoding string
+
+const (
+	payloadEncodingNone   payloadEncoding = ""
+	payloadEncodingBase64 payloadEncoding = "base64"
+)
+
+var ErrInvalidPayload = errors.New("invalid payload")
+
+const (
+	defaultAgentTimeout           = 30 * time.Second
+	defaultAgentFirstBackoffWait  = 100 * time.Millisecond
+	defaultAgentBackoffMultiplier = 2
+	defaultAgentMaxBackoffWait    = 5 * time.Second
+	defaultAgentOpenTimeout       = 30 * time.Second
+	defaultAsyncQueueSize         = 1000
+	defaultAsyncBatchSize         = 50
+	defaultAsyncBatchWait         = 100 * time.Millisecond
+	defaultAsyncDrainTimeout      = 30 * time.Second
+)
 
 type requestDoer interface {
 	Do(req *http.Request) (*http.Response, error)
@@ -20,6 +54,10 @@ type forwarder struct {
 	httpClient requestDoer
 	config     *connectorConfig
 	logger     Logger
+	timeout    time.Duration
+	retry      agentRetry
+	breaker    *circuitBreaker
+	queue      *forwardQueue
 }
 
 type forwardData struct {
@@ -31,11 +69,37 @@ type forwardData struct {
 	StageID          string            `json:"stage_id"`
 	RequestTimeoutMs int               `json:"request_timeout_ms"`
 	HeadersMap       map[string]string `json:"headers"`
+	Mime             string            `json:"mime_type,omitempty"`
+	Encoding         payloadEncoding   `json:"payload_encoding,omitempty"`
 	Payload          json.RawMessage   `json:"payload"`
+
+	// body the decoded payload of a response
+	body []byte
+
+	// settings of the forwarder that can be changed for each message
+	timeout        time.Duration
+	retry          agentRetry
+	idempotencyKey string
+	nonIdempotent  bool
+	onDelivery     DeliveryFunc
+}
+
+// batchMessage a message of a batch, the idempotency key of each message is part of the batch
+type batchMessage struct {
+	*forwardData
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type batchRequest struct {
+	Messages []batchMessage `json:"messages"`
+}
+
+type batchResponse struct {
+	Responses []forwardData `json:"responses"`
 }
 
 func (f forwardData) Body() Bindable {
-	return NewBindable(f.Payload, BindableTypeJson)
+	return NewBindable(f.body, bindableMimeType(f.Mime))
 }
 
 func (f forwardData) Headers() Headers {
@@ -46,75 +110,352 @@ func (f forwardData) MessageName() string {
 	return f.MsgName
 }
 
-func (f *forwarder) Forward(name string, body []byte, headers Headers, opts ...ForwardOption) (InboundResponse, error) {
-	// TODO: Body must be JSON object for now but we must change to bytes after agent update
-	if len(body) > 0 {
-		if err := json.Unmarshal(body, &map[string]any{}); err != nil {
-			f.logger.Error(err, "request body must be a valid json object")
-			return nil, errors.New("request body must be a valid json object")
+// MimeType of the payload, payloads without a mime type are json
+func (f forwardData) MimeType() string {
+	if f.Mime == "" {
+		return string(codec.MimeTypeJson)
+	}
+	return f.Mime
+}
+
+// encodePayload sets the body as the payload, see encodePayload
+func (f *forwardData) encodePayload(body []byte) (err error) {
+	f.Payload, f.Mime, f.Encoding, err = encodePayload(f.Mime, body)
+	return err
+}
+
+// decodePayload decodes the payload of a response into its body
+func (f *forwardData) decodePayload() (err error) {
+	f.body, err = decodePayload(f.Encoding, f.Payload)
+	return err
+}
+
+// encodePayload returns the payload, mime type and payload encoding of a body that is exchanged with the agent,
+// json is sent as it is and other formats are base64 encoded. Bodies without a mime type are json if they are
+// valid json and binary otherwise
+func encodePayload(mime string, body []byte) (json.RawMessage, string, payloadEncoding, error) {
+	if len(body) == 0 {
+		return nil, mime, payloadEncodingNone, nil
+	}
+
+	switch {
+	case mime == "" && json.Valid(body):
+		return body, mime, payloadEncodingNone, nil
+	case mime == "":
+		mime = string(codec.MimeTypeOctetStream)
+	case isJsonMimeType(mime):
+		if !json.Valid(body) {
+			return nil, mime, payloadEncodingNone, fmt.Errorf("%w: %s", ErrInvalidPayload, mime)
 		}
+		return body, mime, payloadEncodingNone, nil
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, mime, payloadEncodingNone, err
+	}
+	return payload, mime, payloadEncodingBase64, nil
+}
+
+// decodePayload returns the body of a payload that was exchanged with the agent
+func decodePayload(encoding payloadEncoding, payload json.RawMessage) ([]byte, error) {
+	switch encoding {
+	case payloadEncodingNone:
+		return payload, nil
+	case payloadEncodingBase64:
+		var body []byte
+		err := json.Unmarshal(payload, &body)
+		return body, err
+	default:
+		return nil, fmt.Errorf("%w: unknown payload encoding (%s)", ErrInvalidPayload, encoding)
+	}
+}
+
+// bindableMimeType the mime type of a body without its parameters, bodies without a mime type are json
+func bindableMimeType(mime string) BindableType {
+	if mime == "" {
+		return BindableTypeJson
 	}
+	mime, _, _ = strings.Cut(mime, ";")
+	return BindableType(strings.TrimSpace(mime))
+}
+
+// isJsonMimeType reports whether the mime type is json or a json based format such as application/vnd.api+json
+func isJsonMimeType(mime string) bool {
+	mime, _, _ = strings.Cut(mime, ";")
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	return codec.MimeType(mime).BaseType() == codec.MimeTypeJson || strings.HasSuffix(mime, "+json")
+}
+
+// Forward sends the message to the agent, messages are only sent once unless the agent config has a retry or the
+// message is forwarded WithRetry. Attempts that fail with a connection error or a 5xx status are retried with the
+// same idempotency key so the agent can discard duplicates, messages forwarded WithNonIdempotent are only retried
+// when they could not be sent
+func (f *forwarder) Forward(name string, body []byte, headers Headers, opts ...ForwardOption) (InboundResponse, error) {
+	req, err := f.newRequest(name, body, headers, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return f.forward(req)
+}
+
+// ForwardAsync queues the message and returns once it is queued or written to the buffer when a buffer is
+// configured, ErrForwardQueueFull is returned when the queue is full so the message can be rejected upstream. Only
+// the message, its headers, mime type and idempotency are kept in the buffer so messages that are recovered after a
+// restart use the timeout and retry of the agent config and have no delivery callback
+func (f *forwarder) ForwardAsync(name string, body []byte, headers Headers, opts ...ForwardOption) error {
+	req, err := f.newRequest(name, body, headers, opts...)
+	if err != nil {
+		return err
+	}
+	if err := f.queue.open(); err != nil {
+		return err
+	}
+	return f.queue.push(req)
+}
+
+func (f *forwarder) HasBuffer() bool {
+	return f.queue.config.BufferDir != ""
+}
+
+// Stats returns the depth of the queue of ForwardAsync and the number of messages it delivered
+func (f *forwarder) Stats() ForwarderStats {
+	return f.queue.stats()
+}
+
+// close delivers the queued messages for up to the drain timeout and closes the buffer
+func (f *forwarder) close() {
+	f.queue.close()
+}
+
+func (f *forwarder) newRequest(name string, body []byte, headers Headers, opts ...ForwardOption) (*forwardData, error) {
 	if headers == nil {
 		headers = Headers{}
 	}
-	req := forwardData{
-		Tenant:        f.config.Tenant,
-		MsgName:       name,
-		ConnectorID:   f.config.Id,
-		ArcID:         f.config.ArcID,
-		EnvironmentID: f.config.EnvironmentID,
-		StageID:       f.config.StageID,
-		HeadersMap:    headers,
-		Payload:       body,
+	req := &forwardData{
+		Tenant:         f.config.Tenant,
+		MsgName:        name,
+		ConnectorID:    f.config.Id,
+		ArcID:          f.config.ArcID,
+		EnvironmentID:  f.config.EnvironmentID,
+		StageID:        f.config.StageID,
+		HeadersMap:     headers,
+		timeout:        f.timeout,
+		retry:          f.retry,
+		idempotencyKey: uuid.NewString(),
 	}
 
 	for _, o := range opts {
-		o(&req)
+		o(req)
 	}
 
+	if err := req.encodePayload(body); err != nil {
+		f.logger.Error(err, "[forwarder] failed to encode request body")
+		return nil, err
+	}
+	return req, nil
+}
+
+func (f *forwarder) forward(req *forwardData) (InboundResponse, error) {
 	data, err := json.Marshal(req)
 	if err != nil {
 		f.logger.Error(err, "[forwarder] failed to marshal request")
 		return nil, err
 	}
-	request, err := http.NewRequest(http.MethodPost, f.config.Agent.forwarderURL(), bytes.NewReader(data))
+
+	return retry(f, req.MsgName, req.retry, func(attempt int) (InboundResponse, error) {
+		body, err := f.post(req.MsgName, attempt, f.config.Agent.forwarderURL(), req.idempotencyKey, req.nonIdempotent,
+			req.timeout, data)
+		if err != nil {
+			return nil, err
+		}
+
+		var resp forwardData
+		if err := decodeResponse(body, &resp); err != nil {
+			f.logger.Error(err, "[forwarder] failed to decode response body of %d bytes", len(body))
+			return nil, backoff.Permanent(err)
+		}
+		return resp, nil
+	})
+}
+
+// forwardBatch sends the messages in a single request to the batch path of the agent, the agent responds with
+// the responses of the messages in the same order
+func (f *forwarder) forwardBatch(path string, batch []*forwardData) ([]InboundResponse, error) {
+	messages := make([]batchMessage, len(batch))
+	nonIdempotent := false
+	timeout := time.Duration(0)
+	for i, req := range batch {
+		messages[i] = batchMessage{forwardData: req, IdempotencyKey: req.idempotencyKey}
+		nonIdempotent = nonIdempotent || req.nonIdempotent
+		timeout = max(timeout, req.timeout)
+	}
+
+	data, err := json.Marshal(batchRequest{Messages: messages})
 	if err != nil {
-		f.logger.Error(err, "[forwarder] failed to create a new request")
+		f.logger.Error(err, "[forwarder] failed to marshal batch")
 		return nil, err
 	}
+
+	key := uuid.NewString()
+	name := fmt.Sprintf("batch of %d messages", len(batch))
+	return retry(f, name, f.retry, func(attempt int) ([]InboundResponse, error) {
+		body, err := f.post(name, attempt, f.config.Agent.batchURL(path), key, nonIdempotent, timeout, data)
+		if err != nil {
+			return nil, err
+		}
+
+		var resp batchResponse
+		err = json.Unmarshal(body, &resp)
+		if err == nil && len(resp.Responses) != len(batch) {
+			err = fmt.Errorf("%w: expected %d responses, got %d", ErrInvalidPayload, len(batch), len(resp.Responses))
+		}
+		if err != nil {
+			f.logger.Error(err, "[forwarder] failed to decode batch response body of %d bytes", len(body))
+			return nil, backoff.Permanent(err)
+		}
+
+		responses := make([]InboundResponse, len(resp.Responses))
+		for i := range resp.Responses {
+			if err := resp.Responses[i].decodePayload(); err != nil {
+				return nil, backoff.Permanent(err)
+			}
+			responses[i] = resp.Responses[i]
+		}
+		return responses, nil
+	})
+}
+
+// retry calls fn with the attempt, starting at 1, until it succeeds, returns a permanent error or the retries are
+// exhausted
+func retry[T any](f *forwarder, name string, r agentRetry, fn func(attempt int) (T, error)) (T, error) {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = r.FirstBackoffWait
+	b.Multiplier = r.BackoffMultiplier
+	b.MaxInterval = r.MaxBackoffWait
+	b.MaxElapsedTime = 0
+
+	attempt := 0
+	try := func() (T, error) {
+		attempt++
+		return fn(attempt)
+	}
+	return backoff.RetryNotifyWithData(try, backoff.WithMaxRetries(b, uint64(r.Times)), func(err error, wait time.Duration) {
+		f.logger.Warn("[forwarder] failed to forward %s, retrying in %s: %s", name, wait, err)
+	})
+}
+
+// post sends the data of the named message once and returns the body of the response, errors that must not be
+// retried are permanent. Only the size of the data is logged, it may contain sensitive payloads
+func (f *forwarder) post(name string, attempt int, url, idempotencyKey string, nonIdempotent bool,
+	timeout time.Duration, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		f.logger.Error(err, "[forwarder] failed to create a new request")
+		return nil, backoff.Permanent(err)
+	}
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set(agentTokenHeader, f.config.Agent.Token)
-	f.logger.Debug("[forwarder] %s %s: %s", request.Method, request.URL.String(), string(data))
+	request.Header.Set(idempotencyHeader, idempotencyKey)
+	f.logger.Debug("[forwarder] %s %s: %s attempt %d, %d bytes", request.Method, request.URL.String(), name, attempt,
+		len(data))
+
+	if !f.breaker.allow() {
+		return nil, backoff.Permanent(ErrCircuitOpen)
+	}
 
 	response, err := f.httpClient.Do(request)
 	if err != nil {
+		f.breaker.failure()
 		f.logger.Error(err, "[forwarder] failed to do the request")
-		return nil, err
+		return nil, retryable(nonIdempotent, err, isDialError(err))
 	}
-	body, err = io.ReadAll(response.Body)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
 	if err != nil {
+		f.breaker.failure()
 		f.logger.Error(err, "[forwarder] failed to read response body")
-		return nil, err
+		return nil, retryable(nonIdempotent, err, false)
 	}
-	f.logger.Debug("[forwarder] response body: %s", string(body))
-	if response.StatusCode != http.StatusOK {
-		return nil, &HttpError{
-			HttpCode: response.StatusCode,
-			Reason:   response.Status,
-			Raw:      body,
-		}
+	f.logger.Debug("[forwarder] response to %s attempt %d: status %d, %d bytes", name, attempt, response.StatusCode,
+		len(body))
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		f.breaker.failure()
+		return nil, retryable(nonIdempotent, newHttpError(response, body), false)
 	}
-	var resp forwardData
-	err = json.Unmarshal(body, &resp)
-	if err != nil {
-		f.logger.Error(err, "[forwarder] failed to decode response body. body: %s", string(body))
-		return nil, err
+	f.breaker.success()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusTooManyRequests:
+		// the agent did not accept the message so it can be sent again
+		return nil, newHttpError(response, body)
+	default:
+		return nil, backoff.Permanent(newHttpError(response, body))
+	}
+}
+
+func decodeResponse(body []byte, resp *forwardData) error {
+	if err := json.Unmarshal(body, resp); err != nil {
+		return err
+	}
+	return resp.decodePayload()
+}
+
+// retryable returns the error as it is if the request can be sent again, requests that are not idempotent are
+// only sent again when they did not reach the agent
+func retryable(nonIdempotent bool, err error, notSent bool) error {
+	if nonIdempotent && !notSent {
+		return backoff.Permanent(err)
+	}
+	return err
+}
+
+func newHttpError(response *http.Response, body []byte) *HttpError {
+	return &HttpError{
+		HttpCode: response.StatusCode,
+		Reason:   response.Status,
+		Raw:      body,
 	}
-	return resp, nil
+}
+
+// isTemporary reports whether a request that failed after its retries can be sent again later, the same rules
+// as for the retries apply
+func isTemporary(err error, nonIdempotent bool) bool {
+	var he *HttpError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrCircuitOpen), isDialError(err):
+		return true
+	case errors.As(err, &he):
+		return he.HttpCode == http.StatusTooManyRequests ||
+			(he.HttpCode >= http.StatusInternalServerError && !nonIdempotent)
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return !nonIdempotent
+	default:
+		return false
+	}
+}
+
+// isDialError reports whether the connection to the agent could not be established
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
 func newForwarder(config *connectorConfig, opts ...forwarderOption) Forwarder {
-	fwd := forwarder{config: config}
+	fwd := forwarder{
+		config:  config,
+		timeout: config.Agent.timeout(),
+		retry:   config.Agent.retry(),
+		breaker: newCircuitBreaker(config.Agent.circuitBreaker()),
+	}
 	for _, opt := range opts {
 		fwd = opt(fwd)
 	}
@@ -124,6 +465,7 @@ func newForwarder(config *connectorConfig, opts ...forwarderOption) Forwarder {
 	if fwd.logger == nil {
 		fwd.logger = noopLogger{}
 	}
+	fwd.queue = newForwardQueue(&fwd, config.Agent.async())
 	return &fwd
 }
 
@@ -145,9 +487,71 @@ func withLogger(logger Logger) forwarderOption {
 
 // forward options
 
-// WithRequestTimeout with the timeout in ms
+// WithRequestTimeout with the timeout in ms, the timeout is sent to the agent and bounds each attempt to forward
+// the message like WithTimeout
+//
+// Deprecated: use WithTimeout
 func WithRequestTimeout(t int) ForwardOption {
 	return func(data *forwardData) {
 		data.RequestTimeoutMs = t
+		if t > 0 {
+			data.timeout = time.Duration(t) * time.Millisecond
+		}
+	}
+}
+
+// WithMimeType sets the mime type of the body, bodies that are not json are sent base64 encoded
+func WithMimeType(mimeType string) ForwardOption {
+	return func(data *forwardData) {
+		data.Mime = mimeType
+	}
+}
+
+// WithDeliveryCallback is called with the response of the agent once a message forwarded with ForwardAsync was
+// delivered or with the error if it can not be delivered. The callback is not kept in the buffer, it is not called
+// for messages that are delivered from the buffer after a restart
+func WithDeliveryCallback(fn DeliveryFunc) ForwardOption {
+	return func(data *forwardData) {
+		data.onDelivery = fn
+	}
+}
+
+// WithTimeout bounds each attempt to forward the message, it overrides the timeout of the agent config
+func WithTimeout(timeout time.Duration) ForwardOption {
+	return func(data *forwardData) {
+		data.timeout = timeout
+	}
+}
+
+// WithRetry retries the message up to times after the first attempt, the wait between attempts starts at
+// firstBackoffWait and is multiplied by backoffMultiplier for each retry
+func WithRetry(times uint, firstBackoffWait time.Duration, backoffMultiplier float64) ForwardOption {
+	return func(data *forwardData) {
+		data.retry.Times = times
+		data.retry.FirstBackoffWait = firstBackoffWait
+		data.retry.BackoffMultiplier = backoffMultiplier
+	}
+}
+
+// WithoutRetry forwards the message only once
+func WithoutRetry() ForwardOption {
+	return func(data *forwardData) {
+		data.retry.Times = 0
+	}
+}
+
+// WithIdempotencyKey sends the key to the agent instead of a random key, use it to discard duplicates of
+// messages that are forwarded more than once by the connector
+func WithIdempotencyKey(key string) ForwardOption {
+	return func(data *forwardData) {
+		data.idempotencyKey = key
+	}
+}
+
+// WithNonIdempotent only retries the message when it could not be sent to the agent, use it for messages the
+// agent must not receive twice
+func WithNonIdempotent() ForwardOption {
+	return func(data *forwardData) {
+		data.nonIdempotent = true
 	}
 }
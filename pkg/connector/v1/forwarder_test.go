@@ -2,11 +2,15 @@ package connectorv1
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"net"
 	"net/http"
 	"testing"
+	"time"
 )
 
 type mockHttpDoer struct {
@@ -43,6 +47,9 @@ func TestForward(t *testing.T) {
 		assert.Equal(t, "http://test.agent:8080/forward", req.URL.String())
 		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
 		assert.Equal(t, agentConfig.Token, req.Header.Get("X-Token"))
+		deadline, ok := req.Context().Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(234*time.Millisecond), deadline, 50*time.Millisecond)
 
 		body, err := io.ReadAll(req.Body)
 		assert.NoError(t, err)
@@ -138,3 +145,269 @@ func TestForwardWithNilHeader(t *testing.T) {
 
 	assert.Equal(t, dummyEmptyBody, respData)
 }
+
+func newRetryForwarder(t *testing.T, doer requestDoer) *forwarder {
+	t.Helper()
+	config := &connectorConfig{
+		Id: "connector-id",
+		Agent: &agent{
+			Host: "test.agent",
+			Port: 8080,
+			Retry: &agentRetry{
+				Times:            2,
+				FirstBackoffWait: time.Millisecond,
+			},
+			CircuitBreaker: &agentCircuitBreaker{FailureThreshold: 3, OpenTimeout: time.Minute},
+		},
+	}
+	return newForwarder(config, withRequestDoer(doer)).(*forwarder)
+}
+
+func newStatusResponse(status int) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Body:       io.NopCloser(bytes.NewReader([]byte(`{}`))),
+	}
+}
+
+func TestForwardRetries(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	t.Run("retries 5xx with the same idempotency key", func(t *testing.T) {
+		var keys []string
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			keys = append(keys, req.Header.Get("X-Idempotency-Key"))
+			body, err := io.ReadAll(req.Body)
+			assert.NoError(t, err)
+			assert.Contains(t, string(body), `"message_name":"test-name"`)
+			if len(keys) < 3 {
+				return newStatusResponse(http.StatusBadGateway), nil
+			}
+			return newStatusResponse(http.StatusOK), nil
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil)
+		assert.NoError(t, err)
+		assert.Len(t, keys, 3)
+		assert.NotEmpty(t, keys[0])
+		assert.Equal(t, keys[0], keys[1])
+		assert.Equal(t, keys[0], keys[2])
+	})
+
+	t.Run("returns the last error when the retries are exhausted", func(t *testing.T) {
+		attempts := 0
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return newStatusResponse(http.StatusServiceUnavailable), nil
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil, WithIdempotencyKey("key"))
+		var he *HttpError
+		assert.ErrorAs(t, err, &he)
+		assert.Equal(t, http.StatusServiceUnavailable, he.HttpCode)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("does not retry 4xx", func(t *testing.T) {
+		attempts := 0
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return newStatusResponse(http.StatusBadRequest), nil
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil)
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("retries 429", func(t *testing.T) {
+		attempts := 0
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				return newStatusResponse(http.StatusTooManyRequests), nil
+			}
+			return newStatusResponse(http.StatusOK), nil
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil, WithNonIdempotent())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("non idempotent messages are only retried when they were not sent", func(t *testing.T) {
+		attempts := 0
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, dialErr
+			}
+			return newStatusResponse(http.StatusInternalServerError), nil
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil, WithNonIdempotent())
+		assert.Error(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("without retry", func(t *testing.T) {
+		attempts := 0
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return nil, dialErr
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil, WithoutRetry())
+		assert.ErrorIs(t, err, dialErr)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("no retries and no circuit breaker by default", func(t *testing.T) {
+		attempts := 0
+		config := &connectorConfig{Id: "connector-id", Agent: &agent{Host: "test.agent", Port: 8080}}
+		fwd := newForwarder(config, withRequestDoer(mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return newStatusResponse(http.StatusServiceUnavailable), nil
+		}})).(*forwarder)
+		assert.Nil(t, fwd.breaker)
+
+		for i := 0; i < 10; i++ {
+			_, err := fwd.Forward("test-name", nil, nil)
+			assert.NotErrorIs(t, err, ErrCircuitOpen)
+		}
+		assert.Equal(t, 10, attempts)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}})
+
+		_, err := fwd.Forward("test-name", nil, nil, WithTimeout(time.Millisecond), WithoutRetry())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestForwardCircuitBreaker(t *testing.T) {
+	failing := true
+	attempts := 0
+	fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if failing {
+			return newStatusResponse(http.StatusInternalServerError), nil
+		}
+		return newStatusResponse(http.StatusOK), nil
+	}})
+	now := time.Now()
+	fwd.breaker.now = func() time.Time { return now }
+
+	// the third attempt opens the circuit
+	_, err := fwd.Forward("test-name", nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	_, err = fwd.Forward("test-name", nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 3, attempts)
+
+	// a failed trial opens the circuit again
+	now = now.Add(time.Minute)
+	_, err = fwd.Forward("test-name", nil, nil, WithoutRetry())
+	assert.Error(t, err)
+	assert.Equal(t, 4, attempts)
+	_, err = fwd.Forward("test-name", nil, nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// a successful trial closes the circuit
+	now = now.Add(time.Minute)
+	failing = false
+	_, err = fwd.Forward("test-name", nil, nil)
+	assert.NoError(t, err)
+	_, err = fwd.Forward("test-name", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, attempts)
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, newCircuitBreaker(agentCircuitBreaker{FailureThreshold: -1}))
+		assert.Nil(t, newCircuitBreaker(agentCircuitBreaker{}))
+	})
+}
+
+func TestForwardPayloads(t *testing.T) {
+	forward := func(t *testing.T, body []byte, response forwardData, opts ...ForwardOption) (forwardData, InboundResponse, error) {
+		var sent forwardData
+		fwd := newRetryForwarder(t, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			b, err := io.ReadAll(req.Body)
+			assert.NoError(t, err)
+			assert.NoError(t, json.Unmarshal(b, &sent))
+
+			respBytes, _ := json.Marshal(response)
+			return &http.Response{
+				Status:     http.StatusText(http.StatusOK),
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(respBytes)),
+			}, nil
+		}})
+		resp, err := fwd.Forward("test-name", body, nil, opts...)
+		return sent, resp, err
+	}
+
+	t.Run("json is sent as it is", func(t *testing.T) {
+		sent, _, err := forward(t, []byte(`["a","b"]`), forwardData{})
+		assert.NoError(t, err)
+		assert.Equal(t, `["a","b"]`, string(sent.Payload))
+		assert.Empty(t, sent.Mime)
+		assert.Empty(t, sent.Encoding)
+	})
+
+	t.Run("xml is base64 encoded", func(t *testing.T) {
+		body := []byte(`<order id="1"/>`)
+		sent, _, err := forward(t, body, forwardData{}, WithMimeType("application/xml"))
+		assert.NoError(t, err)
+		assert.Equal(t, "application/xml", sent.Mime)
+		assert.Equal(t, payloadEncodingBase64, sent.Encoding)
+
+		var decoded []byte
+		assert.NoError(t, json.Unmarshal(sent.Payload, &decoded))
+		assert.Equal(t, body, decoded)
+	})
+
+	t.Run("binary without a mime type", func(t *testing.T) {
+		sent, _, err := forward(t, []byte{0x00, 0xff, 0x10}, forwardData{})
+		assert.NoError(t, err)
+		assert.Equal(t, "application/octet-stream", sent.Mime)
+		assert.Equal(t, payloadEncodingBase64, sent.Encoding)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		_, _, err := forward(t, []byte(`a,b`), forwardData{}, WithMimeType("application/vnd.api+json"))
+		assert.ErrorIs(t, err, ErrInvalidPayload)
+	})
+
+	t.Run("response mime type", func(t *testing.T) {
+		csv := []byte("name,age\nalice,30\n")
+		payload, _ := json.Marshal(csv)
+		_, resp, err := forward(t, nil, forwardData{
+			Mime:     "text/csv; charset=utf-8",
+			Encoding: payloadEncodingBase64,
+			Payload:  payload,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.(forwardData).MimeType())
+
+		raw, err := resp.Body().Raw()
+		assert.NoError(t, err)
+		assert.Equal(t, csv, raw)
+
+		var rows []map[string]string
+		assert.NoError(t, resp.Body().Bind(&rows))
+		assert.Len(t, rows, 1)
+	})
+
+	t.Run("unknown response encoding", func(t *testing.T) {
+		_, _, err := forward(t, nil, forwardData{Encoding: "gzip", Payload: []byte(`"x"`)})
+		assert.ErrorIs(t, err, ErrInvalidPayload)
+	})
+}
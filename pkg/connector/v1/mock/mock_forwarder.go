@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/azarc-io/vth-faas-sdk-go/pkg/connector/v1 (interfaces: Forwarder)
+// Source: github.com/azarc-io/vth-faas-sdk-go/pkg/connector/v1 (interfaces: Forwarder,AsyncForwarder)
 
 // Package mock is a generated GoMock package.
 package mock
@@ -53,3 +53,93 @@ func (mr *MockForwarderMockRecorder) Forward(arg0, arg1, arg2 interface{}, arg3
 	varargs := append([]interface{}{arg0, arg1, arg2}, arg3...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Forward", reflect.TypeOf((*MockForwarder)(nil).Forward), varargs...)
 }
+
+// MockAsyncForwarder is a mock of AsyncForwarder interface.
+type MockAsyncForwarder struct {
+	ctrl     *gomock.Controller
+	recorder *MockAsyncForwarderMockRecorder
+}
+
+// MockAsyncForwarderMockRecorder is the mock recorder for MockAsyncForwarder.
+type MockAsyncForwarderMockRecorder struct {
+	mock *MockAsyncForwarder
+}
+
+// NewMockAsyncForwarder creates a new mock instance.
+func NewMockAsyncForwarder(ctrl *gomock.Controller) *MockAsyncForwarder {
+	mock := &MockAsyncForwarder{ctrl: ctrl}
+	mock.recorder = &MockAsyncForwarderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAsyncForwarder) EXPECT() *MockAsyncForwarderMockRecorder {
+	return m.recorder
+}
+
+// Forward mocks base method.
+func (m *MockAsyncForwarder) Forward(arg0 string, arg1 []byte, arg2 map[string]string, arg3 ...connectorv1.ForwardOption) (connectorv1.InboundResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Forward", varargs...)
+	ret0, _ := ret[0].(connectorv1.InboundResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Forward indicates an expected call of Forward.
+func (mr *MockAsyncForwarderMockRecorder) Forward(arg0, arg1, arg2 interface{}, arg3 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Forward", reflect.TypeOf((*MockAsyncForwarder)(nil).Forward), varargs...)
+}
+
+// ForwardAsync mocks base method.
+func (m *MockAsyncForwarder) ForwardAsync(arg0 string, arg1 []byte, arg2 map[string]string, arg3 ...connectorv1.ForwardOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ForwardAsync", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForwardAsync indicates an expected call of ForwardAsync.
+func (mr *MockAsyncForwarderMockRecorder) ForwardAsync(arg0, arg1, arg2 interface{}, arg3 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForwardAsync", reflect.TypeOf((*MockAsyncForwarder)(nil).ForwardAsync), varargs...)
+}
+
+// HasBuffer mocks base method.
+func (m *MockAsyncForwarder) HasBuffer() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasBuffer")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasBuffer indicates an expected call of HasBuffer.
+func (mr *MockAsyncForwarderMockRecorder) HasBuffer() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasBuffer", reflect.TypeOf((*MockAsyncForwarder)(nil).HasBuffer))
+}
+
+// Stats mocks base method.
+func (m *MockAsyncForwarder) Stats() connectorv1.ForwarderStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(connectorv1.ForwarderStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockAsyncForwarderMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockAsyncForwarder)(nil).Stats))
+}
@@ -0,0 +1,183 @@
+package connectorv1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+)
+
+const defaultOutboundPath = "/v1/connector-outbound-message"
+
+// outboundRequest the request the agent sends for an outbound message, the payload is encoded like the payload of
+// forwarded messages
+type outboundRequest struct {
+	MsgName    string            `json:"message_name"`
+	Mime       string            `json:"mime_type"`
+	HeadersMap map[string]string `json:"headers"`
+	Encoding   payloadEncoding   `json:"payload_encoding,omitempty"`
+	Payload    json.RawMessage   `json:"payload"`
+
+	// body the decoded payload
+	body []byte
+}
+
+// outboundResponse the response returned to the agent, it mirrors the response of the forwarder
+type outboundResponse struct {
+	HeadersMap map[string]string `json:"headers"`
+	Mime       string            `json:"mime_type,omitempty"`
+	Encoding   payloadEncoding   `json:"payload_encoding,omitempty"`
+	Payload    json.RawMessage   `json:"payload,omitempty"`
+}
+
+// outboundHandler dispatches the outbound requests of the agent to the connector by their message name
+type outboundHandler struct {
+	connector   OutboundConnector
+	descriptors map[string]messageDescriptor
+	token       string
+	logger      Logger
+}
+
+func (o outboundRequest) Body() Bindable {
+	return NewBindable(o.body, bindableMimeType(o.Mime))
+}
+
+func (o outboundRequest) Headers() Headers {
+	return o.HeadersMap
+}
+
+func (o outboundRequest) MessageName() string {
+	return o.MsgName
+}
+
+func (o outboundRequest) MimeType() string {
+	return o.Mime
+}
+
+func (h *outboundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, &HttpError{HttpCode: http.StatusMethodNotAllowed, Reason: "method not allowed"})
+		return
+	}
+
+	if h.token != "" && r.Header.Get(agentTokenHeader) != h.token {
+		h.writeError(w, &HttpError{HttpCode: http.StatusUnauthorized, Reason: "invalid agent token"})
+		return
+	}
+
+	var req outboundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, &HttpError{HttpCode: http.StatusBadRequest, Reason: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+
+	desc, ok := h.descriptors[req.MsgName]
+	if !ok {
+		h.writeError(w, &HttpError{HttpCode: http.StatusNotFound,
+			Reason: fmt.Sprintf("unknown outbound message: %s", req.MsgName)})
+		return
+	}
+	if req.Mime == "" {
+		req.Mime = desc.MimeType()
+	}
+	if req.HeadersMap == nil {
+		req.HeadersMap = Headers{}
+	}
+	body, err := decodePayload(req.Encoding, req.Payload)
+	if err != nil {
+		h.writeError(w, &HttpError{HttpCode: http.StatusBadRequest, Reason: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+	req.body = body
+
+	h.logger.Debug("[outbound] %s: %d bytes", req.MsgName, len(req.body))
+	respBody, headers, err := h.connector.HandleOutboundRequest(req)
+	if err != nil {
+		var he *HttpError
+		if !errors.As(err, &he) {
+			he = &HttpError{HttpCode: http.StatusInternalServerError, Reason: err.Error()}
+		}
+		h.logger.Error(err, "[outbound] failed to handle request: %s", req.MsgName)
+		h.writeError(w, he)
+		return
+	}
+
+	if headers == nil {
+		headers = Headers{}
+	}
+	resp, err := encodeOutboundResponse(respBody, headers)
+	if err != nil {
+		h.logger.Error(err, "[outbound] failed to encode response body: %s", req.MsgName)
+		h.writeError(w, &HttpError{HttpCode: http.StatusInternalServerError, Reason: err.Error()})
+		return
+	}
+
+	h.writeJson(w, http.StatusOK, resp)
+}
+
+// writeError responds with the status of the error, errors without a valid status are internal errors
+func (h *outboundHandler) writeError(w http.ResponseWriter, he *HttpError) {
+	status := he.HttpCode
+	if status < 400 || status > 599 {
+		status = http.StatusInternalServerError
+	}
+	h.writeJson(w, status, he)
+}
+
+func (h *outboundHandler) writeJson(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		h.logger.Error(err, "[outbound] failed to marshal response")
+		status, data = http.StatusInternalServerError, nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		h.logger.Error(err, "[outbound] failed to write response")
+	}
+}
+
+// encodeOutboundResponse encodes the body returned by the connector like the payload of forwarded messages, the
+// Content-Type header sets the mime type of bytes and other values are encoded as json
+func encodeOutboundResponse(body any, headers Headers) (*outboundResponse, error) {
+	var data []byte
+	mime := headers["Content-Type"]
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		data = b
+	case json.RawMessage:
+		data, mime = b, string(codec.MimeTypeJson)
+	default:
+		var err error
+		if data, err = json.Marshal(b); err != nil {
+			return nil, err
+		}
+		mime = string(codec.MimeTypeJson)
+	}
+
+	payload, mime, encoding, err := encodePayload(mime, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > 0 && mime == "" {
+		mime = string(codec.MimeTypeJson)
+	}
+	return &outboundResponse{HeadersMap: headers, Mime: mime, Encoding: encoding, Payload: payload}, nil
+}
+
+func newOutboundHandler(connector OutboundConnector, descriptors []messageDescriptor, token string, logger Logger) http.Handler {
+	h := &outboundHandler{
+		connector:   connector,
+		descriptors: make(map[string]messageDescriptor, len(descriptors)),
+		token:       token,
+		logger:      logger,
+	}
+	for _, desc := range descriptors {
+		h.descriptors[desc.MessageName()] = desc
+	}
+	return h
+}
@@ -0,0 +1,195 @@
+package connectorv1
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type outboundConnector struct {
+	handle func(request OutboundRequest) (any, Headers, error)
+}
+
+func (c outboundConnector) Start(_ StartContext) error {
+	return nil
+}
+
+func (c outboundConnector) Stop(_ StopContext) error {
+	return nil
+}
+
+func (c outboundConnector) HandleOutboundRequest(request OutboundRequest) (any, Headers, error) {
+	return c.handle(request)
+}
+
+type inboundConnector struct{}
+
+func (c inboundConnector) Start(_ StartContext) error {
+	return nil
+}
+
+func (c inboundConnector) Stop(_ StopContext) error {
+	return nil
+}
+
+func TestOutboundHandler(t *testing.T) {
+	_ = os.Setenv("OUTBOUND_DESCRIPTOR_FILE_PATH", "./fixtures/outbound_descriptors_config_1.yaml")
+	defer func() {
+		_ = os.Unsetenv("OUTBOUND_DESCRIPTOR_FILE_PATH")
+	}()
+	descriptors, err := loadMessageDescriptorsConfig(MessageTypeOutbound)
+	require.NoError(t, err)
+	require.Len(t, descriptors, 2)
+	assert.Equal(t, MessageTypeOutbound, descriptors[0].MessageType())
+
+	var handle func(request OutboundRequest) (any, Headers, error)
+	connector := outboundConnector{handle: func(request OutboundRequest) (any, Headers, error) {
+		return handle(request)
+	}}
+	server := httptest.NewServer(newOutboundHandler(connector, descriptors, "test-token", noopLogger{}))
+	defer server.Close()
+
+	send := func(t *testing.T, method, token string, body []byte) (int, map[string]any) {
+		req, err := http.NewRequest(method, server.URL, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(agentTokenHeader, token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+		var out map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+		return resp.StatusCode, out
+	}
+
+	t.Run("dispatch by message name", func(t *testing.T) {
+		handle = func(request OutboundRequest) (any, Headers, error) {
+			assert.Equal(t, "outbound-message-1", request.MessageName())
+			assert.Equal(t, "application/json", request.MimeType(), "mime type of the descriptor")
+			assert.Equal(t, "value", request.Headers()["key"])
+
+			var body struct {
+				Key string `json:"key"`
+			}
+			assert.NoError(t, request.Body().Bind(&body))
+			assert.Equal(t, "request", body.Key)
+			return map[string]string{"key": "response"}, Headers{"X-Response": "yes"}, nil
+		}
+
+		status, out := send(t, http.MethodPost, "test-token",
+			[]byte(`{"message_name":"outbound-message-1","headers":{"key":"value"},"payload":{"key":"request"}}`))
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, map[string]any{
+			"headers":   map[string]any{"X-Response": "yes"},
+			"mime_type": "application/json",
+			"payload":   map[string]any{"key": "response"},
+		}, out)
+	})
+
+	t.Run("yaml payload", func(t *testing.T) {
+		handle = func(request OutboundRequest) (any, Headers, error) {
+			assert.Equal(t, "application/yaml", request.MimeType())
+			var body map[string]string
+			assert.NoError(t, request.Body().Bind(&body))
+			assert.Equal(t, "request", body["key"])
+			return []byte("key: response\n"), Headers{"Content-Type": "application/yaml"}, nil
+		}
+
+		payload, err := json.Marshal([]byte("key: request\n"))
+		require.NoError(t, err)
+		status, out := send(t, http.MethodPost, "test-token",
+			[]byte(`{"message_name":"outbound-message-2","payload_encoding":"base64","payload":`+string(payload)+`}`))
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, map[string]any{
+			"headers":          map[string]any{"Content-Type": "application/yaml"},
+			"mime_type":        "application/yaml",
+			"payload_encoding": "base64",
+			"payload":          base64.StdEncoding.EncodeToString([]byte("key: response\n")),
+		}, out)
+	})
+
+	t.Run("binary response", func(t *testing.T) {
+		handle = func(_ OutboundRequest) (any, Headers, error) {
+			return []byte("not json"), nil, nil
+		}
+
+		status, out := send(t, http.MethodPost, "test-token", []byte(`{"message_name":"outbound-message-1"}`))
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, map[string]any{
+			"headers":          map[string]any{},
+			"mime_type":        "application/octet-stream",
+			"payload_encoding": "base64",
+			"payload":          base64.StdEncoding.EncodeToString([]byte("not json")),
+		}, out)
+	})
+
+	t.Run("http errors of the connector", func(t *testing.T) {
+		handle = func(_ OutboundRequest) (any, Headers, error) {
+			return nil, nil, &HttpError{HttpCode: http.StatusConflict, Reason: "conflict", Raw: []byte("raw")}
+		}
+
+		status, out := send(t, http.MethodPost, "test-token", []byte(`{"message_name":"outbound-message-1"}`))
+		assert.Equal(t, http.StatusConflict, status)
+		assert.Equal(t, map[string]any{"http_code": float64(409), "reason": "conflict", "raw": "cmF3"}, out)
+	})
+
+	t.Run("errors of the connector", func(t *testing.T) {
+		handle = func(_ OutboundRequest) (any, Headers, error) {
+			return nil, nil, errors.New("boom")
+		}
+
+		status, out := send(t, http.MethodPost, "test-token", []byte(`{"message_name":"outbound-message-1"}`))
+		assert.Equal(t, http.StatusInternalServerError, status)
+		assert.Equal(t, "boom", out["reason"])
+	})
+
+	t.Run("rejected requests", func(t *testing.T) {
+		handle = func(_ OutboundRequest) (any, Headers, error) {
+			t.Fatal("rejected requests must not reach the connector")
+			return nil, nil, nil
+		}
+
+		for _, tc := range []struct {
+			method, token string
+			body          string
+			status        int
+		}{
+			{http.MethodGet, "test-token", "", http.StatusMethodNotAllowed},
+			{http.MethodPost, "wrong-token", `{"message_name":"outbound-message-1"}`, http.StatusUnauthorized},
+			{http.MethodPost, "test-token", `{`, http.StatusBadRequest},
+			{http.MethodPost, "test-token", `{"message_name":"unknown"}`, http.StatusNotFound},
+			{http.MethodPost, "test-token", `{"message_name":"outbound-message-1","payload_encoding":"gzip"}`,
+				http.StatusBadRequest},
+		} {
+			status, out := send(t, tc.method, tc.token, []byte(tc.body))
+			assert.Equal(t, tc.status, status, tc.body)
+			assert.Equal(t, float64(tc.status), out["http_code"], tc.body)
+		}
+	})
+}
+
+func TestInitOutbound(t *testing.T) {
+	enabled := &connectorConfig{Outbound: &configOutbound{Enabled: true, Bind: "127.0.0.1", Port: 8090}}
+
+	w := &worker{connector: outboundConnector{}, config: enabled, opts: &ConnectorOpts{log: noopLogger{}}}
+	require.NoError(t, w.initOutbound())
+	require.NotNil(t, w.outboundServer)
+	assert.Equal(t, "127.0.0.1:8090", w.outboundServer.Addr)
+
+	w = &worker{connector: inboundConnector{}, config: enabled, opts: &ConnectorOpts{log: noopLogger{}}}
+	assert.ErrorIs(t, w.initOutbound(), ErrNotAnOutboundConnector)
+
+	w = &worker{connector: inboundConnector{}, config: &connectorConfig{}, opts: &ConnectorOpts{log: noopLogger{}}}
+	assert.NoError(t, w.initOutbound())
+	assert.Nil(t, w.outboundServer)
+}
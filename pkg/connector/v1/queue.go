@@ -0,0 +1,297 @@
+package connectorv1
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// queuedMessage a message of ForwardAsync, seq is the sequence number of the message in the buffer and 0 if the
+// message is not buffered
+type queuedMessage struct {
+	seq uint64
+	req *forwardData
+	err error
+}
+
+// forwardQueue delivers the messages of ForwardAsync in batches in the background, messages that can not be
+// delivered because the agent is unavailable are sent again until the queue is closed so a full queue pushes back
+// on the producers instead of dropping messages
+type forwardQueue struct {
+	fwd      *forwarder
+	config   agentAsync
+	messages chan *queuedMessage
+
+	once      sync.Once
+	openErr   error
+	mu        sync.Mutex
+	opened    bool
+	closed    bool
+	buffer    *forwardBuffer
+	recovered []*queuedMessage
+	closing   chan struct{}
+	done      chan struct{}
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// open opens the buffer and starts the delivery of the messages, the messages in the buffer are delivered first
+func (q *forwardQueue) open() error {
+	q.once.Do(func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if q.closed {
+			q.openErr = ErrForwardQueueClosed
+			return
+		}
+		if q.config.BufferDir != "" {
+			if q.buffer, q.recovered, q.openErr = openForwardBuffer(q.config.BufferDir); q.openErr != nil {
+				return
+			}
+			for _, m := range q.recovered {
+				m.req.timeout, m.req.retry = q.fwd.timeout, q.fwd.retry
+			}
+			if len(q.recovered) > 0 {
+				q.fwd.logger.Info("[forwarder] delivering %d buffered messages", len(q.recovered))
+			}
+		}
+
+		q.opened = true
+		go q.run()
+	})
+	return q.openErr
+}
+
+// push queues the message, it is written to the buffer first if the queue has a buffer
+func (q *forwardQueue) push(req *forwardData) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrForwardQueueClosed
+	}
+	// the queue only shrinks while it is locked so a message can be sent without blocking if it is not full
+	if len(q.messages) == cap(q.messages) {
+		return ErrForwardQueueFull
+	}
+
+	m := &queuedMessage{req: req}
+	if q.buffer != nil {
+		seq, err := q.buffer.append(req)
+		if err != nil {
+			q.fwd.logger.Error(err, "[forwarder] failed to buffer message: %s", req.MsgName)
+			return err
+		}
+		m.seq = seq
+	}
+	q.messages <- m
+	return nil
+}
+
+// close delivers the queued messages for up to the drain timeout and closes the buffer, messages that are not
+// delivered by then are kept in the buffer for the next start or, without a buffer, fail with ErrForwardQueueClosed
+func (q *forwardQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.messages)
+	opened := q.opened
+	q.mu.Unlock()
+
+	if !opened {
+		close(q.closing)
+		return
+	}
+
+	timer := time.NewTimer(q.config.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-q.done:
+	case <-timer.C:
+		q.fwd.logger.Warn("[forwarder] queued messages were not delivered within %s, stopping anyway",
+			q.config.DrainTimeout)
+	}
+	close(q.closing)
+	<-q.done
+
+	if q.buffer != nil {
+		if err := q.buffer.close(); err != nil {
+			q.fwd.logger.Error(err, "[forwarder] failed to close buffer")
+		}
+	}
+}
+
+func (q *forwardQueue) stats() ForwarderStats {
+	s := ForwarderStats{
+		QueueDepth:    len(q.messages),
+		QueueCapacity: cap(q.messages),
+		Delivered:     q.delivered.Load(),
+		Failed:        q.failed.Load(),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.buffer != nil {
+		s.Buffered = q.buffer.len()
+	}
+	return s
+}
+
+func (q *forwardQueue) run() {
+	defer close(q.done)
+
+	for len(q.recovered) > 0 && !q.isClosing() {
+		n := min(q.config.BatchSize, len(q.recovered))
+		q.deliver(q.recovered[:n])
+		q.recovered = q.recovered[n:]
+	}
+
+	for m := range q.messages {
+		q.deliver(q.collect(m))
+	}
+}
+
+func (q *forwardQueue) isClosing() bool {
+	select {
+	case <-q.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// collect adds the queued messages to the batch until it is full or the batch wait passed
+func (q *forwardQueue) collect(first *queuedMessage) []*queuedMessage {
+	batch := []*queuedMessage{first}
+	timer := time.NewTimer(q.config.BatchWait)
+	defer timer.Stop()
+
+	for len(batch) < q.config.BatchSize {
+		select {
+		case m, ok := <-q.messages:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, m)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// deliver sends the batch until every message was delivered or failed permanently, the wait between the
+// attempts grows up to the max backoff wait of the retry config
+func (q *forwardQueue) deliver(batch []*queuedMessage) {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = q.fwd.retry.FirstBackoffWait
+	b.Multiplier = q.fwd.retry.BackoffMultiplier
+	b.MaxInterval = q.fwd.retry.MaxBackoffWait
+	b.MaxElapsedTime = 0
+
+	for {
+		if batch = q.send(batch); len(batch) == 0 {
+			return
+		}
+
+		wait := b.NextBackOff()
+		q.fwd.logger.Warn("[forwarder] failed to deliver %d messages, retrying in %s: %s", len(batch), wait,
+			batch[0].err)
+		select {
+		case <-q.closing:
+			q.abandon(batch)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// send sends the batch once and returns the messages that can be sent again, messages are sent one by one when
+// the agent has no batch path and the batch stops at the first message that can be sent again to keep the order
+func (q *forwardQueue) send(batch []*queuedMessage) []*queuedMessage {
+	if q.config.BatchPath == "" || len(batch) == 1 {
+		for i, m := range batch {
+			resp, err := q.fwd.forward(m.req)
+			if err != nil && isTemporary(err, m.req.nonIdempotent) {
+				m.err = err
+				return batch[i:]
+			}
+			q.complete(m, resp, err)
+		}
+		return nil
+	}
+
+	reqs := make([]*forwardData, len(batch))
+	nonIdempotent := false
+	for i, m := range batch {
+		reqs[i] = m.req
+		nonIdempotent = nonIdempotent || m.req.nonIdempotent
+	}
+
+	responses, err := q.fwd.forwardBatch(q.config.BatchPath, reqs)
+	for i, m := range batch {
+		switch {
+		case err == nil:
+			q.complete(m, responses[i], nil)
+		case isTemporary(err, nonIdempotent):
+			m.err = err
+		default:
+			q.complete(m, nil, err)
+		}
+	}
+	if err != nil && isTemporary(err, nonIdempotent) {
+		return batch
+	}
+	return nil
+}
+
+// complete removes the message from the buffer and passes the result to the delivery callback
+func (q *forwardQueue) complete(m *queuedMessage, resp InboundResponse, err error) {
+	if m.seq != 0 {
+		if err := q.buffer.remove(m.seq); err != nil {
+			q.fwd.logger.Error(err, "[forwarder] failed to remove message from buffer: %s", m.req.MsgName)
+		}
+	}
+
+	if err != nil {
+		q.failed.Add(1)
+		q.fwd.logger.Error(err, "[forwarder] failed to deliver message: %s", m.req.MsgName)
+	} else {
+		q.delivered.Add(1)
+	}
+
+	if m.req.onDelivery != nil {
+		m.req.onDelivery(resp, err)
+	}
+}
+
+// abandon gives up the messages when the queue is closed, buffered messages are delivered after the next start
+func (q *forwardQueue) abandon(batch []*queuedMessage) {
+	for _, m := range batch {
+		if m.seq != 0 {
+			continue
+		}
+		q.failed.Add(1)
+		q.fwd.logger.Error(m.err, "[forwarder] queue closed before message was delivered: %s", m.req.MsgName)
+		if m.req.onDelivery != nil {
+			m.req.onDelivery(nil, fmt.Errorf("%w: %w", ErrForwardQueueClosed, m.err))
+		}
+	}
+}
+
+func newForwardQueue(fwd *forwarder, config agentAsync) *forwardQueue {
+	return &forwardQueue{
+		fwd:      fwd,
+		config:   config,
+		messages: make(chan *queuedMessage, config.QueueSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
@@ -0,0 +1,333 @@
+package connectorv1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type agentRecorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *agentRecorder) record(t *testing.T, req *http.Request) []byte {
+	body, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	return body
+}
+
+func (r *agentRecorder) messageNames(t *testing.T) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for _, body := range r.bodies {
+		var data forwardData
+		require.NoError(t, json.Unmarshal(body, &data))
+		names = append(names, data.MsgName)
+	}
+	return names
+}
+
+func newAsyncForwarder(t *testing.T, async *agentAsync, doer requestDoer) *forwarder {
+	t.Helper()
+	config := &connectorConfig{
+		Id: "connector-id",
+		Agent: &agent{
+			Host:           "test.agent",
+			Port:           8080,
+			Retry:          &agentRetry{Times: 1, FirstBackoffWait: time.Millisecond, MaxBackoffWait: time.Millisecond},
+			CircuitBreaker: &agentCircuitBreaker{FailureThreshold: -1},
+			Async:          async,
+		},
+	}
+	fwd := newForwarder(config, withRequestDoer(doer)).(*forwarder)
+	t.Cleanup(fwd.close)
+	return fwd
+}
+
+func echoResponse(body []byte) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(http.StatusOK),
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+type deliveries struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results map[string]error
+}
+
+func newDeliveries(n int) *deliveries {
+	d := &deliveries{results: map[string]error{}}
+	d.wg.Add(n)
+	return d
+}
+
+func (d *deliveries) callback(name string) ForwardOption {
+	return WithDeliveryCallback(func(_ InboundResponse, err error) {
+		d.mu.Lock()
+		d.results[name] = err
+		d.mu.Unlock()
+		d.wg.Done()
+	})
+}
+
+func TestForwardAsync(t *testing.T) {
+	t.Run("delivers messages one by one", func(t *testing.T) {
+		rec := &agentRecorder{}
+		fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 10, BatchWait: time.Millisecond},
+			mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+				return echoResponse(rec.record(t, req)), nil
+			}})
+
+		d := newDeliveries(2)
+		require.NoError(t, fwd.ForwardAsync("message-1", []byte(`{"a":1}`), nil, d.callback("message-1")))
+		require.NoError(t, fwd.ForwardAsync("message-2", []byte(`{"b":2}`), nil, d.callback("message-2")))
+		d.wg.Wait()
+
+		assert.Equal(t, map[string]error{"message-1": nil, "message-2": nil}, d.results)
+		assert.Equal(t, []string{"message-1", "message-2"}, rec.messageNames(t))
+		assert.Equal(t, "http://test.agent:8080", rec.requests[0].URL.String())
+		assert.Equal(t, uint64(2), fwd.Stats().Delivered)
+	})
+
+	t.Run("delivers batches to the batch path", func(t *testing.T) {
+		rec := &agentRecorder{}
+		fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 3, BatchWait: time.Minute, BatchPath: "/batch"},
+			mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+				var batch struct {
+					Messages []struct {
+						MsgName        string `json:"message_name"`
+						IdempotencyKey string `json:"idempotency_key"`
+					} `json:"messages"`
+				}
+				assert.NoError(t, json.Unmarshal(rec.record(t, req), &batch))
+
+				var resp batchResponse
+				for _, m := range batch.Messages {
+					assert.NotEmpty(t, m.IdempotencyKey)
+					resp.Responses = append(resp.Responses, forwardData{Payload: json.RawMessage(`"` + m.MsgName + `"`)})
+				}
+				body, _ := json.Marshal(resp)
+				return echoResponse(body), nil
+			}})
+
+		var (
+			mu        sync.Mutex
+			responses []string
+			wg        sync.WaitGroup
+		)
+		wg.Add(3)
+		for _, name := range []string{"message-1", "message-2", "message-3"} {
+			require.NoError(t, fwd.ForwardAsync(name, nil, nil, WithDeliveryCallback(func(resp InboundResponse, err error) {
+				assert.NoError(t, err)
+				raw, _ := resp.Body().Raw()
+				mu.Lock()
+				responses = append(responses, string(raw))
+				mu.Unlock()
+				wg.Done()
+			})))
+		}
+		wg.Wait()
+
+		require.Len(t, rec.requests, 1)
+		assert.Equal(t, "http://test.agent:8080/batch", rec.requests[0].URL.String())
+		assert.Equal(t, []string{`"message-1"`, `"message-2"`, `"message-3"`}, responses)
+	})
+
+	t.Run("retries until the agent is available", func(t *testing.T) {
+		attempts := 0
+		fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 1}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			if attempts++; attempts < 5 {
+				return newStatusResponse(http.StatusServiceUnavailable), nil
+			}
+			return newStatusResponse(http.StatusOK), nil
+		}})
+
+		d := newDeliveries(1)
+		require.NoError(t, fwd.ForwardAsync("message-1", nil, nil, d.callback("message-1")))
+		d.wg.Wait()
+
+		assert.NoError(t, d.results["message-1"])
+		assert.Equal(t, 5, attempts)
+	})
+
+	t.Run("reports permanent failures", func(t *testing.T) {
+		fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 1}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return newStatusResponse(http.StatusBadRequest), nil
+		}})
+
+		d := newDeliveries(1)
+		require.NoError(t, fwd.ForwardAsync("message-1", nil, nil, d.callback("message-1")))
+		d.wg.Wait()
+
+		var he *HttpError
+		assert.ErrorAs(t, d.results["message-1"], &he)
+		assert.Equal(t, uint64(1), fwd.Stats().Failed)
+	})
+
+	t.Run("rejects messages when the queue is full", func(t *testing.T) {
+		sent, release := make(chan struct{}, 1), make(chan struct{})
+		fwd := newAsyncForwarder(t, &agentAsync{QueueSize: 1, BatchSize: 1}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			sent <- struct{}{}
+			<-release
+			return newStatusResponse(http.StatusOK), nil
+		}})
+
+		require.NoError(t, fwd.ForwardAsync("message-1", nil, nil))
+		<-sent
+		require.NoError(t, fwd.ForwardAsync("message-2", nil, nil))
+		assert.Equal(t, ForwarderStats{QueueDepth: 1, QueueCapacity: 1}, fwd.Stats())
+		assert.ErrorIs(t, fwd.ForwardAsync("message-3", nil, nil), ErrForwardQueueFull)
+
+		close(release)
+		fwd.close()
+		assert.Equal(t, uint64(2), fwd.Stats().Delivered)
+		assert.ErrorIs(t, fwd.ForwardAsync("message-4", nil, nil), ErrForwardQueueClosed)
+	})
+
+	t.Run("drains the queue on close", func(t *testing.T) {
+		dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		var mu sync.Mutex
+		attempts := 0
+		fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 1, DrainTimeout: time.Minute}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			// the agent becomes available while the queue is drained
+			if attempts++; attempts < 4 {
+				return nil, dialErr
+			}
+			return newStatusResponse(http.StatusOK), nil
+		}})
+
+		d := newDeliveries(1)
+		require.NoError(t, fwd.ForwardAsync("message-1", nil, nil, d.callback("message-1")))
+		fwd.close()
+		d.wg.Wait()
+		assert.NoError(t, d.results["message-1"])
+		assert.Equal(t, uint64(1), fwd.Stats().Delivered)
+	})
+
+	t.Run("drops unbuffered messages after the drain timeout", func(t *testing.T) {
+		dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 1, DrainTimeout: 10 * time.Millisecond}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return nil, dialErr
+		}})
+
+		d := newDeliveries(1)
+		require.NoError(t, fwd.ForwardAsync("message-1", nil, nil, d.callback("message-1")))
+		fwd.close()
+		d.wg.Wait()
+		assert.ErrorIs(t, d.results["message-1"], ErrForwardQueueClosed)
+		assert.Equal(t, uint64(1), fwd.Stats().Failed)
+	})
+}
+
+func TestForwardAsyncBuffer(t *testing.T) {
+	dir := t.TempDir()
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	// the agent is unavailable so the messages stay in the buffer
+	var keys []string
+	fwd := newAsyncForwarder(t, &agentAsync{BatchSize: 1, BufferDir: dir, DrainTimeout: 10 * time.Millisecond}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+		keys = append(keys, req.Header.Get(idempotencyHeader))
+		return nil, dialErr
+	}})
+	assert.True(t, fwd.HasBuffer())
+	assert.False(t, newAsyncForwarder(t, &agentAsync{}, nil).HasBuffer())
+	require.NoError(t, fwd.ForwardAsync("message-1", []byte("a,b"), nil, WithMimeType("text/csv")))
+	require.NoError(t, fwd.ForwardAsync("message-2", []byte(`{"b":2}`), nil, WithNonIdempotent()))
+	assert.Equal(t, 2, fwd.Stats().Buffered)
+	fwd.close()
+	assert.Equal(t, uint64(0), fwd.Stats().Failed)
+	require.NotEmpty(t, keys)
+
+	// the buffered messages are delivered in order after a restart
+	rec := &agentRecorder{}
+	var delivered sync.WaitGroup
+	delivered.Add(2)
+	fwd = newAsyncForwarder(t, &agentAsync{BatchSize: 1, BufferDir: dir}, mockHttpDoer{DoFunc: func(req *http.Request) (*http.Response, error) {
+		defer delivered.Done()
+		return echoResponse(rec.record(t, req)), nil
+	}})
+	require.NoError(t, fwd.queue.open())
+	delivered.Wait()
+	fwd.close()
+
+	assert.Equal(t, []string{"message-1", "message-2"}, rec.messageNames(t))
+	assert.Equal(t, keys[0], rec.requests[0].Header.Get(idempotencyHeader))
+
+	var first forwardData
+	require.NoError(t, json.Unmarshal(rec.bodies[0], &first))
+	require.NoError(t, first.decodePayload())
+	assert.Equal(t, "text/csv", first.Mime)
+	assert.Equal(t, []byte("a,b"), first.body)
+
+	info, err := os.Stat(path.Join(dir, bufferFileName))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "the buffer is truncated once every message was delivered")
+
+	t.Run("incomplete record", func(t *testing.T) {
+		file := path.Join(t.TempDir(), bufferFileName)
+		require.NoError(t, os.WriteFile(file, []byte(
+			`{"seq":1,"message":{"message_name":"message-1","payload":null,"idempotency_key":"key-1"}}`+"\n"+
+				`{"seq":2,"message":{"message_name":"message-2","payload":null,"idempotency_key":"key-2"}}`+"\n"+
+				`{"seq":1,"delivered":true}`+"\n"+
+				`{"seq":3,"message":{"message_na`), 0o644))
+
+		messages, err := readForwardBuffer(file)
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, uint64(2), messages[0].seq)
+		assert.Equal(t, "message-2", messages[0].req.MsgName)
+		assert.Equal(t, "key-2", messages[0].req.idempotencyKey)
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		dir := t.TempDir()
+		b, _, err := openForwardBuffer(dir)
+		require.NoError(t, err)
+
+		// a message that is never delivered keeps the buffer from being truncated
+		_, err = b.append(&forwardData{MsgName: "stuck", idempotencyKey: "key-stuck"})
+		require.NoError(t, err)
+		for i := 0; i < bufferCompactRecords; i++ {
+			seq, err := b.append(&forwardData{MsgName: "delivered"})
+			require.NoError(t, err)
+			require.NoError(t, b.remove(seq))
+		}
+		assert.Less(t, b.records, bufferCompactRecords)
+		require.NoError(t, b.close())
+
+		data, err := os.ReadFile(path.Join(dir, bufferFileName))
+		require.NoError(t, err)
+		assert.Less(t, bytes.Count(data, []byte("\n")), bufferCompactRecords)
+
+		_, messages, err := openForwardBuffer(dir)
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "stuck", messages[0].req.MsgName)
+		assert.Equal(t, "key-stuck", messages[0].req.idempotencyKey)
+	})
+}
@@ -9,6 +9,8 @@ type Config struct {
 	UserConfig         []byte              `json:"user_config"`
 	Ingress            []IngressConfig     `json:"ingress_config"`
 	InboundDescriptors []InboundDescriptor `json:"inbound_descriptors"`
+	// OutboundDescriptors are described like inbound descriptors with the outbound message type
+	OutboundDescriptors []OutboundDescriptor `json:"outbound_descriptors"`
 }
 
 type IngressConfig struct {
@@ -39,6 +41,8 @@ type InboundDescriptor struct {
 	Options      []byte                  `json:"options"`
 }
 
+type OutboundDescriptor = InboundDescriptor
+
 func (m InboundDescriptor) Name() string {
 	return m.ReadableName
 }
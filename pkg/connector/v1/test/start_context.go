@@ -9,16 +9,39 @@ import (
 )
 
 type startContext struct {
-	ctrl               *gomock.Controller
-	userConfig         []byte
-	inboundDescriptors []InboundDescriptor
-	logger             connectorv1.Logger
-	forwarder          connectorv1.Forwarder
-	ingress            []IngressConfig
-	healthCheckers     map[string]connectorv1.HealthCheckFunc
+	ctrl                *gomock.Controller
+	userConfig          []byte
+	inboundDescriptors  []InboundDescriptor
+	outboundDescriptors []OutboundDescriptor
+	logger              connectorv1.Logger
+	forwarder           connectorv1.Forwarder
+	ingress             []IngressConfig
+	healthCheckers      map[string]connectorv1.HealthCheckFunc
 
 	LoggerMock    *mock.MockLogger
 	ForwarderMock *mock.MockForwarder
+	// AsyncForwarderMock receives the calls of ForwardAsync, HasBuffer and Stats, calls of Forward are received by
+	// ForwarderMock
+	AsyncForwarderMock *mock.MockAsyncForwarder
+}
+
+// asyncForwarder the forwarder of the start context, it passes the calls of connectorv1.AsyncForwarder that
+// connectorv1.Forwarder does not have to the async mock
+type asyncForwarder struct {
+	*mock.MockForwarder
+	async *mock.MockAsyncForwarder
+}
+
+func (f asyncForwarder) ForwardAsync(name string, body []byte, headers connectorv1.Headers, opts ...connectorv1.ForwardOption) error {
+	return f.async.ForwardAsync(name, body, headers, opts...)
+}
+
+func (f asyncForwarder) HasBuffer() bool {
+	return f.async.HasBuffer()
+}
+
+func (f asyncForwarder) Stats() connectorv1.ForwarderStats {
+	return f.async.Stats()
 }
 
 func (c *startContext) Ingress(name string) (connectorv1.Ingress, error) {
@@ -39,7 +62,11 @@ func (c *startContext) InboundDescriptors() []connectorv1.InboundDescriptor {
 }
 
 func (c *startContext) OutboundDescriptors() []connectorv1.OutboundDescriptor {
-	return nil
+	descriptors := make([]connectorv1.OutboundDescriptor, len(c.outboundDescriptors))
+	for i := range c.outboundDescriptors {
+		descriptors[i] = c.outboundDescriptors[i]
+	}
+	return descriptors
 }
 
 func (c *startContext) Forwarder() connectorv1.Forwarder {
@@ -61,17 +88,20 @@ func (c *startContext) Config() connectorv1.Bindable {
 func NewStartContext(t *testing.T, config *Config) *startContext {
 	ctrl := gomock.NewController(t)
 	forwarderMock := mock.NewMockForwarder(ctrl)
+	asyncForwarderMock := mock.NewMockAsyncForwarder(ctrl)
 
 	return &startContext{
-		ctrl:               ctrl,
-		userConfig:         config.UserConfig,
-		inboundDescriptors: config.InboundDescriptors,
-		logger:             noopLogger{},
-		forwarder:          forwarderMock,
-		ingress:            config.Ingress,
-		healthCheckers:     make(map[string]connectorv1.HealthCheckFunc),
-
-		ForwarderMock: forwarderMock,
+		ctrl:                ctrl,
+		userConfig:          config.UserConfig,
+		inboundDescriptors:  config.InboundDescriptors,
+		outboundDescriptors: config.OutboundDescriptors,
+		logger:              noopLogger{},
+		forwarder:           asyncForwarder{MockForwarder: forwarderMock, async: asyncForwarderMock},
+		ingress:             config.Ingress,
+		healthCheckers:      make(map[string]connectorv1.HealthCheckFunc),
+
+		ForwarderMock:      forwarderMock,
+		AsyncForwarderMock: asyncForwarderMock,
 	}
 }
 
@@ -83,11 +113,15 @@ func (c *startContext) WithLoggerMock() *startContext {
 
 type InboundResponse struct {
 	HeadersMap connectorv1.Headers
+	Mime       string
 	Payload    []byte
 }
 
 func (f *InboundResponse) Body() connectorv1.Bindable {
-	return connectorv1.NewBindable(f.Payload, connectorv1.BindableTypeJson)
+	if f.Mime == "" {
+		return connectorv1.NewBindable(f.Payload, connectorv1.BindableTypeJson)
+	}
+	return connectorv1.NewBindable(f.Payload, connectorv1.BindableType(f.Mime))
 }
 
 func (f *InboundResponse) Headers() connectorv1.Headers {
@@ -97,3 +131,32 @@ func (f *InboundResponse) Headers() connectorv1.Headers {
 func (c *startContext) MockForward(messageName string, body any, headers any, response *InboundResponse, responseErr error) {
 	c.ForwarderMock.EXPECT().Forward(messageName, body, headers).Return(response, responseErr)
 }
+
+// MockForwardAsync expects a message to be queued with ForwardAsync, delivery callbacks are not called
+func (c *startContext) MockForwardAsync(messageName string, body any, headers any, responseErr error) {
+	c.AsyncForwarderMock.EXPECT().ForwardAsync(messageName, body, headers).Return(responseErr)
+}
+
+// OutboundRequest a request of the agent that can be passed to connectorv1.OutboundConnector.HandleOutboundRequest
+type OutboundRequest struct {
+	MsgName    string
+	Mime       string
+	HeadersMap connectorv1.Headers
+	Payload    []byte
+}
+
+func (r *OutboundRequest) Body() connectorv1.Bindable {
+	return connectorv1.NewBindable(r.Payload, connectorv1.BindableType(r.Mime))
+}
+
+func (r *OutboundRequest) Headers() connectorv1.Headers {
+	return r.HeadersMap
+}
+
+func (r *OutboundRequest) MessageName() string {
+	return r.MsgName
+}
+
+func (r *OutboundRequest) MimeType() string {
+	return r.Mime
+}
@@ -2,6 +2,7 @@ package connectorv1
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/azarc-io/vth-faas-sdk-go/internal/healthz"
 	"github.com/azarc-io/vth-faas-sdk-go/internal/signals"
@@ -12,17 +13,21 @@ import (
 const runtimeTTL = time.Minute
 
 type worker struct {
-	connector          Connector
-	opts               *ConnectorOpts
-	config             *connectorConfig
-	ingress            []ingressConfig
-	userConfig         Bindable
-	inboundDescriptors []messageDescriptor
-
-	health       healthChecker
-	healthServer *http.Server
+	connector           Connector
+	opts                *ConnectorOpts
+	config              *connectorConfig
+	ingress             []ingressConfig
+	userConfig          Bindable
+	inboundDescriptors  []messageDescriptor
+	outboundDescriptors []messageDescriptor
+
+	health         healthChecker
+	healthServer   *http.Server
+	outboundServer *http.Server
 }
 
+var ErrNotAnOutboundConnector = errors.New("outbound requests are enabled but the connector does not implement OutboundConnector")
+
 type healthChecker interface {
 	Register(name string, period time.Duration, fn healthz.CheckFunc)
 	Handler() http.Handler
@@ -44,13 +49,24 @@ func (w *worker) Run() {
 	}
 
 	startCtx := startContext{
-		userConfig:         w.userConfig,
-		inboundDescriptors: w.inboundDescriptors,
-		logger:             w.opts.log,
-		forwarder:          w.opts.forwarder,
-		health:             w.health,
-		healthConfig:       w.config.Health,
-		ingress:            w.ingress,
+		userConfig:          w.userConfig,
+		inboundDescriptors:  w.inboundDescriptors,
+		outboundDescriptors: w.outboundDescriptors,
+		logger:              w.opts.log,
+		forwarder:           w.opts.forwarder,
+		health:              w.health,
+		healthConfig:        w.config.Health,
+		ingress:             w.ingress,
+	}
+
+	// the queue of the forwarder is opened before the connector starts so buffered messages are delivered first
+	if fwd, ok := w.opts.forwarder.(*forwarder); ok {
+		if w.config.Agent.async().BufferDir != "" {
+			if err := fwd.queue.open(); err != nil {
+				panic(err)
+			}
+		}
+		defer fwd.close()
 	}
 
 	err := w.connector.Start(&startCtx)
@@ -58,9 +74,24 @@ func (w *worker) Run() {
 		panic(err)
 	}
 
+	// outbound requests are only accepted once the connector started
+	if w.outboundServer != nil {
+		go func() {
+			if err := w.outboundServer.ListenAndServe(); err != http.ErrServerClosed {
+				panic(err)
+			}
+		}()
+	}
+
 	// wait for signal to shut down
 	<-signals.SetupSignalHandler()
 
+	if w.outboundServer != nil {
+		if err := w.outboundServer.Shutdown(context.Background()); err != nil {
+			w.opts.log.Error(err, "failed to shutdown outbound server")
+		}
+	}
+
 	stopCtx := stopContext{logger: w.opts.log}
 	err = w.connector.Stop(&stopCtx)
 	if err != nil {
@@ -83,6 +114,12 @@ func (w *worker) loadConfiguration() error {
 	}
 	w.inboundDescriptors = inboundDescriptors
 
+	outboundDescriptors, err := loadMessageDescriptorsConfig(MessageTypeOutbound)
+	if err != nil {
+		return err
+	}
+	w.outboundDescriptors = outboundDescriptors
+
 	userConfig, err := loadUserConfig(w.opts)
 	if err != nil {
 		return err
@@ -103,6 +140,29 @@ func (w *worker) initHealthz() {
 	}
 }
 
+// initOutbound serves the outbound requests of the agent when enabled, the connector must implement
+// OutboundConnector
+func (w *worker) initOutbound() error {
+	if w.config.Outbound == nil || !w.config.Outbound.Enabled {
+		return nil
+	}
+
+	oc, ok := w.connector.(OutboundConnector)
+	if !ok {
+		return ErrNotAnOutboundConnector
+	}
+
+	var token string
+	if w.config.Agent != nil {
+		token = w.config.Agent.Token
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(w.config.Outbound.path(), newOutboundHandler(oc, w.outboundDescriptors, token, w.opts.log))
+	w.outboundServer = &http.Server{Addr: w.config.Outbound.address(), Handler: mux}
+	return nil
+}
+
 func NewConnectorWorker(connector Connector, options ...Option) (ConnectorWorker, error) {
 	w := worker{
 		connector: connector,
@@ -130,5 +190,9 @@ func NewConnectorWorker(connector Connector, options ...Option) (ConnectorWorker
 
 	w.initHealthz()
 
+	if err := w.initOutbound(); err != nil {
+		return nil, err
+	}
+
 	return &w, nil
 }
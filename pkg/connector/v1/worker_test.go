@@ -32,7 +32,11 @@ func TestWorker(t *testing.T) {
 
 	_ = os.Setenv("CONNECTOR_FILE_PATH", "./fixtures/connector_config_1.yaml")
 	_ = os.Setenv("INBOUND_DESCRIPTOR_FILE_PATH", "./fixtures/inbound_descriptors_config_1.yaml")
+	_ = os.Setenv("OUTBOUND_DESCRIPTOR_FILE_PATH", "./fixtures/outbound_descriptors_config_1.yaml")
 	_ = os.Setenv("CONFIG_FILE_PATH", "./fixtures/user_config_1.yaml")
+	defer func() {
+		_ = os.Unsetenv("OUTBOUND_DESCRIPTOR_FILE_PATH")
+	}()
 
 	waitChan := make(chan struct{}, 1)
 	connector.EXPECT().Start(gomock.Any()).DoAndReturn(func(ctx connectorv1.StartContext) error {
@@ -78,6 +82,16 @@ func TestWorker(t *testing.T) {
 			}
 		})
 
+		t.Run("connector/outbound_descriptors", func(t *testing.T) {
+			descriptors := ctx.OutboundDescriptors()
+			assert.Equal(t, 2, len(descriptors))
+			for i, desc := range descriptors {
+				index := fmt.Sprint(i + 1)
+				assert.Equal(t, "outbound-message-"+index, desc.MessageName())
+				assert.Equal(t, connectorv1.MessageTypeOutbound, desc.MessageType())
+			}
+		})
+
 		t.Run("connector/forwarder", func(t *testing.T) {
 			resp, err := ctx.Forwarder().Forward("message-name-1", []byte("some-data"), map[string]string{
 				"key": "value",
@@ -1,8 +1,10 @@
 package sparkv1
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 	"github.com/pkg/errors"
@@ -22,7 +24,13 @@ const (
 )
 
 const (
-	ErrorCodeGeneric ErrorCode = "GENERIC"
+	ErrorCodeGeneric   ErrorCode = "GENERIC"
+	ErrorCodeCancelled ErrorCode = "CANCELLED"
+	ErrorCodeTimeout   ErrorCode = "TIMEOUT"
+	// ErrorCodeValidation the inputs or outputs of the spark do not match its SparkSchema
+	ErrorCodeValidation ErrorCode = "VALIDATION"
+
+	ErrorCodeParallelStagesFailed ErrorCode = "PARALLEL_STAGES_FAILED"
 )
 
 /************************************************************************/
@@ -42,6 +50,10 @@ type (
 	// Builder contract for the SparkChain builder
 	Builder interface {
 		NewChain(name string) BuilderChain
+		// Inputs declares the inputs the spark expects, they are validated before the first Stage runs
+		Inputs(inputs ...*VariableSchema) Builder
+		// Outputs declares the outputs the spark produces, they are validated once the spark completed
+		Outputs(outputs ...*VariableSchema) Builder
 		ChainFinalizer
 	}
 
@@ -58,6 +70,12 @@ type (
 	// ChainStage a Stage in the SparkChain Node
 	ChainStage interface {
 		Stage(name string, stageDefinitionFn StageDefinitionFn, options ...StageOption) ChainStageAny
+		// Parallel adds a group of Stages that are executed concurrently, the next Stage only runs once all the
+		// Stages in the group returned, use NewStage to create the Stages of the group
+		Parallel(name string, mode ParallelMode, stages ...*Stage) ChainStageAny
+		// Branch adds a step that runs only one of the branches, the selector returns the key of the branch to
+		// run, the next Stage runs once the selected branch completed
+		Branch(name string, selector BranchSelectorFn, branches map[string]Chain) ChainStageAny
 	}
 
 	// ChainStageAny allows defining more Stages and at least 1 of each Compensate, cancelled or Complete
@@ -76,6 +94,8 @@ type (
 
 	// ChainCompensate contract the builder must implement for compensation
 	ChainCompensate interface {
+		// Compensate runs the chain when a stage of the node fails, the stage at index i of the chain undoes the
+		// stage at index i of the node and only the stages of completed stages run, newest first
 		Compensate(newNode Chain) ChainCancelledOrComplete
 	}
 
@@ -100,6 +120,16 @@ type (
 	}
 )
 
+// ParallelMode describes how errors of Stages in a parallel group are handled
+type ParallelMode string
+
+const (
+	// ParallelModeFailFast cancels the remaining Stages of the group as soon as one Stage fails
+	ParallelModeFailFast ParallelMode = "fail_fast"
+	// ParallelModeCollectAll waits for all Stages of the group and reports every failure
+	ParallelModeCollectAll ParallelMode = "collect_all"
+)
+
 /************************************************************************/
 // DATA APIS
 /************************************************************************/
@@ -114,6 +144,9 @@ type (
 		GetValue() ([]byte, error)
 		GetMimeType() string
 		String() string
+		// Reader streams the raw value, large values are read from the variable store without buffering them
+		// in memory, the reader must be closed
+		Reader() (io.ReadCloser, error)
 	}
 
 	BindableConfig interface {
@@ -134,14 +167,34 @@ type (
 
 	ExecuteSparkInputs map[string]*BindableValue
 	ExecuteSparkOutput struct {
-		Error         *ExecuteSparkError `json:"error,omitempty"`
-		JobPid        *JobPid            `json:"job_pid,omitempty"`
-		VariablesKey  string             `json:"variables_key,omitempty"`
-		JobKey        string             `json:"job_key,omitempty"`
-		CorrelationId string             `json:"correlation_id,omitempty"`
-		TransactionId string             `json:"transaction_id,omitempty"`
-		Model         string             `json:"model,omitempty"`
-		Outputs       BindableMap        `json:"outputs,omitempty"`
+		Error         *ExecuteSparkError        `json:"error,omitempty"`
+		JobPid        *JobPid                   `json:"job_pid,omitempty"`
+		VariablesKey  string                    `json:"variables_key,omitempty"`
+		JobKey        string                    `json:"job_key,omitempty"`
+		CorrelationId string                    `json:"correlation_id,omitempty"`
+		TransactionId string                    `json:"transaction_id,omitempty"`
+		Model         string                    `json:"model,omitempty"`
+		Outputs       BindableMap               `json:"outputs,omitempty"`
+		Compensation  *ExecuteSparkCompensation `json:"compensation,omitempty"`
+		Cancellation  *ExecuteSparkCancellation `json:"cancellation,omitempty"`
+	}
+	// ExecuteSparkCompensation the outcome of a compensation chain that ran because a stage failed,
+	// Error is nil when the compensation chain completed successfully, the outputs of the compensation chain
+	// are stored under VariablesKey and kept apart from the outputs of the failed node
+	ExecuteSparkCompensation struct {
+		ChainName    string                    `json:"chain_name"`
+		Error        *ExecuteSparkError        `json:"error,omitempty"`
+		VariablesKey string                    `json:"variables_key,omitempty"`
+		Outputs      BindableMap               `json:"outputs,omitempty"`
+		Compensation *ExecuteSparkCompensation `json:"compensation,omitempty"`
+	}
+	// ExecuteSparkCancellation describes why and where a job was cancelled, ChainName is only set when a
+	// cancellation chain ran, Error is the error of that cancellation chain if it failed
+	ExecuteSparkCancellation struct {
+		StageName string             `json:"stage_name"`
+		Reason    string             `json:"reason"`
+		ChainName string             `json:"chain_name,omitempty"`
+		Error     *ExecuteSparkError `json:"error,omitempty"`
 	}
 	ExecuteSparkError struct {
 		StageName    string           `json:"stage_name"`
@@ -155,14 +208,55 @@ type (
 		NewInput(name, stageName string, value *BindableValue) Bindable
 		NewOutput(stageName string, value *BindableValue) (Bindable, error)
 		GetStageResult(stageName string) (Bindable, error)
-		PutStageResult(stageName string, stageValue []byte) (Bindable, error)
+		PutStageResult(stageName string, stageValue []byte, mimeType string) (Bindable, error)
+		// PutStageResultStream writes the stage result to the variable store as it is read, the result is only
+		// read back from the store when a later stage accesses it
+		PutStageResultStream(stageName string, r io.Reader, mimeType string) (Bindable, error)
 		LoadVariables(key string) error
 		GetInputValue(name string) (*BindableValue, bool)
 		SetInitialInputs(inputs ExecuteSparkInputs)
 	}
+
+	// VariableStore stores the variables, outputs and checkpoints of jobs, Get must return an error that wraps
+	// ErrVariableStoreKeyNotFound for missing keys and Delete must not fail for missing keys
+	VariableStore interface {
+		Get(ctx context.Context, key string) ([]byte, error)
+		// GetReader streams the value of the key, the reader must be closed
+		GetReader(ctx context.Context, key string) (io.ReadCloser, error)
+		Put(ctx context.Context, key string, value []byte) error
+		// PutReader stores the value read from r without buffering it entirely in memory
+		PutReader(ctx context.Context, key string, r io.Reader) error
+		Delete(ctx context.Context, key string) error
+	}
+
+	// VariableHeaders metadata a HeaderVariableStore keeps next to a value, e.g. the encoding of the value
+	VariableHeaders map[string]string
+
+	// HeaderVariableStore a VariableStore that keeps headers next to the values, the encoding of a value is kept in
+	// its headers so the value itself is stored as a plain stream, values put without headers have no headers
+	HeaderVariableStore interface {
+		VariableStore
+		// GetReaderWithHeaders streams the value of the key and returns its headers, the reader must be closed
+		GetReaderWithHeaders(ctx context.Context, key string) (io.ReadCloser, VariableHeaders, error)
+		// PutReaderWithHeaders stores the value read from r along with the headers
+		PutReaderWithHeaders(ctx context.Context, key string, r io.Reader, headers VariableHeaders) error
+	}
+
+	// KeyProvider provides the AES keys that encrypt the keys of values in the variable store, Key must still
+	// return keys that were rotated out so values encrypted with them can be read
+	KeyProvider interface {
+		// CurrentKey returns the id and the key new values are encrypted with
+		CurrentKey(ctx context.Context) (string, []byte, error)
+		// Key returns the key of the id, it must return an error that wraps ErrEncryptionKeyNotFound for
+		// unknown ids
+		Key(ctx context.Context, id string) ([]byte, error)
+	}
 )
 
 func (b *BindableValue) Bind(a any) error {
+	if b != nil && b.err != nil {
+		return b.err
+	}
 	if b == nil || b.Value == nil {
 		return nil
 	}
@@ -175,11 +269,17 @@ func (b *BindableValue) Bind(a any) error {
 }
 
 func (b *BindableValue) GetValue() ([]byte, error) {
-	return b.Value, nil
+	return b.Value, b.err
 }
 func (b *BindableValue) GetMimeType() string {
 	return b.MimeType
 }
+func (b *BindableValue) Reader() (io.ReadCloser, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return io.NopCloser(bytes.NewReader(b.Value)), nil
+}
 func (b *BindableValue) String() string {
 	// Note: This function will return empty string if the BindableValue value is not a string
 	var val string
@@ -188,12 +288,14 @@ func (b *BindableValue) String() string {
 }
 
 func NewBindable(value Value) *BindableValue {
-	return &BindableValue{MimeType: value.MimeType, Value: value.Value}
+	return &BindableValue{MimeType: value.MimeType, Value: value.Value, Ref: value.Ref}
 }
 
+// NewBindableValue encodes the value with the codec of the mime type, an encoding error is returned by GetValue,
+// Bind and Reader
 func NewBindableValue(value any, mimeType string) *BindableValue {
-	val, _ := codec.Encode(value)
-	return &BindableValue{MimeType: mimeType, Value: val}
+	val, err := codec.EncodeAs(value, codec.MimeType(mimeType))
+	return &BindableValue{MimeType: mimeType, Value: val, err: err}
 }
 
 type errorBindable struct {
@@ -210,6 +312,9 @@ func (b *errorBindable) GetValue() ([]byte, error) {
 func (b *errorBindable) GetMimeType() string {
 	return ""
 }
+func (b *errorBindable) Reader() (io.ReadCloser, error) {
+	return nil, b.err
+}
 func (b *errorBindable) String() string {
 	return b.err.Error()
 }
@@ -253,6 +358,11 @@ type (
 		Context
 		Input(names string) Input
 		StageResult(name string) Bindable
+		// CompensationError returns the error of the stage that caused a compensation chain to run,
+		// it is nil for stages that are not part of a compensation chain
+		CompensationError() *ExecuteSparkError
+		// Attempt returns the number of the current attempt of the stage, starting at 1
+		Attempt() uint
 		Log() Logger
 		Name() string
 	}
@@ -298,6 +408,9 @@ type (
 type (
 	Worker interface {
 		Run()
+		// DeadLetters lists and replays the jobs dead-lettered by the worker, it returns ErrWorkerNotRunning
+		// until Run started the worker and ErrDeadLetterNotConfigured without a dead-letter stream
+		DeadLetters() (*DeadLetters, error)
 	}
 )
 
@@ -333,9 +446,14 @@ type (
 	StageOptionParams interface {
 		StageName() string
 		Context() Context
+		// StageStatus returns the status of a stage in the current job, StageStatus_STAGE_PENDING if it did not run yet
+		StageStatus(name string) StageStatus
+		Input(name string) Input
+		StageResult(name string) Bindable
 	}
 
 	StageDefinitionFn    = func(ctx StageContext) (any, StageError)
 	CompleteDefinitionFn = func(ctx CompleteContext) StageError
+	BranchSelectorFn     = func(ctx StageContext) (string, StageError)
 	StageOption          = func(StageOptionParams) StageError
 )
@@ -9,7 +9,9 @@ import "fmt"
 type chainBuilder struct {
 	rootNode *chainNode
 	current  *chainNode
-	prev     *chainNode
+	// parents holds the Nodes that are still being built while a nested SparkChain is built
+	parents []*chainNode
+	schema  *SparkSchema
 }
 
 // chainNode wraps the SparkChain and the Node for easier access to both
@@ -21,6 +23,13 @@ type chainNode struct {
 	node *Node
 }
 
+// chainFinalizer is returned once a SparkChain Node is complete, it keeps a reference to the Node so that
+// multiple nested chains can be built before they are attached to their parent
+type chainFinalizer struct {
+	builder *chainBuilder
+	node    *Node
+}
+
 /************************************************************************/
 // BUILDER API
 /************************************************************************/
@@ -37,6 +46,49 @@ func (c *chainBuilder) Stage(name string, stageDefinitionFn StageDefinitionFn, o
 	return c
 }
 
+// Parallel adds a group of Stages to the current SparkChain Node that are executed concurrently
+func (c *chainBuilder) Parallel(name string, mode ParallelMode, stages ...*Stage) ChainStageAny {
+	if mode == "" {
+		mode = ParallelModeFailFast
+	}
+
+	for _, s := range stages {
+		s.Node = c.current.node
+	}
+
+	c.current.node.Stages = append(c.current.node.Stages, &Stage{
+		Node:     c.current.node,
+		Name:     name,
+		parallel: append([]*Stage{}, stages...),
+		mode:     mode,
+	})
+	return c
+}
+
+// Branch adds a branch step to the current SparkChain Node, each branch is a nested SparkChain and only the
+// one selected at runtime is executed
+func (c *chainBuilder) Branch(name string, selector BranchSelectorFn, branches map[string]Chain) ChainStageAny {
+	b := &Branch{
+		Name:     name,
+		Nodes:    make(map[string]*Node, len(branches)),
+		selector: selector,
+	}
+
+	for key, branch := range branches {
+		n := branch.build()
+		n.appendBreadcrumb(branchNodeType)
+		b.Nodes[key] = n
+	}
+
+	c.current.node.Branches = append(c.current.node.Branches, b)
+	c.current.node.Stages = append(c.current.node.Stages, &Stage{
+		Node:   c.current.node,
+		Name:   name,
+		branch: b,
+	})
+	return c
+}
+
 // Compensate registers a SparkChain Node at depth-1 in the SparkChain, compensation is always on the parent
 // so this function looks at the previous Node in the SparkChain which is always the parent
 func (c *chainBuilder) Compensate(newNode Chain) ChainCancelledOrComplete {
@@ -63,7 +115,8 @@ func (c *chainBuilder) Cancelled(newNode Chain) ChainComplete {
 	return c
 }
 
-// Complete returns a finalizer that can be used to build the Node SparkChain
+// Complete returns a finalizer that can be used to build the Node SparkChain, completing a nested SparkChain
+// moves the builder back to the parent Node (depth-1)
 func (c *chainBuilder) Complete(completeDefinitionFn CompleteDefinitionFn, options ...StageOption) Chain {
 	name := fmt.Sprintf("%s_complete", c.current.node.Name)
 	c.current.node.Complete = &CompleteStage{
@@ -73,7 +126,15 @@ func (c *chainBuilder) Complete(completeDefinitionFn CompleteDefinitionFn, optio
 		so:   options,
 	}
 
-	return c
+	f := &chainFinalizer{builder: c, node: c.current.node}
+
+	// move the pointer up one
+	if l := len(c.parents); l > 0 {
+		c.current = c.parents[l-1]
+		c.parents = c.parents[:l-1]
+	}
+
+	return f
 }
 
 /************************************************************************/
@@ -96,8 +157,19 @@ func (c *chainBuilder) getStages(stages []*Stage, completeStages []*CompleteStag
 	var nextNodes []*Node
 	for _, n := range nodes {
 		completeStages = appendIfNotNil(completeStages, n.Complete)
-		stages = appendIfNotNil(stages, n.Stages...)
+		for _, s := range n.Stages {
+			stages = appendIfNotNil(stages, s)
+			stages = appendIfNotNil(stages, s.parallel...)
+		}
 		nextNodes = appendIfNotNil(nextNodes, n.Compensate, n.Cancel)
+		for _, b := range n.Branches {
+			for _, key := range b.Keys() {
+				nextNodes = appendIfNotNil(nextNodes, b.Nodes[key])
+			}
+		}
+	}
+	if len(nextNodes) > 0 {
+		return c.getStages(stages, completeStages, nextNodes...)
 	}
 	return stages, completeStages
 }
@@ -114,27 +186,18 @@ func (c *chainBuilder) BuildChain() *SparkChain {
 		RootNode:    c.rootNode.node,
 		stagesMap:   map[string]*Stage{},
 		completeMap: map[string]*CompleteStage{},
+		schema:      c.schema,
 	}
 	c.createResumeOnRetryStagesMap(newChain)
 	addBreadcrumb(newChain.RootNode)
 	return newChain
 }
 
-// Build iterates over the entire SparkChain and performs the following operations
-// - Decorate the SparkChain Node with breadcrumbs
-// - Move the pointer back up the SparkChain (depth-1)
-func (c *chainBuilder) build() *Node {
-	ret := c.current
-
-	addBreadcrumb(c.rootNode.node)
+// Build decorates the SparkChain Node with breadcrumbs and returns the completed Node
+func (f *chainFinalizer) build() *Node {
+	addBreadcrumb(f.builder.rootNode.node)
 
-	// this is a finalizer so switch current back to previous Node in order to push the pointer back up the tree
-	if c.prev != nil {
-		c.current = c.prev // move the pointer up one
-		c.prev = nil       // clear previous because it should only be set when the depth of the SparkChain changes
-	}
-
-	return ret.node
+	return f.node
 }
 
 /************************************************************************/
@@ -162,13 +225,42 @@ func (c *chainBuilder) NewChain(name string) BuilderChain {
 		n.node.appendBreadcrumb(rootNodeType)
 	}
 
-	// this holds the next and previous SparkChain that is being built, it could be the root or a nested SparkChain
-	c.prev = c.current
+	// this holds the SparkChain that is being built and its parents, it could be the root or a nested SparkChain
+	if c.current != nil {
+		c.parents = append(c.parents, c.current)
+	}
 	c.current = n
 
 	return n
 }
 
+// NewStage creates a Stage that can be added to a parallel group, see ChainStage.Parallel
+func NewStage(name string, stageDefinitionFn StageDefinitionFn, options ...StageOption) *Stage {
+	return &Stage{
+		Name: name,
+		cb:   stageDefinitionFn,
+		so:   options,
+	}
+}
+
+// Inputs declares the inputs of the spark, see SparkSchema
+func (c *chainBuilder) Inputs(inputs ...*VariableSchema) Builder {
+	if c.schema == nil {
+		c.schema = &SparkSchema{}
+	}
+	c.schema.Inputs = append(c.schema.Inputs, inputs...)
+	return c
+}
+
+// Outputs declares the outputs of the spark, see SparkSchema
+func (c *chainBuilder) Outputs(outputs ...*VariableSchema) Builder {
+	if c.schema == nil {
+		c.schema = &SparkSchema{}
+	}
+	c.schema.Outputs = append(c.schema.Outputs, outputs...)
+	return c
+}
+
 // NewBuilder main entry point to the builder
 func NewBuilder() Builder {
 	return &chainBuilder{}
@@ -256,6 +256,94 @@ func (s *BuilderSuite) Test_Report_Should_Generate_Errors_On_Empty_Names() {
 	s.Equal(1, n.CountOfStages(), "must have only 1 Stage")
 }
 
+func (s *BuilderSuite) Test_Report_Should_Validate_Stage_Names_In_Parallel_Groups() {
+	noop := func(_ StageContext) (any, StageError) {
+		return nil, nil
+	}
+
+	b := NewBuilder()
+	n := b.NewChain("test-0").
+		Stage("Stage-0", noop).
+		Parallel("Group-0", ParallelModeFailFast,
+			NewStage("Stage-1", noop),
+			NewStage("Stage-0", noop),
+			NewStage("", noop),
+		).
+		Parallel("Group-1", ParallelModeCollectAll).
+		Complete(func(context CompleteContext) StageError {
+			return nil
+		}).
+		build()
+
+	// generate report for validation
+	r := generateReportForChain(b.BuildChain())
+	s.Require().NotNil(r)
+	s.Require().Len(r.Errors, 3)
+	s.Equal("duplicate Stage names are not permitted [SparkChain]: Stage-0 [at]: root > Group-0", r.Errors[0].Error())
+	s.Equal("Stage Name can not be empty [at]: root > Group-0", r.Errors[1].Error())
+	s.Equal("parallel Stage group must have at least 1 Stage [Name]: Group-1 [at]: root", r.Errors[2].Error())
+
+	s.Equal(3, n.CountOfStages())
+	s.True(n.Stages[1].IsParallel())
+	s.Len(n.Stages[1].ParallelStages(), 3)
+	s.Contains(r.StageMap, "Stage-1")
+}
+
+func (s *BuilderSuite) Test_Should_Create_Branch_Children() {
+	noop := func(_ StageContext) (any, StageError) {
+		return nil, nil
+	}
+	selector := func(_ StageContext) (string, StageError) {
+		return "a", nil
+	}
+
+	b := NewBuilder()
+	n := b.NewChain("test-0").
+		Stage("Stage-0", noop).
+		Branch("Branch-0", selector, map[string]Chain{
+			"a": b.NewChain("test-a").Stage("Stage-a", noop).Complete(CompleteSuccess),
+			"b": b.NewChain("test-b").Stage("Stage-0", noop).Complete(CompleteSuccess),
+		}).
+		Stage("Stage-1", noop).
+		Complete(CompleteSuccess).
+		build()
+
+	chain := b.BuildChain()
+	s.Equal(n, chain.RootNode)
+	s.Equal(3, n.CountOfStages())
+	s.True(n.Stages[1].IsBranch())
+	s.Equal("Stage-1", n.Stages[2].Name, "branches must not move the builder away from the parent")
+	s.Require().Len(n.Branches, 1)
+
+	a := n.Branches[0].Nodes["a"]
+	s.Require().NotNil(a)
+	s.True(a.IsBranch())
+	s.Equal("test-a", a.ChainName())
+	s.Equal("root > branch[Branch-0=a]", a.breadcrumb)
+	s.Equal("root > branch[Branch-0=b]", n.Branches[0].Nodes["b"].breadcrumb)
+	s.NotNil(chain.GetStageFunc("Stage-a"), "branch stages must be mapped")
+	s.NotNil(chain.GetStageCompleteFunc("test-b_complete"), "branch complete stages must be mapped")
+
+	// generate report for validation
+	r := generateReportForChain(chain)
+	s.Require().Len(r.Errors, 1)
+	s.Equal("duplicate Stage names are not permitted [SparkChain]: Stage-0 [at]: root > branch[Branch-0=b]", r.Errors[0].Error())
+	s.Contains(r.NodeMap, "test-a")
+	s.Contains(r.StageMap, "Branch-0")
+}
+
+func (s *BuilderSuite) Test_Report_Should_Validate_Branches() {
+	b := NewBuilder()
+	b.NewChain("test-0").
+		Branch("Branch-0", nil, map[string]Chain{}).
+		Complete(CompleteSuccess)
+
+	r := generateReportForChain(b.BuildChain())
+	s.Require().Len(r.Errors, 2)
+	s.Equal("branch must have a selector [Name]: Branch-0 [at]: root", r.Errors[0].Error())
+	s.Equal("branch must have at least 1 SparkChain [Name]: Branch-0 [at]: root", r.Errors[1].Error())
+}
+
 /************************************************************************/
 // LIFECYCLE
 /************************************************************************/
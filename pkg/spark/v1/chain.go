@@ -2,12 +2,14 @@ package sparkv1
 
 import (
 	"fmt"
+	"sort"
 )
 
 var (
 	rootNodeType       = nodeType("root")
 	compensateNodeType = nodeType("Compensate")
 	cancelNodeType     = nodeType("canceled")
+	branchNodeType     = nodeType("branch")
 )
 
 /************************************************************************/
@@ -24,6 +26,16 @@ type SparkChain struct {
 	RootNode    *Node
 	stagesMap   map[string]*Stage
 	completeMap map[string]*CompleteStage
+	// schema the declared inputs and outputs, nil if the spark declared none
+	schema *SparkSchema
+}
+
+// Schema returns the inputs and outputs the spark declared, it is empty if the spark declared none
+func (sc *SparkChain) Schema() *SparkSchema {
+	if sc.schema == nil {
+		return &SparkSchema{}
+	}
+	return sc.schema
 }
 
 func (sc *SparkChain) GetStageFunc(name string) StageDefinitionFn {
@@ -39,11 +51,13 @@ func (sc *SparkChain) GetStageCompleteFunc(name string) CompleteDefinitionFn {
 // - cancellation
 // - compensation
 // - completion (finalizer)
+// Branches holds the branch steps of the Node, they are also part of Stages to keep the order of execution
 type Node struct {
 	Stages     []*Stage
 	Complete   *CompleteStage
 	Cancel     *Node
 	Compensate *Node
+	Branches   []*Branch
 	Name       string
 	nodeType   nodeType
 	breadcrumb string
@@ -57,10 +71,20 @@ type CompleteStage struct {
 }
 
 type Stage struct {
-	Node *Node
-	Name string
-	so   []StageOption
-	cb   StageDefinitionFn
+	Node     *Node
+	Name     string
+	so       []StageOption
+	cb       StageDefinitionFn
+	parallel []*Stage
+	mode     ParallelMode
+	branch   *Branch
+}
+
+// Branch a step in a Node that executes only the Node selected by the selector
+type Branch struct {
+	Name     string
+	Nodes    map[string]*Node
+	selector BranchSelectorFn
 }
 
 /************************************************************************/
@@ -83,8 +107,9 @@ func (n *Node) appendBreadcrumb(nodeType nodeType, breadcrumb ...string) {
 // CHAIN NODE STAGE HELPERS
 /************************************************************************/
 
-func (s *Stage) ApplyConditionalExecutionOptions(ctx Context, stageName string) StageError {
-	params := newStageOptionParams(ctx, stageName)
+// ApplyConditionalExecutionOptions evaluates the options of the Stage, a returned error that wraps
+// ErrConditionalStageSkipped means the Stage must be skipped
+func (s *Stage) ApplyConditionalExecutionOptions(params StageOptionParams) StageError {
 	for _, stageOptions := range s.so {
 		if err := stageOptions(params); err != nil {
 			return err
@@ -93,6 +118,40 @@ func (s *Stage) ApplyConditionalExecutionOptions(ctx Context, stageName string)
 	return nil
 }
 
+// IsParallel returns true if the Stage is a group of Stages that are executed concurrently
+func (s *Stage) IsParallel() bool {
+	return s.parallel != nil
+}
+
+// ParallelStages returns the Stages of a parallel group
+func (s *Stage) ParallelStages() []*Stage {
+	return s.parallel
+}
+
+// IsBranch returns true if the Stage is a branch step
+func (s *Stage) IsBranch() bool {
+	return s.branch != nil
+}
+
+// Branch returns the branch of a branch step
+func (s *Stage) Branch() *Branch {
+	return s.branch
+}
+
+/************************************************************************/
+// CHAIN BRANCH HELPERS
+/************************************************************************/
+
+// Keys returns the keys of the branches in a stable order
+func (b *Branch) Keys() []string {
+	keys := make([]string, 0, len(b.Nodes))
+	for k := range b.Nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 /************************************************************************/
 // CHAIN NODE ACCESSORS
 /************************************************************************/
@@ -132,3 +191,7 @@ func (n *Node) IsCompensate() bool {
 func (n *Node) IsCancel() bool {
 	return n.nodeType == cancelNodeType
 }
+
+func (n *Node) IsBranch() bool {
+	return n.nodeType == branchNodeType
+}
@@ -0,0 +1,190 @@
+package sparkv1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// jobCheckpoint the progress of a job, the outcome of every stage is written to the variable store under a key of
+// its own so that a job that is redelivered after a crash can resume from the last completed stage
+type jobCheckpoint struct {
+	StageResults  map[string]*BindableValue
+	StageStatuses map[string]StageStatus
+}
+
+// checkpointEntry the outcome of a single stage as it is written to the variable store
+type checkpointEntry struct {
+	Status StageStatus    `json:"status"`
+	Result *BindableValue `json:"result"`
+}
+
+/************************************************************************/
+// CHECKPOINT
+/************************************************************************/
+
+// isResumable returns true if the stage completed or was skipped in a previous delivery of the job
+func (cp *jobCheckpoint) isResumable(stageName string) bool {
+	if cp == nil {
+		return false
+	}
+	status := cp.StageStatuses[stageName]
+	return status == StageStatus_STAGE_COMPLETED || status == StageStatus_STAGE_SKIPPED
+}
+
+// record adds the result of a stage to the checkpoint and returns the entry to store, results that are kept in
+// the variable store are only referenced
+func (cp *jobCheckpoint) record(stageName string, status StageStatus, result Bindable) (*checkpointEntry, error) {
+	value := &BindableValue{}
+	if sv, ok := result.(*storedValue); ok {
+		value = sv.reference()
+	} else if result != nil {
+		v, err := result.GetValue()
+		if err != nil {
+			return nil, err
+		}
+		value.Value, value.MimeType = v, result.GetMimeType()
+	}
+
+	cp.StageStatuses[stageName] = status
+	cp.StageResults[stageName] = value
+	return &checkpointEntry{Status: status, Result: value}, nil
+}
+
+// stageNames returns the names of the stages that were checkpointed
+func (cp *jobCheckpoint) stageNames() []string {
+	names := make([]string, 0, len(cp.StageStatuses))
+	for name := range cp.StageStatuses {
+		names = append(names, name)
+	}
+	return names
+}
+
+// rehydrate restores the stage results of the checkpoint into the job state and the spark io
+func (cp *jobCheckpoint) rehydrate(state *JobState, io SparkDataIO) error {
+	for name, value := range cp.StageResults {
+		if !cp.isResumable(name) {
+			continue
+		}
+
+		var (
+			res Bindable
+			err error
+		)
+		if value.Ref != "" {
+			res, err = restoreStageResult(io, name, value)
+		} else {
+			res, err = io.PutStageResult(name, value.Value, value.MimeType)
+		}
+		if err != nil {
+			return err
+		}
+
+		if state.StageResults == nil {
+			state.StageResults = make(map[string]Bindable)
+		}
+		state.StageResults[name] = res
+	}
+	return nil
+}
+
+// restoreStageResult restores a stage result that was written to the variable store by PutStageResultStream
+func restoreStageResult(io SparkDataIO, name string, value *BindableValue) (Bindable, error) {
+	iodp, ok := io.(*ioDataProvider)
+	if !ok {
+		return nil, fmt.Errorf("unable to restore the streamed result of stage %s", name)
+	}
+	return iodp.restoreStageResult(name, value), nil
+}
+
+/************************************************************************/
+// STORAGE
+/************************************************************************/
+
+func checkpointKey(jobKey, stageName string) string {
+	return fmt.Sprintf("%s.checkpoint.%s", jobKey, url.PathEscape(stageName))
+}
+
+func newJobCheckpoint() *jobCheckpoint {
+	return &jobCheckpoint{
+		StageResults:  map[string]*BindableValue{},
+		StageStatuses: map[string]StageStatus{},
+	}
+}
+
+// loadCheckpoint loads the stages that were checkpointed by a previous delivery of the job, the store is only
+// read for redelivered jobs since the first delivery of a job has no checkpoint
+func (w *jobWorkflow) loadCheckpoint(ctx context.Context, jobKey string, redelivered bool) (*jobCheckpoint, error) {
+	cp := newJobCheckpoint()
+	if w.store == nil || jobKey == "" || !redelivered {
+		return cp, nil
+	}
+
+	for name := range w.Chain.stagesMap {
+		b, err := w.store.Get(ctx, checkpointKey(jobKey, name))
+		if err != nil {
+			if errors.Is(err, ErrVariableStoreKeyNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		var entry checkpointEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return nil, err
+		}
+		cp.StageStatuses[name] = entry.Status
+		cp.StageResults[name] = entry.Result
+	}
+	return cp, nil
+}
+
+// saveCheckpoint stores the outcome of a stage, a failure is logged but does not fail the job since the only
+// consequence is that the stage may run again if the job is redelivered
+func (w *jobWorkflow) saveCheckpoint(ctx context.Context, jobKey, stageName string, entry *checkpointEntry) {
+	if w.store == nil || jobKey == "" {
+		return
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to marshal checkpoint of stage %s for job %s", stageName, jobKey)
+		return
+	}
+
+	if err := w.store.Put(ctx, checkpointKey(jobKey, stageName), b); err != nil {
+		log.Error().Err(err).Msgf("unable to store checkpoint of stage %s for job %s", stageName, jobKey)
+	}
+}
+
+// deleteCheckpoint removes the checkpoint once the job reached a terminal state, every stage of the chain is
+// removed when the checkpoint of the job was not loaded
+func (w *jobWorkflow) deleteCheckpoint(ctx context.Context, jobKey string, cp *jobCheckpoint) {
+	if w.store == nil || jobKey == "" {
+		return
+	}
+
+	var names []string
+	if cp != nil {
+		names = cp.stageNames()
+	} else {
+		for name := range w.Chain.stagesMap {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		err := w.store.Delete(ctx, checkpointKey(jobKey, name))
+		if err != nil && !errors.Is(err, ErrVariableStoreKeyNotFound) {
+			log.Error().Err(err).Msgf("unable to delete checkpoint of stage %s for job %s", name, jobKey)
+		}
+	}
+}
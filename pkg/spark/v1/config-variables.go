@@ -0,0 +1,231 @@
+package sparkv1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/getkin/kin-openapi/openapi3"
+	"gopkg.in/yaml.v3"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// VariableType the basic types of the inputs and outputs declared in the spark.yaml
+type VariableType string
+
+const (
+	VariableTypeString  VariableType = "string"
+	VariableTypeBoolean VariableType = "boolean"
+	VariableTypeNumber  VariableType = "number"
+	VariableTypeInteger VariableType = "integer"
+	VariableTypeArray   VariableType = "array"
+	VariableTypeObject  VariableType = "object"
+)
+
+var (
+	ErrInvalidVariableDeclaration = errors.New("invalid variable declaration")
+)
+
+/************************************************************************/
+// DECLARATIONS
+/************************************************************************/
+
+// sparkSchema creates the schema of the inputs and outputs declared in the spark.yaml, variables whose condition
+// does not hold for the config of the spark are not declared. Inputs are required if they are marked as required
+// and declared outputs may be omitted by the spark
+func (m *Config) sparkSchema(config BindableConfig) (*SparkSchema, error) {
+	if len(m.Inputs) == 0 && len(m.Outputs) == 0 {
+		return nil, nil
+	}
+
+	cfg, err := conditionConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &SparkSchema{}
+	for _, name := range sortedNames(m.Inputs) {
+		in := m.Inputs[name]
+		vs, err := m.variableSchema(name, in.Type, in.Schema, in.Condition, cfg, in.MimeTypes...)
+		if err != nil {
+			return nil, err
+		}
+		if vs != nil {
+			vs.Required = in.Required
+			schema.Inputs = append(schema.Inputs, vs)
+		}
+	}
+
+	for _, name := range sortedNames(m.Outputs) {
+		out := m.Outputs[name]
+		vs, err := m.variableSchema(name, out.Type, out.Schema, out.Condition, cfg, out.MimeType)
+		if err != nil {
+			return nil, err
+		}
+		if vs != nil {
+			schema.Outputs = append(schema.Outputs, vs)
+		}
+	}
+
+	return schema, nil
+}
+
+// variableSchema returns nil if the condition of the variable does not hold, the schema file takes precedence
+// over the type of the variable
+func (m *Config) variableSchema(name string, t VariableType, schemaFile, condition string, cfg any, mimeTypes ...codec.MimeType) (*VariableSchema, error) {
+	ok, err := evaluateCondition(condition, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidVariableDeclaration, name, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	var vs *VariableSchema
+	switch {
+	case schemaFile != "":
+		b, err := m.readSchemaFile(schemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: unable to read schema (%s): %w", ErrInvalidVariableDeclaration, name,
+				schemaFile, err)
+		}
+		vs = NewVariableSchemaFromJSON(name, b)
+	case t != "":
+		switch t {
+		case VariableTypeString, VariableTypeBoolean, VariableTypeNumber, VariableTypeInteger, VariableTypeArray,
+			VariableTypeObject:
+			vs = &VariableSchema{Name: name, Schema: &openapi3.Schema{Type: string(t)}}
+		default:
+			return nil, fmt.Errorf("%w: %s: unknown type (%s)", ErrInvalidVariableDeclaration, name, t)
+		}
+	default:
+		vs = &VariableSchema{Name: name}
+	}
+
+	// mime types only describe the encoding of objects, basic types are encoded by the platform
+	if t == "" || t == VariableTypeObject {
+		for _, mt := range mimeTypes {
+			if mt != "" {
+				vs.WithMimeTypes(mt)
+			}
+		}
+	}
+	return vs, nil
+}
+
+// readSchemaFile reads a json or yaml schema file relative to the spark.yaml
+func (m *Config) readSchemaFile(file string) ([]byte, error) {
+	if !path.IsAbs(file) {
+		file = path.Join(m.basePath, file)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if ext := path.Ext(file); ext != ".yaml" && ext != ".yml" {
+		return b, nil
+	}
+
+	var v any
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+/************************************************************************/
+// CONDITIONS
+/************************************************************************/
+
+// conditionConfig decodes the config of the spark into the types of encoding/json, yaml is a superset of json
+// so both config formats are decoded as yaml
+func conditionConfig(config BindableConfig) (any, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	raw, err := config.Raw()
+	if err != nil || len(raw) == 0 {
+		return nil, err
+	}
+
+	var v any
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("unable to decode the config of the spark: %w", err)
+	}
+	if raw, err = json.Marshal(v); err != nil {
+		return nil, err
+	}
+	return v, json.Unmarshal(raw, &v)
+}
+
+// evaluateCondition evaluates conditions in the form of `config.path.to.key == "value"`, `!=` negates the
+// comparison and a path without an operator holds when the value at the path is set and not false, zero or an
+// empty string
+func evaluateCondition(condition string, cfg any) (bool, error) {
+	condition = strings.TrimSpace(condition)
+	if condition == "" {
+		return true, nil
+	}
+
+	expr, op := condition, ""
+	for _, o := range []string{"==", "!="} {
+		if l, _, ok := strings.Cut(condition, o); ok && (op == "" || len(l) < len(expr)) {
+			expr, op = l, o
+		}
+	}
+
+	value, err := conditionValue(strings.TrimSpace(expr), cfg)
+	if err != nil {
+		return false, err
+	}
+
+	if op == "" {
+		return value != nil && value != false && value != "" && value != float64(0), nil
+	}
+
+	_, literal, _ := strings.Cut(condition, op)
+	var expected any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(literal)), &expected); err != nil {
+		return false, fmt.Errorf("invalid condition (%s), expected a json literal: %w", condition, err)
+	}
+
+	return reflect.DeepEqual(value, expected) != (op == "!="), nil
+}
+
+// conditionValue returns the value at the path of the config, nil if any key of the path is missing
+func conditionValue(expr string, cfg any) (any, error) {
+	keys := strings.Split(expr, ".")
+	if keys[0] != "config" {
+		return nil, fmt.Errorf("invalid condition (%s), expected a path starting with config", expr)
+	}
+
+	value := cfg
+	for _, key := range keys[1:] {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, nil
+		}
+		value = m[key]
+	}
+	return value, nil
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,233 @@
+package sparkv1
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSparkYaml = `
+inputs:
+  name:
+    type: string
+    schema: "name-schema.json"
+    required: true
+  customer:
+    type: object
+    mime_types: [ "application/json", "application/yaml" ]
+    schema: "customer-schema.yaml"
+  hidden:
+    type: string
+    required: true
+    condition: config.someObject.someKey == "someValue"
+  debug:
+    type: boolean
+    required: true
+    condition: config.debug
+outputs:
+  message:
+    type: string
+    mime_type: "application/json"
+  report:
+    type: object
+    mime_type: "application/json"
+    condition: config.someObject.someKey != "someValue"
+`
+
+func writeSparkYaml(t *testing.T, spec string) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "spark.yaml"), []byte(spec), 0o600))
+	require.NoError(t, os.WriteFile(path.Join(dir, "name-schema.json"), []byte(`{"type":"string","minLength":3}`), 0o600))
+	require.NoError(t, os.WriteFile(path.Join(dir, "customer-schema.yaml"),
+		[]byte("type: object\nrequired: [ id ]\nproperties:\n  id:\n    type: integer\n"), 0o600))
+	t.Setenv("SPARK_FILE_PATH", path.Join(dir, "spark.yaml"))
+}
+
+func loadTestSparkSchema(t *testing.T, sparkConfig any) *SparkSchema {
+	opts := &SparkOpts{}
+	if sparkConfig != nil {
+		opts = WithSparkConfig(sparkConfig)(opts)
+	}
+
+	cfg, err := loadSparkConfig(opts)
+	require.NoError(t, err)
+	schema, err := cfg.sparkSchema(newBindableConfig(opts))
+	require.NoError(t, err)
+	return schema
+}
+
+func TestConfigVariables(t *testing.T) {
+	t.Run("load declarations", func(t *testing.T) {
+		writeSparkYaml(t, testSparkYaml)
+		schema := loadTestSparkSchema(t, nil)
+
+		require.Len(t, schema.Inputs, 2)
+		assert.Equal(t, "customer", schema.Inputs[0].Name)
+		assert.False(t, schema.Inputs[0].Required)
+		assert.Equal(t, []codec.MimeType{codec.MimeTypeJson, codec.MimeTypeYaml}, schema.Inputs[0].MimeTypes)
+		assert.Equal(t, []string{"id"}, schema.Inputs[0].Schema.Required)
+		assert.Equal(t, "name", schema.Inputs[1].Name)
+		assert.True(t, schema.Inputs[1].Required)
+		assert.Equal(t, uint64(3), schema.Inputs[1].Schema.MinLength)
+		assert.Empty(t, schema.Inputs[1].MimeTypes, "mime types are only enforced for objects")
+
+		require.Len(t, schema.Outputs, 2)
+		assert.Equal(t, "message", schema.Outputs[0].Name)
+		assert.Equal(t, "report", schema.Outputs[1].Name)
+		assert.Equal(t, []codec.MimeType{codec.MimeTypeJson}, schema.Outputs[1].MimeTypes)
+	})
+
+	t.Run("schema paths of SPARK_SECRET are relative to the config base path", func(t *testing.T) {
+		writeSparkYaml(t, testSparkYaml)
+		dir := path.Dir(os.Getenv("SPARK_FILE_PATH"))
+		t.Setenv("SPARK_FILE_PATH", "")
+		t.Setenv("SPARK_SECRET", base64.StdEncoding.EncodeToString([]byte(testSparkYaml)))
+
+		cfg, err := loadSparkConfig(&SparkOpts{configBasePath: dir})
+		require.NoError(t, err)
+		schema, err := cfg.sparkSchema(nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"id"}, schema.Inputs[0].Schema.Required)
+	})
+
+	t.Run("conditions use the spark config", func(t *testing.T) {
+		writeSparkYaml(t, testSparkYaml)
+		schema := loadTestSparkSchema(t, map[string]any{
+			"debug":      true,
+			"someObject": map[string]any{"someKey": "someValue"},
+		})
+
+		var inputs []string
+		for _, vs := range schema.Inputs {
+			inputs = append(inputs, vs.Name)
+		}
+		assert.Equal(t, []string{"customer", "debug", "hidden", "name"}, inputs)
+		require.Len(t, schema.Outputs, 1)
+		assert.Equal(t, "message", schema.Outputs[0].Name)
+	})
+
+	t.Run("enforce inputs", func(t *testing.T) {
+		writeSparkYaml(t, testSparkYaml)
+		schema := loadTestSparkSchema(t, nil)
+
+		io := newIoDataProvider(context.Background(), NewMemoryVariableStore())
+		io.SetInitialInputs(ExecuteSparkInputs{
+			"customer": {Value: []byte(`{"id":"1"}`), MimeType: string(codec.MimeTypeText)},
+		})
+		err := schema.validateInputs(io)
+		require.ErrorIs(t, err, ErrSchemaValidation)
+		assert.Equal(t, []any{
+			map[string]any{"variable": "customer", "message": "mime type application/text is not allowed, expected one of [application/json application/yaml]"},
+			map[string]any{"variable": "name", "message": "variable is required"},
+		}, err.Metadata()["violations"])
+
+		io.SetInitialInputs(ExecuteSparkInputs{
+			"customer": {Value: []byte("id: 1\n"), MimeType: string(codec.MimeTypeYaml)},
+			"name":     {Value: []byte(`"Bob"`), MimeType: string(codec.MimeTypeJson)},
+		})
+		assert.NoError(t, schema.validateInputs(io))
+
+		io.SetInitialInputs(ExecuteSparkInputs{
+			"customer": {Value: []byte(`{"id":1}`), MimeType: string(codec.MimeTypeJson.WithType("text"))},
+			"name":     {Value: []byte(`"Bo"`), MimeType: string(codec.MimeTypeJson)},
+		})
+		err = schema.validateInputs(io)
+		require.ErrorIs(t, err, ErrSchemaValidation)
+		assert.Equal(t, []any{
+			map[string]any{"variable": "name", "message": "minimum string length is 3"},
+		}, err.Metadata()["violations"])
+	})
+
+	t.Run("enforce outputs", func(t *testing.T) {
+		writeSparkYaml(t, testSparkYaml)
+		schema := loadTestSparkSchema(t, nil)
+
+		err := schema.validateOutputs("complete", BindableMap{
+			"message": NewBindable(Value{Value: []byte(`"hi"`), MimeType: string(codec.MimeTypeJson)}),
+			"report":  NewBindable(Value{Value: []byte("a: b"), MimeType: string(codec.MimeTypeYaml)}),
+			"extra":   NewBindable(Value{Value: []byte(`1`), MimeType: string(codec.MimeTypeJson)}),
+		})
+		require.ErrorIs(t, err, ErrSchemaValidation)
+		assert.Equal(t, "complete", err.StageName())
+		assert.Equal(t, []any{
+			map[string]any{"variable": "report", "message": "mime type application/yaml is not allowed, expected one of [application/json]"},
+			map[string]any{"variable": "extra", "message": "variable is not declared"},
+		}, err.Metadata()["violations"])
+
+		assert.NoError(t, schema.validateOutputs("complete", BindableMap{
+			"report": NewBindable(Value{Value: []byte(`{}`), MimeType: string(codec.MimeTypeJson)}),
+		}), "declared outputs may be omitted")
+	})
+
+	t.Run("invalid declarations", func(t *testing.T) {
+		for _, spec := range []string{
+			"inputs:\n  name:\n    type: decimal\n",
+			"inputs:\n  name:\n    schema: missing.json\n",
+			"outputs:\n  name:\n    condition: settings.key == 1\n",
+			"outputs:\n  name:\n    condition: config.key == value\n",
+		} {
+			writeSparkYaml(t, spec)
+			cfg, err := loadSparkConfig(&SparkOpts{})
+			require.NoError(t, err)
+			_, err = cfg.sparkSchema(nil)
+			assert.ErrorIs(t, err, ErrInvalidVariableDeclaration, spec)
+		}
+	})
+
+	t.Run("evaluate conditions", func(t *testing.T) {
+		cfg := map[string]any{"a": map[string]any{"b": "c", "n": float64(2), "f": false}}
+		for _, tc := range []struct {
+			condition string
+			expected  bool
+		}{
+			{"", true},
+			{`config.a.b == "c"`, true},
+			{`config.a.b != "c"`, false},
+			{`config.a.n == 2`, true},
+			{`config.a.f == false`, true},
+			{`config.a.missing == "c"`, false},
+			{`config.a.b.c == "c"`, false},
+			{`config.a.b`, true},
+			{`config.a.f`, false},
+			{`config.missing`, false},
+		} {
+			ok, err := evaluateCondition(tc.condition, cfg)
+			require.NoError(t, err, tc.condition)
+			assert.Equal(t, tc.expected, ok, tc.condition)
+		}
+	})
+
+	t.Run("worker declares the spark.yaml variables", func(t *testing.T) {
+		writeSparkYaml(t, testSparkYaml)
+		w, err := NewSparkWorker(context.Background(), &schemaSpark{})
+		require.NoError(t, err)
+		schema := w.(*sparkWorker).chain.Schema()
+		assert.Len(t, schema.Inputs, 3)
+		assert.Len(t, schema.Outputs, 2)
+
+		writeSparkYaml(t, "inputs:\n  name:\n    schema: missing.json\n")
+		_, err = NewSparkWorker(context.Background(), &schemaSpark{})
+		assert.ErrorIs(t, err, ErrInvalidVariableDeclaration)
+	})
+}
+
+type schemaSpark struct{}
+
+func (s *schemaSpark) BuildChain(b Builder) Chain {
+	b.Inputs(NewVariableSchema[string]("builder").Optional())
+	return b.NewChain("main").
+		Stage("stage", func(_ StageContext) (any, StageError) { return nil, nil }).
+		Complete(CompleteSuccess)
+}
+
+func (s *schemaSpark) Init(_ InitContext) error {
+	return nil
+}
+
+func (s *schemaSpark) Stop() {}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 	"github.com/sethvargo/go-envconfig"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -19,22 +20,34 @@ import (
 /************************************************************************/
 
 type Config struct {
-	Id                     string        `yaml:"id"`
-	Name                   string        `yaml:"Name"`
-	NatsRequestSubject     string        `yaml:"nats_request_subject"`
-	NatsResponseSubject    string        `yaml:"nats_response_subject"`
-	NatsRequestStreamName  string        `yaml:"nats_request_stream_name"`
-	NatsResponseStreamName string        `yaml:"nats_response_stream_name"`
-	NatsBucket             string        `yaml:"nats_bucket"`
-	RetryCount             uint          `yaml:"retry_count"`
-	RetryBackoff           time.Duration `yaml:"retry_backoff"`
-	RetryBackoffMultiplier uint          `yaml:"retry_backoff_multiplier"`
-	Timeout                time.Duration `yaml:"timeout"`
-	Health                 *configHealth `yaml:"health"`
-	Server                 *configServer `yaml:"plugin"`
-	Log                    *configLog    `yaml:"logging"`
-	App                    *configApp    `yaml:"app"`
-	Nats                   *configNats   `yaml:"nats"`
+	Id                       string        `yaml:"id"`
+	Name                     string        `yaml:"Name"`
+	NatsRequestSubject       string        `yaml:"nats_request_subject"`
+	NatsResponseSubject      string        `yaml:"nats_response_subject"`
+	NatsRequestStreamName    string        `yaml:"nats_request_stream_name"`
+	NatsResponseStreamName   string        `yaml:"nats_response_stream_name"`
+	NatsBucket               string        `yaml:"nats_bucket"`
+	NatsCancelSubject        string        `yaml:"nats_cancel_subject"`
+	NatsDeadLetterSubject    string        `yaml:"nats_dead_letter_subject"`
+	NatsDeadLetterStreamName string        `yaml:"nats_dead_letter_stream_name"`
+	RetryCount               uint          `yaml:"retry_count"`
+	RetryBackoff             time.Duration `yaml:"retry_backoff"`
+	RetryBackoffMultiplier   uint          `yaml:"retry_backoff_multiplier"`
+	Timeout                  time.Duration `yaml:"timeout"`
+	CancelTimeout            time.Duration `yaml:"cancel_timeout"`
+	MaxConcurrentJobs        int           `yaml:"max_concurrent_jobs"`
+	DrainTimeout             time.Duration `yaml:"drain_timeout"`
+	Health                   *configHealth `yaml:"health"`
+	Server                   *configServer `yaml:"plugin"`
+	Log                      *configLog    `yaml:"logging"`
+	App                      *configApp    `yaml:"app"`
+	Nats                     *configNats   `yaml:"nats"`
+	Store                    *configStore  `yaml:"store"`
+	// Inputs and Outputs declare the variables of the spark by their name, see Config.sparkSchema
+	Inputs  map[string]*configInput  `yaml:"inputs"`
+	Outputs map[string]*configOutput `yaml:"outputs"`
+	// basePath the directory schema files of the inputs and outputs are relative to
+	basePath string
 }
 
 type configHealth struct {
@@ -63,6 +76,44 @@ type configNats struct {
 	Address string `yaml:"address"`
 }
 
+type configStore struct {
+	Type VariableStoreType `env:"STORE_TYPE" yaml:"type"`
+	Path string            `env:"STORE_PATH" yaml:"path"`
+	// Encoding compresses values of at least EncodingThreshold bytes
+	Encoding          ContentEncoding `env:"STORE_ENCODING" yaml:"encoding"`
+	EncodingThreshold int             `env:"STORE_ENCODING_THRESHOLD" yaml:"encoding_threshold"`
+	// Encryption encrypts all values at rest
+	Encryption *configEncryption `yaml:"encryption"`
+}
+
+type configEncryption struct {
+	// KeyId the id of the key new values are encrypted with
+	KeyId string `env:"STORE_ENCRYPTION_KEY_ID" yaml:"key_id"`
+	// Keys the base64 encoded AES keys by their id, keys that were rotated out must be kept to read older values
+	Keys map[string]string `env:"STORE_ENCRYPTION_KEYS" yaml:"keys"`
+}
+
+type configInput struct {
+	Label string       `yaml:"label"`
+	Type  VariableType `yaml:"type"`
+	// MimeTypes are only enforced for variables of VariableTypeObject
+	MimeTypes []codec.MimeType `yaml:"mime_types"`
+	// Schema the path of a json or yaml OpenAPI 3.0 schema file
+	Schema   string `yaml:"schema"`
+	Required bool   `yaml:"required"`
+	// Condition the variable is only declared when the condition holds for the config of the spark
+	Condition string `yaml:"condition"`
+}
+
+type configOutput struct {
+	Label string       `yaml:"label"`
+	Type  VariableType `yaml:"type"`
+	// MimeType is only enforced for variables of VariableTypeObject
+	MimeType  codec.MimeType `yaml:"mime_type"`
+	Schema    string         `yaml:"schema"`
+	Condition string         `yaml:"condition"`
+}
+
 func (m *Config) serverAddress() string {
 	return fmt.Sprintf("%s:%d", m.Server.Bind, m.Server.Port)
 }
@@ -71,6 +122,54 @@ func (m *Config) healthBindTo() string {
 	return fmt.Sprintf("%s:%d", m.Health.Bind, m.Health.Port)
 }
 
+func (m *Config) maxConcurrentJobs() int {
+	if m.MaxConcurrentJobs > 0 {
+		return m.MaxConcurrentJobs
+	}
+	return defaultMaxConcurrentJobs
+}
+
+func (m *Config) variableStoreType() VariableStoreType {
+	if m.Store == nil || m.Store.Type == "" {
+		return VariableStoreTypeJetStream
+	}
+	return m.Store.Type
+}
+
+func (m *Config) contentEncoding() (ContentEncoding, int) {
+	if m.Store == nil {
+		return ContentEncodingNone, defaultEncodingThreshold
+	}
+	if m.Store.EncodingThreshold > 0 {
+		return m.Store.Encoding, m.Store.EncodingThreshold
+	}
+	return m.Store.Encoding, defaultEncodingThreshold
+}
+
+// keyProvider returns the keys of the encryption config, values are not encrypted if no key id is configured
+func (m *Config) keyProvider() (KeyProvider, error) {
+	if m.Store == nil || m.Store.Encryption == nil || m.Store.Encryption.KeyId == "" {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte, len(m.Store.Encryption.Keys))
+	for id, encoded := range m.Store.Encryption.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidEncryptionKey, id, err)
+		}
+		keys[id] = key
+	}
+	return NewStaticKeyProvider(m.Store.Encryption.KeyId, keys)
+}
+
+func (m *Config) drainTimeout() time.Duration {
+	if m.DrainTimeout > 0 {
+		return m.DrainTimeout
+	}
+	return defaultDrainTimeout
+}
+
 func loadSparkConfig(opts *SparkOpts) (*Config, error) {
 	config := &Config{}
 
@@ -82,6 +181,7 @@ func loadSparkConfig(opts *SparkOpts) (*Config, error) {
 		if err := yaml.Unmarshal(b, &config); err != nil {
 			return nil, err
 		}
+		config.basePath = path.Dir(os.Getenv("SPARK_FILE_PATH"))
 		return config, nil
 	}
 
@@ -94,6 +194,7 @@ func loadSparkConfig(opts *SparkOpts) (*Config, error) {
 		if err := yaml.Unmarshal(secret, &config); err != nil {
 			return nil, err
 		}
+		config.basePath = opts.configBasePath
 		return config, nil
 	}
 
@@ -107,6 +208,7 @@ func loadSparkConfig(opts *SparkOpts) (*Config, error) {
 		if err := yaml.Unmarshal(b, &config); err != nil {
 			return nil, err
 		}
+		config.basePath = opts.configBasePath
 	}
 
 	if err := envconfig.Process(context.Background(), config); err != nil {
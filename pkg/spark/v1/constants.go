@@ -6,6 +6,13 @@ const maxInactiveConsumerDuration = time.Hour
 const maxInactiveResetConsumerDuration = maxInactiveConsumerDuration / 2
 const maxConsumerFetchWait = time.Second * 15
 const ConsumerBatch = 15
-const maxConsumerDeliver = 1
-const maxConsumerAckPending = 1
+const maxConsumerDeliver = 3
+const consumerAckWait = time.Second * 30
+const consumerInProgressInterval = consumerAckWait / 3
+const consumerNakDelay = time.Second
+const maxConsumerNakDelay = time.Second * 30
+const defaultMaxConcurrentJobs = 1
+const defaultDrainTimeout = time.Second * 30
 const maxConsumerCreationRetries = 3
+const defaultCancelTimeout = time.Second * 30
+const defaultEncodingThreshold = 1024
@@ -1,6 +1,9 @@
 package sparkv1
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
 /************************************************************************/
 // JOB CONTEXT
@@ -114,16 +117,29 @@ func (sc stageContext) TransactionID() string {
 	return sc.ExecuteStageRequest.TransactionId
 }
 
+// Input returns an empty input if the job has no input with the name, use InputAs to fail on missing inputs
 func (sc stageContext) Input(name string) Input {
-	if sc.sparkDataIO == nil {
+	in, err := sc.lookupInput(name)
+	if err != nil {
 		return &BindableValue{}
 	}
+	return in
+}
+
+func (sc stageContext) lookupInput(name string) (Input, error) {
+	if sc.sparkDataIO == nil {
+		return nil, fmt.Errorf("%w: stage (%s), name (%s)", ErrVariableNotFound, sc.name, name)
+	}
 	in, ok := sc.sparkDataIO.GetInputValue(name)
 	if !ok {
-		return &BindableValue{}
+		return nil, fmt.Errorf("%w: stage (%s), name (%s)", ErrVariableNotFound, sc.name, name)
 	}
 
-	return in
+	// inputs that are stored separately are only read when they are accessed
+	if in.Ref != "" {
+		return sc.sparkDataIO.NewInput(name, sc.name, in), nil
+	}
+	return in, nil
 }
 
 func (sc stageContext) StageResult(name string) Bindable {
@@ -134,6 +150,17 @@ func (sc stageContext) StageResult(name string) Bindable {
 	return result
 }
 
+func (sc stageContext) CompensationError() *ExecuteSparkError {
+	return sc.ExecuteStageRequest.FailedStageError
+}
+
+func (sc stageContext) Attempt() uint {
+	if sc.ExecuteStageRequest.Attempt == 0 {
+		return 1
+	}
+	return sc.ExecuteStageRequest.Attempt
+}
+
 func (sc stageContext) Name() string {
 	return sc.name
 }
@@ -148,6 +175,11 @@ type completeContext struct {
 }
 
 func (cc *completeContext) Output(variables ...*Var) error {
+	for _, v := range variables {
+		if v.err != nil {
+			return fmt.Errorf("unable to encode output %s: %w", v.Name, v.err)
+		}
+	}
 	cc.outputs = append(cc.outputs, variables...)
 	return nil
 }
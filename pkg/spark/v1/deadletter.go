@@ -0,0 +1,308 @@
+package sparkv1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// HeaderDeadLetterReason the reason the job could not be processed
+	HeaderDeadLetterReason = "Spark-Dead-Letter-Reason"
+	// HeaderDeadLetterSubject the subject the job request was originally received on
+	HeaderDeadLetterSubject = "Spark-Dead-Letter-Subject"
+	// HeaderDeadLetterSparkId the id of the spark that dead-lettered the job
+	HeaderDeadLetterSparkId = "Spark-Dead-Letter-Spark-Id"
+	// HeaderDeadLetterDeliveries the number of times the job request was delivered before it was dead-lettered
+	HeaderDeadLetterDeliveries = "Spark-Dead-Letter-Deliveries"
+
+	// maxDeliveriesAdvisorySubject the subject of the advisories of a consumer of a stream that reached MaxDeliver
+	maxDeliveriesAdvisorySubject = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.%s.%s"
+)
+
+var (
+	ErrDeadLetterNotConfigured = errors.New("dead-letter stream is not configured")
+	ErrMaxDeliveriesExceeded   = errors.New("job request was not acknowledged within its maximum deliveries")
+	ErrWorkerNotRunning        = errors.New("worker is not running")
+)
+
+// nakBackoff the delay before a job that could not be processed is delivered again
+var nakBackoff = &RetryConfig{
+	FirstBackoffWait:  consumerNakDelay,
+	BackoffMultiplier: 2,
+	MaxBackoffWait:    maxConsumerNakDelay,
+}
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// DeadLetter a job request that could not be processed, the original payload and headers are kept so it can be
+// replayed
+type DeadLetter struct {
+	Sequence  uint64
+	Subject   string
+	Reason    string
+	Headers   nats.Header
+	Data      []byte
+	Timestamp time.Time
+}
+
+// DeadLetters lists and replays the jobs that were dead-lettered by a JobWorkflow
+type DeadLetters struct {
+	js     jetstream.JetStream
+	stream string
+	wf     JobWorkflow
+}
+
+// deadLetterMsg a dead-lettered job request that is replayed through the workflow, it is always treated as the
+// last delivery so a job that fails again is dead-lettered again instead of being redelivered
+type deadLetterMsg struct {
+	dl *DeadLetter
+}
+
+/************************************************************************/
+// WORKFLOW
+/************************************************************************/
+
+// reject handles a job that can not be processed, the job is redelivered with a growing delay until its last
+// delivery after which it is dead-lettered and an error is published for it
+func (w *jobWorkflow) reject(msg jetstream.Msg, jmd *JobMetadata, io *ioDataProvider, err error) {
+	deliveries := numDelivered(msg)
+	if deliveries < maxConsumerDeliver {
+		delay := nakBackoff.backoff(uint(deliveries))
+		log.Warn().Err(err).Msgf("unable to process job %s, it will be redelivered in %s", jmd.JobKeyValue, delay)
+		if err := msg.NakWithDelay(delay); err != nil {
+			log.Error().Err(err).Msgf("failed to reject job request")
+		}
+		return
+	}
+
+	w.deadLetter(msg, deliveries, err)
+	w.publishError(jmd, err)
+	w.discard(jmd, io)
+	w.ack(msg)
+}
+
+// deadLetterExhausted dead-letters a job request that used up its deliveries without being acknowledged, either
+// because the spark crashed or because the job did not complete within the ack wait on its last delivery
+func (w *jobWorkflow) deadLetterExhausted(msg jetstream.Msg, deliveries uint64) {
+	var jmd *JobMetadata
+	if err := json.Unmarshal(msg.Data(), &jmd); err != nil {
+		jmd = nil
+	}
+
+	w.deadLetter(msg, deliveries, ErrMaxDeliveriesExceeded)
+	w.publishError(jmd, ErrMaxDeliveriesExceeded)
+	if jmd != nil {
+		w.discard(jmd, nil)
+	}
+}
+
+// deadLetter publishes the job request to Config.NatsDeadLetterSubject along with the reason it failed, the
+// subject must be captured by the Config.NatsDeadLetterStreamName stream
+func (w *jobWorkflow) deadLetter(msg jetstream.Msg, deliveries uint64, reason error) {
+	if w.js == nil || w.cfg == nil || w.cfg.NatsDeadLetterSubject == "" {
+		log.Error().Err(reason).Msgf("job request could not be processed and no dead-letter subject is configured, the job is lost")
+		return
+	}
+
+	dl := nats.NewMsg(w.cfg.NatsDeadLetterSubject)
+	dl.Data = msg.Data()
+	for k, v := range msg.Headers() {
+		dl.Header[k] = v
+	}
+	dl.Header.Set(HeaderDeadLetterReason, reason.Error())
+	dl.Header.Set(HeaderDeadLetterSubject, msg.Subject())
+	dl.Header.Set(HeaderDeadLetterSparkId, w.SparkId)
+	dl.Header.Set(HeaderDeadLetterDeliveries, strconv.FormatUint(deliveries, 10))
+
+	ctx, cancel := w.newDetachedContext()
+	defer cancel()
+
+	if _, err := w.js.PublishMsg(ctx, dl); err != nil {
+		log.Error().Err(err).Msgf("failed to publish job request to the dead-letter subject, the job is lost")
+	}
+}
+
+// maxDeliveriesAdvisory the advisory JetStream publishes when a consumer stops delivering a message because it
+// reached MaxDeliver
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// handleMaxDeliveries dead-letters the job request of the advisory and removes it from the request stream
+func handleMaxDeliveries(ctx context.Context, stream jetstream.Stream, wf *jobWorkflow, data []byte) error {
+	var advisory maxDeliveriesAdvisory
+	if err := json.Unmarshal(data, &advisory); err != nil {
+		return err
+	}
+
+	raw, err := stream.GetMsg(ctx, advisory.StreamSeq)
+	if err != nil {
+		// the job was acknowledged in the meantime
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	wf.deadLetterExhausted(&deadLetterMsg{dl: &DeadLetter{
+		Sequence:  raw.Sequence,
+		Subject:   raw.Subject,
+		Headers:   raw.Header,
+		Data:      raw.Data,
+		Timestamp: raw.Time,
+	}}, advisory.Deliveries)
+
+	return stream.DeleteMsg(ctx, advisory.StreamSeq)
+}
+
+// numDelivered returns the number of times the message was delivered, messages without metadata are treated as
+// being on their last delivery
+func numDelivered(msg jetstream.Msg) uint64 {
+	md, err := msg.Metadata()
+	if err != nil || md == nil {
+		return maxConsumerDeliver
+	}
+	return md.NumDelivered
+}
+
+/************************************************************************/
+// DEAD LETTERS
+/************************************************************************/
+
+// List returns the dead-lettered jobs in the order they were dead-lettered
+func (d *DeadLetters) List(ctx context.Context) ([]*DeadLetter, error) {
+	stream, err := d.js.Stream(ctx, d.stream)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dls []*DeadLetter
+	if info.State.Msgs == 0 {
+		return dls, nil
+	}
+
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		msg, err := stream.GetMsg(ctx, seq)
+		if err != nil {
+			// replayed jobs are deleted from the stream
+			if errors.Is(err, jetstream.ErrMsgNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		dls = append(dls, newDeadLetter(msg))
+	}
+
+	return dls, nil
+}
+
+// Replay runs a dead-lettered job through the workflow again and removes it from the dead-letter stream, a job
+// that fails again is dead-lettered again
+func (d *DeadLetters) Replay(ctx context.Context, sequence uint64) error {
+	stream, err := d.js.Stream(ctx, d.stream)
+	if err != nil {
+		return err
+	}
+
+	msg, err := stream.GetMsg(ctx, sequence)
+	if err != nil {
+		return err
+	}
+
+	d.wf.Run(&deadLetterMsg{dl: newDeadLetter(msg)})
+
+	return stream.DeleteMsg(ctx, sequence)
+}
+
+func newDeadLetter(msg *jetstream.RawStreamMsg) *DeadLetter {
+	return &DeadLetter{
+		Sequence:  msg.Sequence,
+		Subject:   msg.Header.Get(HeaderDeadLetterSubject),
+		Reason:    msg.Header.Get(HeaderDeadLetterReason),
+		Headers:   msg.Header,
+		Data:      msg.Data,
+		Timestamp: msg.Time,
+	}
+}
+
+/************************************************************************/
+// DEAD LETTER MESSAGE
+/************************************************************************/
+
+func (m *deadLetterMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: maxConsumerDeliver, Timestamp: m.dl.Timestamp}, nil
+}
+
+func (m *deadLetterMsg) Data() []byte {
+	return m.dl.Data
+}
+
+func (m *deadLetterMsg) Headers() nats.Header {
+	return m.dl.Headers
+}
+
+func (m *deadLetterMsg) Subject() string {
+	return m.dl.Subject
+}
+
+func (m *deadLetterMsg) Reply() string {
+	return ""
+}
+
+func (m *deadLetterMsg) Ack() error {
+	return nil
+}
+
+func (m *deadLetterMsg) DoubleAck(_ context.Context) error {
+	return nil
+}
+
+func (m *deadLetterMsg) Nak() error {
+	return nil
+}
+
+func (m *deadLetterMsg) NakWithDelay(_ time.Duration) error {
+	return nil
+}
+
+func (m *deadLetterMsg) InProgress() error {
+	return nil
+}
+
+func (m *deadLetterMsg) Term() error {
+	return nil
+}
+
+func (m *deadLetterMsg) TermWithReason(_ string) error {
+	return nil
+}
+
+/************************************************************************/
+// FACTORY
+/************************************************************************/
+
+// newDeadLetters creates the API to list and replay the jobs that were dead-lettered to the
+// Config.NatsDeadLetterStreamName stream by the workflow
+func newDeadLetters(js jetstream.JetStream, cfg *Config, wf JobWorkflow) (*DeadLetters, error) {
+	if cfg == nil || cfg.NatsDeadLetterStreamName == "" {
+		return nil, ErrDeadLetterNotConfigured
+	}
+	return &DeadLetters{js: js, stream: cfg.NatsDeadLetterStreamName, wf: wf}, nil
+}
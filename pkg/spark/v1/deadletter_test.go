@@ -0,0 +1,227 @@
+package sparkv1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1/util"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	port, err := util.GetFreeTCPPort()
+	require.NoError(t, err)
+
+	s, err := util.RunServerOnPort(port, t.TempDir())
+	require.NoError(t, err)
+	defer s.Shutdown()
+	s.Start()
+
+	nc, js := util.GetNatsClient(port)
+	defer nc.Close()
+
+	store, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
+		Bucket: "test",
+	})
+	require.NoError(t, err)
+
+	_, err = js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:     "DEAD_LETTERS",
+		Subjects: []string{"test.dead"},
+	})
+	require.NoError(t, err)
+
+	sub, err := nc.SubscribeSync("test.response")
+	require.NoError(t, err)
+
+	b := NewBuilder()
+	b.NewChain("chain-1").
+		Stage("stage-1", func(_ StageContext) (any, StageError) {
+			return "one", nil
+		}).
+		Complete(func(ctx CompleteContext) StageError {
+			if err := ctx.Output(NewVar("out", codec.MimeTypeJson, "done")); err != nil {
+				return NewStageError(err)
+			}
+			return nil
+		})
+
+	cfg := &Config{
+		NatsResponseSubject:      "test.response",
+		NatsDeadLetterSubject:    "test.dead",
+		NatsDeadLetterStreamName: "DEAD_LETTERS",
+	}
+	wf, err := NewJobWorkflow(context.Background(), "test", b.BuildChain(),
+		WithConfig(cfg),
+		WithNatsClient(nc),
+		WithObjectStore(store),
+	)
+	require.NoError(t, err)
+
+	dls, err := newDeadLetters(js, cfg, wf)
+	require.NoError(t, err)
+
+	nextResponse := func(t *testing.T) *ExecuteSparkOutput {
+		m, err := sub.NextMsg(time.Second * 5)
+		require.NoError(t, err)
+
+		var res ExecuteSparkOutput
+		require.NoError(t, json.Unmarshal(m.Data, &res))
+		return &res
+	}
+
+	t.Run("malformed job is dead-lettered", func(t *testing.T) {
+		msg := &testMsg{data: []byte("not json"), deliveries: 1}
+		wf.Run(msg)
+
+		assert.True(t, msg.acked)
+		res := nextResponse(t)
+		require.NotNil(t, res.Error)
+
+		list, err := dls.List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, []byte("not json"), list[0].Data)
+		assert.Equal(t, "test.request", list[0].Subject)
+		assert.NotEmpty(t, list[0].Reason)
+		assert.Equal(t, "test", list[0].Headers.Get("Origin"), "original headers must be kept")
+		assert.Equal(t, "1", list[0].Headers.Get(HeaderDeadLetterDeliveries))
+
+		stream, err := js.Stream(context.Background(), "DEAD_LETTERS")
+		require.NoError(t, err)
+		require.NoError(t, stream.Purge(context.Background()))
+	})
+
+	// the variables can not be loaded until they are fixed
+	_, err = store.PutBytes(context.Background(), "vars", []byte("not json"))
+	require.NoError(t, err)
+
+	data, err := json.Marshal(&JobMetadata{
+		JobKeyValue:        "job-1",
+		CorrelationIdValue: "cid",
+		TransactionIdValue: "tid",
+		VariablesKey:       "vars",
+		JobPid:             &JobPid{Id: "pid"},
+	})
+	require.NoError(t, err)
+
+	t.Run("failing job is redelivered before it is dead-lettered", func(t *testing.T) {
+		msg := &testMsg{data: data, deliveries: 1}
+		wf.Run(msg)
+
+		assert.True(t, msg.naked)
+		assert.Equal(t, consumerNakDelay, msg.nakDelay)
+		assert.False(t, msg.acked)
+
+		msg.deliveries++
+		wf.Run(msg)
+		assert.Equal(t, consumerNakDelay*2, msg.nakDelay, "the delay must grow with every delivery")
+
+		list, err := dls.List(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, list, 0)
+	})
+
+	t.Run("failing job is dead-lettered on its last delivery", func(t *testing.T) {
+		// a previous delivery checkpointed a stage that streamed its result
+		_, err := store.PutBytes(context.Background(), "stream-1", []byte("one"))
+		require.NoError(t, err)
+		_, err = store.PutBytes(context.Background(), checkpointKey("job-1", "stage-1"),
+			[]byte(`{"status":"STAGE_COMPLETED","result":{"ref":"stream-1"}}`))
+		require.NoError(t, err)
+
+		msg := &testMsg{data: data, deliveries: maxConsumerDeliver}
+		wf.Run(msg)
+
+		assert.True(t, msg.acked)
+		assert.False(t, msg.naked)
+
+		res := nextResponse(t)
+		require.NotNil(t, res.Error)
+		assert.Equal(t, "job-1", res.JobKey)
+		assert.Equal(t, "cid", res.CorrelationId)
+		assert.Equal(t, "tid", res.TransactionId)
+		require.NotNil(t, res.JobPid)
+		assert.Equal(t, "pid", res.JobPid.Id)
+
+		list, err := dls.List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, data, list[0].Data)
+
+		_, err = store.GetBytes(context.Background(), checkpointKey("job-1", "stage-1"))
+		assert.ErrorIs(t, err, jetstream.ErrObjectNotFound, "checkpoint must be removed once the job is dead-lettered")
+		_, err = store.GetBytes(context.Background(), "stream-1")
+		assert.ErrorIs(t, err, jetstream.ErrObjectNotFound, "streamed results must be removed once the job is dead-lettered")
+	})
+
+	t.Run("dead-lettered job is replayed through the workflow", func(t *testing.T) {
+		_, err := store.PutBytes(context.Background(), "vars", []byte("{}"))
+		require.NoError(t, err)
+
+		list, err := dls.List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+
+		require.NoError(t, dls.Replay(context.Background(), list[0].Sequence))
+
+		res := nextResponse(t)
+		assert.Nil(t, res.Error)
+		assert.Equal(t, "job-1", res.JobKey)
+		assert.NotEmpty(t, res.VariablesKey)
+
+		list, err = dls.List(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, list, 0, "replayed job must be removed")
+	})
+
+	t.Run("job that reached its maximum deliveries is dead-lettered", func(t *testing.T) {
+		requests, err := js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+			Name:     "REQUESTS",
+			Subjects: []string{"test.request"},
+		})
+		require.NoError(t, err)
+
+		ack, err := js.Publish(context.Background(), "test.request", data)
+		require.NoError(t, err)
+
+		advisory, err := json.Marshal(&maxDeliveriesAdvisory{StreamSeq: ack.Sequence, Deliveries: maxConsumerDeliver})
+		require.NoError(t, err)
+		require.NoError(t, handleMaxDeliveries(context.Background(), requests, wf.(*jobWorkflow), advisory))
+
+		res := nextResponse(t)
+		require.NotNil(t, res.Error)
+		assert.Equal(t, "job-1", res.JobKey)
+
+		list, err := dls.List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, data, list[0].Data)
+		assert.Equal(t, "test.request", list[0].Subject)
+		assert.Equal(t, ErrMaxDeliveriesExceeded.Error(), list[0].Reason)
+
+		_, err = requests.GetMsg(context.Background(), ack.Sequence)
+		assert.ErrorIs(t, err, jetstream.ErrMsgNotFound, "the job request must be removed from the stream")
+
+		// the advisory of a job that was removed in the meantime is ignored
+		assert.NoError(t, handleMaxDeliveries(context.Background(), requests, wf.(*jobWorkflow), advisory))
+	})
+}
+
+func TestNewDeadLettersRequiresStream(t *testing.T) {
+	_, err := newDeadLetters(nil, &Config{}, nil)
+	assert.ErrorIs(t, err, ErrDeadLetterNotConfigured)
+}
+
+func TestPluginDeadLetters(t *testing.T) {
+	_, err := newSparkPlugin(context.Background(), &Config{}, nil, nil).deadLetterAPI()
+	assert.ErrorIs(t, err, ErrDeadLetterNotConfigured)
+
+	_, err = newSparkPlugin(context.Background(), &Config{NatsDeadLetterStreamName: "DEAD_LETTERS"}, nil, nil).deadLetterAPI()
+	assert.ErrorIs(t, err, ErrWorkerNotRunning, "dead letters must not be available before the plugin started")
+}
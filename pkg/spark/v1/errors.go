@@ -42,6 +42,12 @@ var (
 	ErrConditionalStageSkipped  = errors.New("conditional Stage execution")
 	ErrChainIsNotValid          = errors.New("SparkChain is not valid")
 	ErrVariableNotFound         = errors.New("variable not found")
+	ErrInvalidVariable          = errors.New("invalid variable")
+	ErrJobCancelled             = errors.New("job canceled by request")
+	ErrJobTimeout               = errors.New("job canceled after exceeding its timeout")
+	ErrBranchNotFound           = errors.New("branch not found")
+	ErrStageTimeout             = errors.New("stage canceled after exceeding its timeout")
+	ErrMalformedJobRequest      = errors.New("malformed job request")
 )
 
 var (
@@ -60,8 +66,21 @@ func newErrStageNotFoundInNodeChain(stage string) error {
 	return fmt.Errorf("%w: %s", ErrStageNotFoundInNodeChain, stage)
 }
 
-func newErrConditionalStageSkipped(stageName string) error {
-	return fmt.Errorf("%w: Stage '%s' skipped", ErrConditionalStageSkipped, stageName)
+func newErrConditionalStageSkipped(stageName string) StageError {
+	return NewStageError(fmt.Errorf("%w: Stage '%s' skipped", ErrConditionalStageSkipped, stageName),
+		WithStageName(stageName))
+}
+
+func newErrBranchNotFound(stageName, key string) StageError {
+	return NewStageError(fmt.Errorf("%w: Stage '%s' selected '%s'", ErrBranchNotFound, stageName, key),
+		WithStageName(stageName))
+}
+
+// newErrStageTimeout creates the error of a stage that exceeded its timeout, the retry config of the error the
+// stage returned is kept so the timed out stage can be retried
+func newErrStageTimeout(stageName string, timeout time.Duration, retry *RetryConfig) StageError {
+	return NewStageErrorWithCode(ErrorCodeTimeout, fmt.Errorf("%w: Stage '%s' did not complete within %s",
+		ErrStageTimeout, stageName, timeout), WithStageName(stageName), withRetryConfig(retry))
 }
 
 func NewStageErrorWithCode(errorCode ErrorCode, err error, opts ...ErrorOption) StageError {
@@ -100,6 +119,10 @@ func (s *stageError) Error() string {
 	return s.err.Error()
 }
 
+func (s *stageError) Unwrap() error {
+	return s.err
+}
+
 func (s *stageError) Metadata() map[string]any {
 	return s.metadata
 }
@@ -136,6 +159,20 @@ func WithRetry(times uint, backoffMultiplier uint, firstBackoffWait time.Duratio
 	}
 }
 
+func withRetryConfig(retry *RetryConfig) ErrorOption {
+	return func(err *stageError) *stageError {
+		err.retry = retry
+		return err
+	}
+}
+
+func WithStageName(stageName string) ErrorOption {
+	return func(err *stageError) *stageError {
+		err.stageName = stageName
+		return err
+	}
+}
+
 func WithErrorCode(errorCode ErrorCode) ErrorOption {
 	return func(err *stageError) *stageError {
 		err.errorCode = errorCode
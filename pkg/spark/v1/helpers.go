@@ -2,6 +2,7 @@ package sparkv1
 
 import (
 	"errors"
+	"fmt"
 )
 
 var CompleteSuccess = func(ctx CompleteContext) StageError {
@@ -27,6 +28,14 @@ func addBreadcrumb(nodes ...*Node) {
 		n.Cancel.appendBreadcrumb(cancelNodeType, n.breadcrumb)
 		n.Compensate.appendBreadcrumb(compensateNodeType, n.breadcrumb)
 		nextNodes = appendIfNotNil(nextNodes, n.Compensate, n.Cancel)
+		for _, b := range n.Branches {
+			for _, key := range b.Keys() {
+				bn := b.Nodes[key]
+				bn.appendBreadcrumb(branchNodeType, n.breadcrumb)
+				bn.breadcrumb = fmt.Sprintf("%s[%s=%s]", bn.breadcrumb, b.Name, key)
+				nextNodes = appendIfNotNil(nextNodes, bn)
+			}
+		}
 	}
 	if len(nextNodes) > 0 {
 		addBreadcrumb(nextNodes...)
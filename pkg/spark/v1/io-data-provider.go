@@ -1,19 +1,25 @@
 package sparkv1
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
-	"github.com/nats-io/nats.go/jetstream"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"io"
+	"sync"
 )
 
 type ioDataProvider struct {
 	ctx          context.Context
+	mu           sync.RWMutex
 	stageResults map[string]*BindableValue
 	inputs       map[string]*BindableValue
-	store        jetstream.ObjectStore
+	store        VariableStore
+	// streams the keys of the stage results that were written to the store, they are deleted once the job is done
+	streams []string
 }
 
 func (iodp *ioDataProvider) SetInitialInputs(inputs ExecuteSparkInputs) {
@@ -27,10 +33,12 @@ func (iodp *ioDataProvider) GetInputValue(name string) (*BindableValue, bool) {
 	return v, ok
 }
 
+// LoadVariables loads the variables of the job, variables that are stored separately are referenced by their
+// key and only read when a stage accesses them
 func (iodp *ioDataProvider) LoadVariables(key string) error {
-	b, err := iodp.store.GetBytes(iodp.ctx, key)
+	b, err := iodp.store.Get(iodp.ctx, key)
 	if err != nil {
-		if errors.Is(err, jetstream.ErrObjectNotFound) {
+		if errors.Is(err, ErrVariableStoreKeyNotFound) {
 			if iodp.inputs == nil {
 				iodp.inputs = make(map[string]*BindableValue)
 			}
@@ -49,7 +57,11 @@ type bindableInput struct {
 	name      string
 }
 
-func NewIoDataProvider(ctx context.Context, store jetstream.ObjectStore) SparkDataIO {
+func NewIoDataProvider(ctx context.Context, store VariableStore) SparkDataIO {
+	return newIoDataProvider(ctx, store)
+}
+
+func newIoDataProvider(ctx context.Context, store VariableStore) *ioDataProvider {
 	return &ioDataProvider{
 		ctx:          ctx,
 		store:        store,
@@ -74,21 +86,50 @@ func (b *bindableInput) Bind(a any) error {
 func (b *bindableInput) GetValue() ([]byte, error) {
 	if len(b.data) == 0 {
 		// first fetch data
-		if bv, ok := b.iodp.GetInputValue(b.name); ok {
+		bv, err := b.value()
+		if err != nil {
+			return nil, err
+		}
+		if bv.Ref == "" {
 			b.data = bv.Value
 			return b.data, nil
-		} else {
-			return nil, fmt.Errorf("%w: error retrieving input data: stage (%s), name (%s)",
-				ErrVariableNotFound,
-				b.stageName,
-				b.name,
-			)
+		}
+		if b.data, err = b.iodp.store.Get(b.iodp.ctx, bv.Ref); err != nil {
+			return nil, fmt.Errorf("error retrieving input data: stage (%s), name (%s): %w", b.stageName, b.name, err)
 		}
 	}
 
 	return b.data, nil
 }
 
+// Reader streams inputs that are stored separately straight from the store
+func (b *bindableInput) Reader() (io.ReadCloser, error) {
+	if len(b.data) > 0 {
+		return io.NopCloser(bytes.NewReader(b.data)), nil
+	}
+
+	bv, err := b.value()
+	if err != nil {
+		return nil, err
+	}
+	if bv.Ref == "" {
+		return bv.Reader()
+	}
+	return b.iodp.store.GetReader(b.iodp.ctx, bv.Ref)
+}
+
+func (b *bindableInput) value() (*BindableValue, error) {
+	bv, ok := b.iodp.GetInputValue(b.name)
+	if !ok {
+		return nil, fmt.Errorf("%w: error retrieving input data: stage (%s), name (%s)",
+			ErrVariableNotFound,
+			b.stageName,
+			b.name,
+		)
+	}
+	return bv, nil
+}
+
 func (b *bindableInput) GetMimeType() string {
 	return b.mimeType
 }
@@ -117,13 +158,21 @@ func (iodp *ioDataProvider) NewInput(name, stageName string, value *BindableValu
 }
 
 func (iodp *ioDataProvider) NewOutput(stageName string, value *BindableValue) (Bindable, error) {
+	iodp.mu.Lock()
+	defer iodp.mu.Unlock()
+
 	iodp.stageResults[stageName] = value
 	return value, nil
 }
 
 func (iodp *ioDataProvider) GetStageResult(stageName string) (Bindable, error) {
+	iodp.mu.RLock()
+	defer iodp.mu.RUnlock()
+
 	if v, ok := iodp.stageResults[stageName]; !ok {
-		return nil, errors.New("stage result not found")
+		return nil, fmt.Errorf("%w: stage result not found: stage (%s)", ErrVariableNotFound, stageName)
+	} else if v.Ref != "" {
+		return iodp.newStoredValue(v), nil
 	} else {
 		return NewBindable(Value{
 			Value:    v.Value,
@@ -132,11 +181,116 @@ func (iodp *ioDataProvider) GetStageResult(stageName string) (Bindable, error) {
 	}
 }
 
-func (iodp *ioDataProvider) PutStageResult(stageName string, stageValue []byte) (Bindable, error) {
+func (iodp *ioDataProvider) PutStageResult(stageName string, stageValue []byte, mimeType string) (Bindable, error) {
+	iodp.mu.Lock()
+	defer iodp.mu.Unlock()
+
 	iodp.stageResults[stageName] = NewBindable(Value{
 		Value:    stageValue,
-		MimeType: string(codec.MimeTypeText),
+		MimeType: mimeType,
 	})
 
 	return iodp.stageResults[stageName], nil
 }
+
+func (iodp *ioDataProvider) PutStageResultStream(stageName string, r io.Reader, mimeType string) (Bindable, error) {
+	ref := uuid.NewString()
+	if err := iodp.store.PutReader(iodp.ctx, ref, r); err != nil {
+		return nil, err
+	}
+
+	return iodp.restoreStageResult(stageName, &BindableValue{Ref: ref, MimeType: mimeType}), nil
+}
+
+// restoreStageResult adds a stage result that was stored separately by a previous delivery of the job
+func (iodp *ioDataProvider) restoreStageResult(stageName string, value *BindableValue) Bindable {
+	iodp.mu.Lock()
+	defer iodp.mu.Unlock()
+
+	iodp.stageResults[stageName] = value
+	iodp.streams = append(iodp.streams, value.Ref)
+	return iodp.newStoredValue(value)
+}
+
+// deleteStreams removes the stage results that were written to the store
+func (iodp *ioDataProvider) deleteStreams(ctx context.Context) {
+	iodp.mu.Lock()
+	defer iodp.mu.Unlock()
+
+	for _, ref := range iodp.streams {
+		if err := iodp.store.Delete(ctx, ref); err != nil {
+			log.Error().Err(err).Msgf("unable to delete stage result %s", ref)
+		}
+	}
+	iodp.streams = nil
+}
+
+func (iodp *ioDataProvider) newStoredValue(value *BindableValue) *storedValue {
+	return &storedValue{ctx: iodp.ctx, store: iodp.store, ref: value.Ref, mimeType: value.MimeType}
+}
+
+/************************************************************************/
+// STORED VALUE
+/************************************************************************/
+
+// storedValue a value that is kept in the variable store, it is only read from the store when it is accessed
+type storedValue struct {
+	ctx      context.Context
+	store    VariableStore
+	ref      string
+	mimeType string
+	mu       sync.Mutex
+	data     []byte
+}
+
+func (sv *storedValue) Bind(a any) error {
+	data, err := sv.GetValue()
+	if err != nil {
+		return err
+	}
+	return NewBindable(Value{Value: data, MimeType: sv.mimeType}).Bind(a)
+}
+
+func (sv *storedValue) GetValue() ([]byte, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if sv.data == nil {
+		data, err := sv.store.Get(sv.ctx, sv.ref)
+		if err != nil {
+			return nil, err
+		}
+		sv.data = data
+	}
+	return sv.data, nil
+}
+
+func (sv *storedValue) GetMimeType() string {
+	return sv.mimeType
+}
+
+func (sv *storedValue) String() string {
+	var s string
+	_ = sv.Bind(&s)
+	return s
+}
+
+func (sv *storedValue) Reader() (io.ReadCloser, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if sv.data != nil {
+		return io.NopCloser(bytes.NewReader(sv.data)), nil
+	}
+	return sv.store.GetReader(sv.ctx, sv.ref)
+}
+
+// reference returns the value as it is stored in checkpoints and outputs, without its data
+func (sv *storedValue) reference() *BindableValue {
+	return &BindableValue{Ref: sv.ref, MimeType: sv.mimeType}
+}
+
+// MarshalJSON only writes the reference so the value is not read from the store
+func (sv *storedValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sv.reference())
+}
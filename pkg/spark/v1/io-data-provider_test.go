@@ -7,6 +7,8 @@ import (
 	"github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1/util"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -37,14 +39,15 @@ func TestIoDataProvider(t *testing.T) {
 		iop := &ioDataProvider{
 			ctx:          context.Background(),
 			stageResults: map[string]*BindableValue{},
-			store:        store,
+			store:        NewJetStreamVariableStore(store),
 		}
 		input, _ := codec.Encode("hello world")
 
 		t.Run("put stage result: success", func(t *testing.T) {
-			sr, err := iop.PutStageResult("dummy-stage", input)
+			sr, err := iop.PutStageResult("dummy-stage", input, string(codec.MimeTypeText))
 			assert.NoError(t, err)
 			assert.Equal(t, "hello world", sr.String())
+			assert.Equal(t, string(codec.MimeTypeText), sr.GetMimeType())
 		})
 
 		t.Run("get stage result: success", func(t *testing.T) {
@@ -54,19 +57,53 @@ func TestIoDataProvider(t *testing.T) {
 			var res any
 			assert.NoError(t, sr.Bind(&res))
 			assert.Equal(t, "hello world", res)
+			assert.Equal(t, string(codec.MimeTypeText), sr.GetMimeType())
 		})
 
 		t.Run("get stage result: fail", func(t *testing.T) {
 			_, err := iop.GetStageResult("dummy-error")
 			assert.ErrorContains(t, err, "stage result not found")
 		})
+
+		t.Run("put stage result stream: success", func(t *testing.T) {
+			sr, err := iop.PutStageResultStream("stream-stage", strings.NewReader("streamed"), string(codec.MimeTypeOctetStream))
+			assert.NoError(t, err)
+			assert.Len(t, iop.streams, 1)
+
+			b, err := json.Marshal(sr)
+			assert.NoError(t, err)
+			assert.NotContains(t, string(b), "streamed", "only the reference must be marshalled")
+
+			res, err := iop.GetStageResult("stream-stage")
+			assert.NoError(t, err)
+			r, err := res.Reader()
+			assert.NoError(t, err)
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, "streamed", string(data))
+
+			var out []byte
+			assert.NoError(t, res.Bind(&out))
+			assert.Equal(t, "streamed", string(out))
+		})
+
+		t.Run("delete streams: success", func(t *testing.T) {
+			iop.deleteStreams(context.Background())
+			assert.Empty(t, iop.streams)
+
+			res, err := iop.GetStageResult("stream-stage")
+			assert.NoError(t, err)
+			_, err = res.GetValue()
+			assert.ErrorIs(t, err, ErrVariableStoreKeyNotFound)
+		})
 	})
 
 	t.Run("Spark IO", func(t *testing.T) {
 		iop := &ioDataProvider{
 			ctx:          context.Background(),
 			stageResults: map[string]*BindableValue{},
-			store:        store,
+			store:        NewJetStreamVariableStore(store),
 		}
 
 		t.Run("get input: success", func(t *testing.T) {
@@ -95,6 +132,43 @@ func TestIoDataProvider(t *testing.T) {
 			assert.Equal(t, "foo bar", sr.String())
 		})
 
+		t.Run("get referenced input: success", func(t *testing.T) {
+			if _, err := store.PutBytes(context.Background(), "large-input", []byte(`"large value"`)); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := json.Marshal(map[string]any{
+				"large": &BindableValue{
+					MimeType: string(codec.MimeTypeJson),
+					Ref:      "large-input",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.PutBytes(context.Background(), "test-ref", b); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := iop.LoadVariables("test-ref"); err != nil {
+				t.Fatal(err)
+			}
+
+			bv, ok := iop.GetInputValue("large")
+			assert.True(t, ok)
+			assert.Nil(t, bv.Value, "referenced inputs must be loaded lazily")
+
+			sr := iop.NewInput("large", "c789", bv)
+			r, err := sr.Reader()
+			assert.NoError(t, err)
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, `"large value"`, string(data))
+			assert.Equal(t, "large value", sr.String())
+		})
+
 		t.Run("get input: fail", func(t *testing.T) {
 			sr := iop.NewInput("missing", "c567", &BindableValue{
 				MimeType: string(codec.MimeTypeJson),
@@ -12,18 +12,25 @@ import (
 // JobMetadata the context for the spark we want to execute on a module
 // TODO this type should come from the Module Library
 type JobMetadata struct {
-	SparkId                string             `json:"spark_id"` // id of the spark to execute
-	JobKeyValue            string             `json:"job_key"`
-	CorrelationIdValue     string             `json:"correlation_id"`
-	TransactionIdValue     string             `json:"transaction_id"`
-	RetryCount             uint               `json:"retry_count"`
-	RetryBackoff           time.Duration      `json:"retry_backoff"`
-	RetryBackoffMultiplier uint               `json:"retry_backoff_multiplier"`
-	JobPid                 *JobPid            `json:"job_pid,omitempty"`
-	VariablesBucket        string             `json:"variables_bucket"`
-	VariablesKey           string             `json:"variables_key"`
-	Model                  string             `json:"model,omitempty"`
-	Inputs                 ExecuteSparkInputs `json:"-"`
+	SparkId                string        `json:"spark_id"` // id of the spark to execute
+	JobKeyValue            string        `json:"job_key"`
+	CorrelationIdValue     string        `json:"correlation_id"`
+	TransactionIdValue     string        `json:"transaction_id"`
+	RetryCount             uint          `json:"retry_count"`
+	RetryBackoff           time.Duration `json:"retry_backoff"`
+	RetryBackoffMultiplier uint          `json:"retry_backoff_multiplier"`
+	JobPid                 *JobPid       `json:"job_pid,omitempty"`
+	VariablesBucket        string        `json:"variables_bucket"`
+	VariablesKey           string        `json:"variables_key"`
+	Model                  string        `json:"model,omitempty"`
+	// Deadline the job is cancelled when it has not completed by this time, Config.Timeout still applies
+	Deadline *time.Time         `json:"deadline,omitempty"`
+	Inputs   ExecuteSparkInputs `json:"-"`
+}
+
+// JobCancelRequest cancels a running job when published to Config.NatsCancelSubject
+type JobCancelRequest struct {
+	JobKey string `json:"job_key"`
 }
 
 type JobPid struct {
@@ -0,0 +1,442 @@
+package sparkv1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// ManifestFormat the format a ChainManifest is encoded in
+type ManifestFormat string
+
+const (
+	ManifestFormatJson     ManifestFormat = "json"
+	ManifestFormatGraphviz ManifestFormat = "dot"
+	ManifestFormatMermaid  ManifestFormat = "mermaid"
+)
+
+// StageType the kind of step a StageManifest describes
+type StageType string
+
+const (
+	StageTypeStage    StageType = "stage"
+	StageTypeParallel StageType = "parallel"
+	StageTypeBranch   StageType = "branch"
+	StageTypeComplete StageType = "complete"
+)
+
+var (
+	ErrUnknownManifestFormat = errors.New("unknown manifest format")
+)
+
+// ChainManifest a machine-readable description of a SparkChain, it is created without running the spark so it
+// can be rendered and compared by tooling
+type ChainManifest struct {
+	Root   *NodeManifest `json:"root"`
+	Schema *SparkSchema  `json:"schema,omitempty"`
+}
+
+// NodeManifest describes a Node of the SparkChain and the compensation and cancellation chains attached to it
+type NodeManifest struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Breadcrumb string           `json:"breadcrumb"`
+	Stages     []*StageManifest `json:"stages"`
+	Complete   *StageManifest   `json:"complete,omitempty"`
+	Compensate *NodeManifest    `json:"compensate,omitempty"`
+	Cancel     *NodeManifest    `json:"cancel,omitempty"`
+}
+
+// StageManifest describes a step of a Node, Stages holds the Stages of a parallel group and Branches the Nodes
+// of a branch step by their key
+type StageManifest struct {
+	Name     string                   `json:"name"`
+	Type     StageType                `json:"type"`
+	Options  *StageOptionsManifest    `json:"options,omitempty"`
+	Mode     ParallelMode             `json:"mode,omitempty"`
+	Stages   []*StageManifest         `json:"stages,omitempty"`
+	Branches map[string]*NodeManifest `json:"branches,omitempty"`
+}
+
+// StageOptionsManifest describes the options of a Stage, the names of the stages, inputs and stage results the
+// conditional options depend on are recorded when the options are evaluated against an empty job
+type StageOptionsManifest struct {
+	Timeout       string       `json:"timeout,omitempty"`
+	Retry         *RetryConfig `json:"retry,omitempty"`
+	StageStatuses []string     `json:"stage_statuses,omitempty"`
+	Inputs        []string     `json:"inputs,omitempty"`
+	StageResults  []string     `json:"stage_results,omitempty"`
+	// Error the panics of the options that could not be evaluated against an empty job, what they accessed before
+	// they panicked is still recorded
+	Error string `json:"error,omitempty"`
+}
+
+// describeParams records what the options of a stage access instead of evaluating them against a job
+type describeParams struct {
+	stageName string
+	manifest  *StageOptionsManifest
+}
+
+/************************************************************************/
+// DESCRIBE
+/************************************************************************/
+
+// Describe creates the manifest of the SparkChain
+func (sc *SparkChain) Describe() *ChainManifest {
+	return &ChainManifest{Root: describeNode(sc.RootNode), Schema: sc.schema}
+}
+
+func describeNode(n *Node) *NodeManifest {
+	if n == nil {
+		return nil
+	}
+
+	nm := &NodeManifest{
+		Name:       n.Name,
+		Type:       strings.ToLower(string(n.nodeType)),
+		Breadcrumb: n.breadcrumb,
+		Stages:     make([]*StageManifest, 0, len(n.Stages)),
+		Compensate: describeNode(n.Compensate),
+		Cancel:     describeNode(n.Cancel),
+	}
+	for _, s := range n.Stages {
+		nm.Stages = append(nm.Stages, describeStage(s))
+	}
+	if n.Complete != nil {
+		nm.Complete = &StageManifest{
+			Name:    n.Complete.Name,
+			Type:    StageTypeComplete,
+			Options: describeOptions(n.Complete.Name, n.Complete.so),
+		}
+	}
+	return nm
+}
+
+func describeStage(s *Stage) *StageManifest {
+	sm := &StageManifest{Name: s.Name, Type: StageTypeStage, Options: describeOptions(s.Name, s.so)}
+
+	switch {
+	case s.IsParallel():
+		sm.Type, sm.Mode = StageTypeParallel, s.mode
+		for _, ps := range s.parallel {
+			sm.Stages = append(sm.Stages, describeStage(ps))
+		}
+	case s.IsBranch():
+		sm.Type, sm.Branches = StageTypeBranch, make(map[string]*NodeManifest, len(s.branch.Nodes))
+		for _, key := range s.branch.Keys() {
+			sm.Branches[key] = describeNode(s.branch.Nodes[key])
+		}
+	}
+	return sm
+}
+
+// describeOptions evaluates the options against describeParams, options that panic because they expect a job
+// are only described by what they accessed until then and their panic is recorded as the error of the manifest
+func describeOptions(stageName string, options []StageOption) *StageOptionsManifest {
+	if len(options) == 0 {
+		return nil
+	}
+
+	params := &describeParams{stageName: stageName, manifest: &StageOptionsManifest{}}
+	var panics []string
+	for _, opt := range options {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panics = append(panics, fmt.Sprintf("option panicked: %v", r))
+				}
+			}()
+			_ = opt(params)
+		}()
+	}
+	params.manifest.Error = strings.Join(panics, "; ")
+	return params.manifest
+}
+
+func (d *describeParams) StageName() string {
+	return d.stageName
+}
+
+func (d *describeParams) Context() Context {
+	return &JobContext{Context: context.Background(), Metadata: &JobMetadata{}}
+}
+
+func (d *describeParams) StageStatus(name string) StageStatus {
+	d.manifest.StageStatuses = appendUnique(d.manifest.StageStatuses, name)
+	return StageStatus_STAGE_PENDING
+}
+
+func (d *describeParams) Input(name string) Input {
+	d.manifest.Inputs = appendUnique(d.manifest.Inputs, name)
+	return &BindableValue{}
+}
+
+func (d *describeParams) StageResult(name string) Bindable {
+	d.manifest.StageResults = appendUnique(d.manifest.StageResults, name)
+	return NewBindableError(ErrVariableNotFound)
+}
+
+func (d *describeParams) setTimeout(timeout time.Duration) {
+	d.manifest.Timeout = timeout.String()
+}
+
+func (d *describeParams) setRetry(retry *RetryConfig) {
+	d.manifest.Retry = retry
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+/************************************************************************/
+// ENCODING
+/************************************************************************/
+
+// Encode writes the manifest in the format, graphs show every Node as a cluster of its stages with dashed
+// edges to its compensation and cancellation chains
+func (m *ChainManifest) Encode(w io.Writer, format ManifestFormat) error {
+	switch format {
+	case ManifestFormatJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	case ManifestFormatGraphviz:
+		_, err := io.WriteString(w, newManifestGraph(m).graphviz())
+		return err
+	case ManifestFormatMermaid:
+		_, err := io.WriteString(w, newManifestGraph(m).mermaid())
+		return err
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownManifestFormat, format)
+	}
+}
+
+// manifestGraph the vertices, clusters and edges the graph formats are rendered from
+type manifestGraph struct {
+	clusters []*graphCluster
+	edges    []graphEdge
+	next     int
+}
+
+type graphCluster struct {
+	id       string
+	label    string
+	entry    string
+	vertices []graphVertex
+}
+
+type graphVertex struct {
+	id    string
+	label string
+	shape StageType
+}
+
+// graphEdge connects two vertices, edges between clusters connect the entries of the clusters
+type graphEdge struct {
+	from, to     string
+	label        string
+	dashed       bool
+	fromCluster  string
+	toCluster    string
+	betweenNodes bool
+}
+
+func newManifestGraph(m *ChainManifest) *manifestGraph {
+	g := &manifestGraph{}
+	g.addNode(m.Root)
+	return g
+}
+
+func (g *manifestGraph) id(prefix string) string {
+	g.next++
+	return fmt.Sprintf("%s%d", prefix, g.next)
+}
+
+// addNode adds the cluster of the Node and returns it and the vertex the Node exits through
+func (g *manifestGraph) addNode(n *NodeManifest) (*graphCluster, string) {
+	c := &graphCluster{id: g.id("cluster_"), label: n.Name}
+	g.clusters = append(g.clusters, c)
+
+	var prev string
+	link := func(to, label string) {
+		if prev != "" {
+			g.edges = append(g.edges, graphEdge{from: prev, to: to, label: label})
+		} else {
+			c.entry = to
+		}
+	}
+	vertex := func(label string, shape StageType) string {
+		v := graphVertex{id: g.id("v"), label: label, shape: shape}
+		c.vertices = append(c.vertices, v)
+		return v.id
+	}
+
+	for _, s := range n.Stages {
+		switch s.Type {
+		case StageTypeParallel:
+			fork := vertex(s.Name, StageTypeParallel)
+			link(fork, "")
+			join := vertex(s.Name+" done", StageTypeParallel)
+			for _, ps := range s.Stages {
+				v := vertex(ps.Name, StageTypeStage)
+				g.edges = append(g.edges, graphEdge{from: fork, to: v}, graphEdge{from: v, to: join})
+			}
+			prev = join
+		case StageTypeBranch:
+			branch := vertex(s.Name, StageTypeBranch)
+			link(branch, "")
+			join := vertex(s.Name+" done", StageTypeParallel)
+			for _, key := range sortedKeys(s.Branches) {
+				bc, exit := g.addNode(s.Branches[key])
+				g.edges = append(g.edges, graphEdge{from: branch, to: bc.entry, label: key})
+				if exit != "" {
+					g.edges = append(g.edges, graphEdge{from: exit, to: join})
+				}
+			}
+			prev = join
+		default:
+			v := vertex(s.Name, StageTypeStage)
+			link(v, "")
+			prev = v
+		}
+	}
+
+	if n.Complete != nil {
+		v := vertex(n.Complete.Name, StageTypeComplete)
+		link(v, "")
+		prev = v
+	}
+
+	for _, sub := range []struct {
+		label string
+		node  *NodeManifest
+	}{{"compensate", n.Compensate}, {"cancel", n.Cancel}} {
+		if sub.node == nil {
+			continue
+		}
+		sc, _ := g.addNode(sub.node)
+		if c.entry != "" && sc.entry != "" {
+			g.edges = append(g.edges, graphEdge{
+				from: c.entry, to: sc.entry, label: sub.label, dashed: true,
+				fromCluster: c.id, toCluster: sc.id, betweenNodes: true,
+			})
+		}
+	}
+
+	return c, prev
+}
+
+func (g *manifestGraph) graphviz() string {
+	var sb strings.Builder
+	sb.WriteString("digraph \"spark\" {\n\tcompound=true;\n\trankdir=LR;\n")
+	for _, c := range g.clusters {
+		fmt.Fprintf(&sb, "\tsubgraph \"%s\" {\n\t\tlabel=\"%s\";\n", c.id, dotEscape(c.label))
+		for _, v := range c.vertices {
+			fmt.Fprintf(&sb, "\t\t\"%s\" [label=\"%s\", shape=%s];\n", v.id, dotEscape(v.label), dotShape(v.shape))
+		}
+		sb.WriteString("\t}\n")
+	}
+	for _, e := range g.edges {
+		var attrs []string
+		if e.label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=\"%s\"", dotEscape(e.label)))
+		}
+		if e.dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		if e.betweenNodes {
+			attrs = append(attrs, fmt.Sprintf("ltail=\"%s\"", e.fromCluster), fmt.Sprintf("lhead=\"%s\"", e.toCluster))
+		}
+		fmt.Fprintf(&sb, "\t\"%s\" -> \"%s\"", e.from, e.to)
+		if len(attrs) > 0 {
+			fmt.Fprintf(&sb, " [%s]", strings.Join(attrs, ", "))
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (g *manifestGraph) mermaid() string {
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	for _, c := range g.clusters {
+		fmt.Fprintf(&sb, "\tsubgraph %s [\"%s\"]\n", c.id, mermaidEscape(c.label))
+		for _, v := range c.vertices {
+			open, closing := mermaidShape(v.shape)
+			fmt.Fprintf(&sb, "\t\t%s%s\"%s\"%s\n", v.id, open, mermaidEscape(v.label), closing)
+		}
+		sb.WriteString("\tend\n")
+	}
+	for _, e := range g.edges {
+		from, to, arrow := e.from, e.to, "-->"
+		if e.betweenNodes {
+			from, to = e.fromCluster, e.toCluster
+		}
+		if e.dashed {
+			arrow = "-.->"
+		}
+		if e.label != "" {
+			arrow += "|\"" + mermaidEscape(e.label) + "\"|"
+		}
+		fmt.Fprintf(&sb, "\t%s %s %s\n", from, arrow, to)
+	}
+	return sb.String()
+}
+
+func dotShape(t StageType) string {
+	switch t {
+	case StageTypeParallel:
+		return "point"
+	case StageTypeBranch:
+		return "diamond"
+	case StageTypeComplete:
+		return "doublecircle"
+	default:
+		return "box"
+	}
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func mermaidShape(t StageType) (string, string) {
+	switch t {
+	case StageTypeParallel:
+		return "[/", "/]"
+	case StageTypeBranch:
+		return "{", "}"
+	case StageTypeComplete:
+		return "((", "))"
+	default:
+		return "[", "]"
+	}
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s)
+}
+
+func sortedKeys(m map[string]*NodeManifest) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
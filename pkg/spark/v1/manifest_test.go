@@ -0,0 +1,163 @@
+package sparkv1
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newManifestChain() *SparkChain {
+	noop := func(_ StageContext) (any, StageError) {
+		return nil, nil
+	}
+	selector := func(_ StageContext) (string, StageError) {
+		return "a", nil
+	}
+
+	b := NewBuilder()
+	b.NewChain("main").
+		Stage("fetch", noop,
+			WithStageTimeout(time.Second),
+			WithRetryPolicy(RetryConfig{Times: 3, FirstBackoffWait: time.Millisecond, BackoffMultiplier: 2}),
+		).
+		Parallel("enrich", ParallelModeCollectAll,
+			NewStage("enrich-a", noop),
+			NewStage("enrich-b", noop, WithSkipOnMissingStageResult("fetch")),
+		).
+		Branch("route", selector, map[string]Chain{
+			"a": b.NewChain("route-a").Stage("route-a-stage", noop).Complete(CompleteSuccess),
+			"b": b.NewChain("route-b").Stage("route-b-stage", noop,
+				WithSkipOnInput("\"quoted\"", func(in Input) bool { return true })).Complete(CompleteSuccess),
+		}).
+		Compensate(b.NewChain("undo").Stage("undo-stage", noop,
+			WithStageStatus("fetch", StageStatus_STAGE_COMPLETED)).Complete(CompleteSuccess)).
+		Cancelled(b.NewChain("cancel").Stage("cancel-stage", noop).Complete(CompleteSuccess)).
+		Complete(CompleteSuccess)
+	b.Outputs(NewVariableSchema[string]("message"))
+	return b.BuildChain()
+}
+
+func TestChainManifest(t *testing.T) {
+	t.Run("describe chain", func(t *testing.T) {
+		m := newManifestChain().Describe()
+		root := m.Root
+
+		require.NotNil(t, root)
+		assert.Equal(t, "main", root.Name)
+		assert.Equal(t, "root", root.Type)
+		assert.Equal(t, "root", root.Breadcrumb)
+		require.Len(t, root.Stages, 3)
+		require.NotNil(t, root.Complete)
+		assert.Equal(t, StageTypeComplete, root.Complete.Type)
+		require.Len(t, m.Schema.Outputs, 1)
+
+		fetch := root.Stages[0]
+		assert.Equal(t, StageTypeStage, fetch.Type)
+		require.NotNil(t, fetch.Options)
+		assert.Equal(t, "1s", fetch.Options.Timeout)
+		assert.Equal(t, &RetryConfig{Times: 3, FirstBackoffWait: time.Millisecond, BackoffMultiplier: 2}, fetch.Options.Retry)
+
+		enrich := root.Stages[1]
+		assert.Equal(t, StageTypeParallel, enrich.Type)
+		assert.Equal(t, ParallelModeCollectAll, enrich.Mode)
+		require.Len(t, enrich.Stages, 2)
+		assert.Nil(t, enrich.Stages[0].Options)
+		assert.Equal(t, []string{"fetch"}, enrich.Stages[1].Options.StageResults)
+
+		route := root.Stages[2]
+		assert.Equal(t, StageTypeBranch, route.Type)
+		require.Len(t, route.Branches, 2)
+		assert.Equal(t, "root > branch[route=a]", route.Branches["a"].Breadcrumb)
+		assert.Equal(t, []string{"\"quoted\""}, route.Branches["b"].Stages[0].Options.Inputs)
+
+		require.NotNil(t, root.Compensate)
+		assert.Equal(t, "undo", root.Compensate.Name)
+		assert.Equal(t, "compensate", root.Compensate.Type)
+		assert.Equal(t, []string{"fetch"}, root.Compensate.Stages[0].Options.StageStatuses)
+		require.NotNil(t, root.Cancel)
+		assert.Equal(t, "cancel", root.Cancel.Name)
+	})
+
+	t.Run("encode json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, newManifestChain().Describe().Encode(&buf, ManifestFormatJson))
+
+		var m ChainManifest
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+		assert.Equal(t, "main", m.Root.Name)
+		assert.Equal(t, uint(3), m.Root.Stages[0].Options.Retry.Times)
+		assert.Equal(t, "route-b-stage", m.Root.Stages[2].Branches["b"].Stages[0].Name)
+		assert.Equal(t, "message", m.Schema.Outputs[0].Name)
+	})
+
+	t.Run("encode graphviz", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, newManifestChain().Describe().Encode(&buf, ManifestFormatGraphviz))
+		dot := buf.String()
+
+		assert.Contains(t, dot, "digraph \"spark\" {")
+		assert.Contains(t, dot, "compound=true;")
+		assert.Contains(t, dot, "label=\"main\";")
+		assert.Contains(t, dot, "[label=\"fetch\", shape=box];")
+		assert.Contains(t, dot, "[label=\"route\", shape=diamond];")
+		assert.Contains(t, dot, "[label=\"main_complete\", shape=doublecircle];")
+		assert.Contains(t, dot, "[label=\"a\"];")
+		assert.Contains(t, dot, "[label=\"compensate\", style=dashed, ltail=")
+		assert.Contains(t, dot, "[label=\"cancel\", style=dashed, ltail=")
+	})
+
+	t.Run("encode mermaid", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, newManifestChain().Describe().Encode(&buf, ManifestFormatMermaid))
+		mermaid := buf.String()
+
+		assert.Contains(t, mermaid, "flowchart LR\n")
+		assert.Contains(t, mermaid, "[\"main\"]")
+		assert.Contains(t, mermaid, "{\"route\"}")
+		assert.Contains(t, mermaid, "((\"main_complete\"))")
+		assert.Contains(t, mermaid, "-.->|\"compensate\"|")
+		assert.Contains(t, mermaid, "-->|\"b\"|")
+		assert.Equal(t, 5, bytes.Count(buf.Bytes(), []byte("\tend\n")), "every node must be a subgraph")
+	})
+
+	t.Run("record panics of options", func(t *testing.T) {
+		options := describeOptions("stage", []StageOption{
+			func(p StageOptionParams) StageError {
+				p.Input("customer")
+				panic("boom")
+			},
+		})
+
+		require.NotNil(t, options)
+		assert.Equal(t, []string{"customer"}, options.Inputs)
+		assert.Equal(t, "option panicked: boom", options.Error)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		err := newManifestChain().Describe().Encode(&bytes.Buffer{}, "svg")
+		assert.ErrorIs(t, err, ErrUnknownManifestFormat)
+	})
+
+	t.Run("describe flag", func(t *testing.T) {
+		for _, tc := range []struct {
+			args   []string
+			format ManifestFormat
+			ok     bool
+		}{
+			{args: nil},
+			{args: []string{"describe"}},
+			{args: []string{"--describe"}, format: ManifestFormatJson, ok: true},
+			{args: []string{"-describe"}, format: ManifestFormatJson, ok: true},
+			{args: []string{"-v", "--describe=mermaid"}, format: ManifestFormatMermaid, ok: true},
+			{args: []string{"--describe=dot"}, format: ManifestFormatGraphviz, ok: true},
+		} {
+			format, ok := describeFormat(tc.args)
+			assert.Equal(t, tc.ok, ok, tc.args)
+			assert.Equal(t, tc.format, format, tc.args)
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 /************************************************************************/
@@ -14,6 +15,21 @@ import (
 type stageOptionParams struct {
 	stageName string
 	ctx       Context
+	state     *JobState
+	io        SparkDataIO
+	settings  *stageSettings
+}
+
+// stageSettings holds the execution settings of a stage that are set through its options
+type stageSettings struct {
+	timeout time.Duration
+	retry   *RetryConfig
+}
+
+// stageSettingsParams is implemented by the params of stages that accept execution settings
+type stageSettingsParams interface {
+	setTimeout(timeout time.Duration)
+	setRetry(retry *RetryConfig)
 }
 
 func (s stageOptionParams) StageName() string {
@@ -24,10 +40,113 @@ func (s stageOptionParams) Context() Context {
 	return s.ctx
 }
 
-func newStageOptionParams(ctx Context, stageName string) StageOptionParams {
+func (s stageOptionParams) StageStatus(name string) StageStatus {
+	if status, ok := s.state.StageStatuses[name]; ok {
+		return status
+	}
+	return StageStatus_STAGE_PENDING
+}
+
+func (s stageOptionParams) Input(name string) Input {
+	if in, ok := s.io.GetInputValue(name); ok {
+		return in
+	}
+	return &BindableValue{}
+}
+
+func (s stageOptionParams) StageResult(name string) Bindable {
+	result, err := s.io.GetStageResult(name)
+	if err != nil {
+		return NewBindableError(err)
+	}
+	return result
+}
+
+func (s stageOptionParams) setTimeout(timeout time.Duration) {
+	s.settings.timeout = timeout
+}
+
+func (s stageOptionParams) setRetry(retry *RetryConfig) {
+	s.settings.retry = retry
+}
+
+func newStageOptionParams(ctx Context, stageName string, state *JobState, io SparkDataIO, settings *stageSettings) StageOptionParams {
 	return stageOptionParams{
 		stageName: stageName,
 		ctx:       ctx,
+		state:     state,
+		io:        io,
+		settings:  settings,
+	}
+}
+
+// WithStageStatus only executes the stage when the stage with the given name has the given status,
+// the stage is skipped otherwise
+func WithStageStatus(stageName string, status StageStatus) StageOption {
+	return func(params StageOptionParams) StageError {
+		if params.StageStatus(stageName) != status {
+			return newErrConditionalStageSkipped(params.StageName())
+		}
+		return nil
+	}
+}
+
+// WithSkipOnStageStatus skips the stage when the stage with the given name has any of the given statuses
+func WithSkipOnStageStatus(stageName string, statuses ...StageStatus) StageOption {
+	return func(params StageOptionParams) StageError {
+		current := params.StageStatus(stageName)
+		for _, status := range statuses {
+			if current == status {
+				return newErrConditionalStageSkipped(params.StageName())
+			}
+		}
+		return nil
+	}
+}
+
+// WithSkipOnInput skips the stage when the predicate returns true for the input with the given name,
+// a missing input is passed to the predicate as an empty input
+func WithSkipOnInput(name string, predicate func(input Input) bool) StageOption {
+	return func(params StageOptionParams) StageError {
+		if predicate(params.Input(name)) {
+			return newErrConditionalStageSkipped(params.StageName())
+		}
+		return nil
+	}
+}
+
+// WithSkipOnMissingStageResult skips the stage when the stage with the given name has no result,
+// this includes stages that did not run and stages that were skipped
+func WithSkipOnMissingStageResult(stageName string) StageOption {
+	return func(params StageOptionParams) StageError {
+		v, err := params.StageResult(stageName).GetValue()
+		if err != nil || len(v) == 0 {
+			return newErrConditionalStageSkipped(params.StageName())
+		}
+		return nil
+	}
+}
+
+// WithStageTimeout bounds every attempt of the stage to the given duration, the StageContext is done once the
+// timeout is exceeded and the stage fails with ErrorCodeTimeout, the retry config of the error returned by the
+// stage is kept so a timed out stage can still be retried
+func WithStageTimeout(timeout time.Duration) StageOption {
+	return func(params StageOptionParams) StageError {
+		if p, ok := params.(stageSettingsParams); ok {
+			p.setTimeout(timeout)
+		}
+		return nil
+	}
+}
+
+// WithRetryPolicy retries the stage when it fails, use RetryConfig.RetryOn to only retry specific error codes,
+// a retry config set on the error returned by the stage takes precedence over the policy
+func WithRetryPolicy(retry RetryConfig) StageOption {
+	return func(params StageOptionParams) StageError {
+		if p, ok := params.(stageSettingsParams); ok {
+			p.setRetry(&retry)
+		}
+		return nil
 	}
 }
 
@@ -40,6 +159,7 @@ type SparkOpts struct {
 	config         []byte
 	configType     ConfigType
 	configBasePath string
+	keyProvider    KeyProvider
 }
 
 type Option = func(je *SparkOpts) *SparkOpts
@@ -55,6 +175,15 @@ func WithSparkConfig(cfg any) Option {
 	}
 }
 
+// WithKeyProvider encrypts the values in the variable store with the keys of the provider, it takes precedence
+// over the keys in the store config
+func WithKeyProvider(kp KeyProvider) Option {
+	return func(je *SparkOpts) *SparkOpts {
+		je.keyProvider = kp
+		return je
+	}
+}
+
 /************************************************************************/
 // WORKFLOW OPTIONS
 /************************************************************************/
@@ -63,10 +192,15 @@ type workflowOpts struct {
 	config             *Config
 	nc                 *nats.Conn
 	os                 jetstream.ObjectStore
+	store              VariableStore
 	inputs             ExecuteSparkInputs
 	stageRetryOverride *RetryConfig
+	publisher          ResultPublisher
 }
 
+// ResultPublisher publishes the serialized ExecuteSparkOutput of a job, a failed publish is retried
+type ResultPublisher = func(result []byte) error
+
 type WorkflowOption = func(je *workflowOpts) *workflowOpts
 
 func WithStageTracker(ist InternalStageTracker) WorkflowOption {
@@ -90,6 +224,16 @@ func WithNatsClient(nc *nats.Conn) WorkflowOption {
 	}
 }
 
+// WithVariableStore sets the store for variables, outputs and checkpoints, it takes precedence over WithObjectStore
+func WithVariableStore(store VariableStore) WorkflowOption {
+	return func(jw *workflowOpts) *workflowOpts {
+		jw.store = store
+		return jw
+	}
+}
+
+// WithObjectStore stores variables, outputs and checkpoints in the object store, values are compressed with the
+// Config.Store encoding and encrypted with the Config.Store encryption keys
 func WithObjectStore(os jetstream.ObjectStore) WorkflowOption {
 	return func(jw *workflowOpts) *workflowOpts {
 		jw.os = os
@@ -110,3 +254,12 @@ func WithStageRetryOverride(override *RetryConfig) WorkflowOption {
 		return je
 	}
 }
+
+// WithResultPublisher publishes the results of the jobs with publisher instead of the NATS client, jobs can then
+// run without a NATS server
+func WithResultPublisher(publisher ResultPublisher) WorkflowOption {
+	return func(je *workflowOpts) *workflowOpts {
+		je.publisher = publisher
+		return je
+	}
+}
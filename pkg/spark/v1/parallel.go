@@ -0,0 +1,93 @@
+package sparkv1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// preparedStage a Stage of a parallel group that must run along with its settings
+type preparedStage struct {
+	*Stage
+	settings *stageSettings
+}
+
+// parallelResult the outcome of a single Stage in a parallel group
+type parallelResult struct {
+	stage *Stage
+	res   Bindable
+	err   error
+	// cancelled is true when the Stage failed after another Stage in a fail-fast group already failed
+	cancelled bool
+}
+
+/************************************************************************/
+// EXECUTION
+/************************************************************************/
+
+// executeParallelActivity runs the Stages concurrently and waits for all of them to return, in fail-fast mode
+// the context of the remaining Stages is cancelled as soon as one of them fails
+func (w *jobWorkflow) executeParallelActivity(ctx context.Context, stages []preparedStage, mode ParallelMode, state *JobState, io SparkDataIO) []parallelResult {
+	gctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		results = make([]parallelResult, len(stages))
+	)
+
+	for i, stage := range stages {
+		wg.Add(1)
+		go func(i int, stage preparedStage) {
+			defer wg.Done()
+
+			res, err := w.executeStageActivity(gctx, stage.Name, stage.settings, state, io)
+			results[i] = parallelResult{stage: stage.Stage, res: res, err: err}
+			if err == nil || mode == ParallelModeCollectAll {
+				return
+			}
+
+			// only the first failure is reported in fail-fast mode, the others were caused by the cancellation
+			results[i].cancelled = true
+			once.Do(func() {
+				results[i].cancelled = false
+				cancel()
+			})
+		}(i, stage)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+/************************************************************************/
+// HELPERS
+/************************************************************************/
+
+// getParallelErrorOutput combines the errors of the Stages in a parallel group, a single error is returned as is
+func getParallelErrorOutput(groupName string, errs []*ExecuteSparkError) *ExecuteStageResponse {
+	if len(errs) == 1 {
+		return &ExecuteStageResponse{Error: errs[0]}
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = fmt.Sprintf("%s: %s", err.StageName, err.ErrorMessage)
+	}
+
+	return &ExecuteStageResponse{
+		Error: &ExecuteSparkError{
+			StageName:    groupName,
+			ErrorCode:    ErrorCodeParallelStagesFailed,
+			ErrorMessage: fmt.Sprintf("%d parallel stages failed: %s", len(errs), strings.Join(messages, "; ")),
+			Metadata:     map[string]any{"errors": errs},
+		},
+	}
+}
@@ -3,12 +3,14 @@ package sparkv1
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-plugin"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog/log"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,16 +26,33 @@ var (
 type sparkPlugin struct {
 	config *Config
 	chain  *SparkChain
-	ctx    context.Context
-	nc     *nats.Conn
+	// keys encrypt the variable store, the keys of the config are used if it is nil
+	keys KeyProvider
+	ctx  context.Context
+	nc   *nats.Conn
+	pool *jobPool
+	// consumerCtx stops the consumers without cancelling the jobs that are running
+	consumerCtx  context.Context
+	stopConsumer context.CancelFunc
+	// deadLetters replays dead-lettered jobs through the workflow of the plugin once it started
+	deadLetters atomic.Pointer[DeadLetters]
 }
 
 /************************************************************************/
 // SERVER
 /************************************************************************/
 
-func newSparkPlugin(ctx context.Context, cfg *Config, chain *SparkChain) *sparkPlugin {
-	return &sparkPlugin{ctx: ctx, config: cfg, chain: chain}
+func newSparkPlugin(ctx context.Context, cfg *Config, chain *SparkChain, keys KeyProvider) *sparkPlugin {
+	consumerCtx, stopConsumer := context.WithCancel(ctx)
+	return &sparkPlugin{
+		ctx:          ctx,
+		config:       cfg,
+		chain:        chain,
+		keys:         keys,
+		pool:         newJobPool(cfg.maxConcurrentJobs(), consumerInProgressInterval),
+		consumerCtx:  consumerCtx,
+		stopConsumer: stopConsumer,
+	}
 }
 
 func (s *sparkPlugin) start() error {
@@ -47,13 +66,13 @@ func (s *sparkPlugin) start() error {
 	if err != nil {
 		return err
 	}
-	store, err := js.ObjectStore(s.ctx, s.config.NatsBucket)
+	store, err := newVariableStore(s.ctx, s.config, js, s.keys)
 	if err != nil {
 		return err
 	}
 
 	wf, err := NewJobWorkflow(s.ctx, uuid.NewString(), s.chain,
-		WithConfig(s.config), WithNatsClient(nc), WithObjectStore(store))
+		WithConfig(s.config), WithNatsClient(nc), WithVariableStore(store))
 	if err != nil {
 		return err
 	}
@@ -63,6 +82,16 @@ func (s *sparkPlugin) start() error {
 		return err
 	}
 
+	if err := s.subscribeMaxDeliveries(js, wf.(*jobWorkflow)); err != nil {
+		return err
+	}
+
+	dls, err := newDeadLetters(js, s.config, wf)
+	if err != nil {
+		return err
+	}
+	s.deadLetters.Store(dls)
+
 	plugin.Serve(&plugin.ServeConfig{
 		HandshakeConfig: plugin.HandshakeConfig{
 			ProtocolVersion:  1,
@@ -85,9 +114,9 @@ func (s *sparkPlugin) createEventConsumer(js jetstream.JetStream, wf JobWorkflow
 		Name:              s.config.Id,
 		FilterSubject:     s.config.NatsRequestSubject,
 		AckPolicy:         jetstream.AckExplicitPolicy,
-		AckWait:           s.config.Timeout,
+		AckWait:           consumerAckWait,
 		MaxDeliver:        maxConsumerDeliver,
-		MaxAckPending:     maxConsumerAckPending,
+		MaxAckPending:     s.config.maxConcurrentJobs(),
 		InactiveThreshold: maxInactiveConsumerDuration,
 	})
 	if err != nil {
@@ -99,41 +128,47 @@ func (s *sparkPlugin) createEventConsumer(js jetstream.JetStream, wf JobWorkflow
 		var lastConsumedTime = time.Now()
 	loop:
 		for {
-			select {
-			// on consumer stopped
-			case <-s.ctx.Done():
+			// only fetch as many messages as there are free slots in the pool
+			free := s.pool.reserve(s.consumerCtx)
+			if free == 0 {
 				log.Info().Msgf("stopping consumer")
 				return
-			default:
-				batch, err := consumer.Fetch(ConsumerBatch, jetstream.FetchMaxWait(maxConsumerFetchWait))
-				if err != nil {
-					log.Error().Err(err).Msgf("failed to fetch job request messages, will retry shortly")
-					continue
-				}
+			}
 
-				var received bool
-				for msg := range batch.Messages() {
-					received = true
-					go func(m jetstream.Msg) {
-						m.Ack()
-						wf.Run(m)
-					}(msg)
-				}
+			batch, err := consumer.Fetch(min(free, ConsumerBatch), jetstream.FetchMaxWait(maxConsumerFetchWait))
+			if err != nil {
+				s.pool.release(free)
+				log.Error().Err(err).Msgf("failed to fetch job request messages, will retry shortly")
+				continue
+			}
 
-				if received {
-					lastConsumedTime = time.Now()
-				} else if time.Since(lastConsumedTime) > maxInactiveResetConsumerDuration {
-					err := backoff.Retry(func() error {
-						return s.createEventConsumer(js, wf)
-					}, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), maxConsumerCreationRetries))
+			var received bool
+			for msg := range batch.Messages() {
+				received = true
 
-					if err != nil {
-						log.Error().Err(err).Msgf(
-							"failed to re-subscribe audit event consumer after it became idle for too long")
-					}
+				// the workflow acknowledges the message once the result has been published, a message that is
+				// received after the spark started to stop is redelivered to another spark
+				if !s.pool.run(msg, wf.Run) {
+					_ = msg.Nak()
+					s.pool.release(1)
+				}
+				free--
+			}
+			s.pool.release(free)
 
-					break loop
+			if received {
+				lastConsumedTime = time.Now()
+			} else if time.Since(lastConsumedTime) > maxInactiveResetConsumerDuration {
+				err := backoff.Retry(func() error {
+					return s.createEventConsumer(js, wf)
+				}, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), maxConsumerCreationRetries))
+
+				if err != nil {
+					log.Error().Err(err).Msgf(
+						"failed to re-subscribe audit event consumer after it became idle for too long")
 				}
+
+				break loop
 			}
 		}
 	}()
@@ -141,7 +176,43 @@ func (s *sparkPlugin) createEventConsumer(js jetstream.JetStream, wf JobWorkflow
 	return nil
 }
 
+// subscribeMaxDeliveries dead-letters the job requests the consumer stopped delivering because they reached
+// MaxDeliver, the sparks of the consumer share a queue group so every advisory is handled once
+func (s *sparkPlugin) subscribeMaxDeliveries(js jetstream.JetStream, wf *jobWorkflow) error {
+	stream, err := js.Stream(s.ctx, s.config.NatsRequestStreamName)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf(maxDeliveriesAdvisorySubject, s.config.NatsRequestStreamName, s.config.Id)
+	_, err = s.nc.QueueSubscribe(subject, s.config.Id, func(msg *nats.Msg) {
+		if err := handleMaxDeliveries(s.ctx, stream, wf, msg.Data); err != nil {
+			log.Error().Err(err).Msgf("failed to dead-letter job request that reached its maximum deliveries")
+		}
+	})
+	return err
+}
+
+// deadLetterAPI returns the dead-letters of the plugin, replayed jobs run on the workflow of the plugin
+func (s *sparkPlugin) deadLetterAPI() (*DeadLetters, error) {
+	if s.config.NatsDeadLetterStreamName == "" {
+		return nil, ErrDeadLetterNotConfigured
+	}
+	if dls := s.deadLetters.Load(); dls != nil {
+		return dls, nil
+	}
+	return nil, ErrWorkerNotRunning
+}
+
+// stop stops consuming job requests and waits for the running jobs to return before the connection is drained,
+// jobs that do not return within the drain timeout are redelivered once their ack wait expires
 func (s *sparkPlugin) stop() {
+	s.stopConsumer()
+
+	if !s.pool.drain(s.config.drainTimeout()) {
+		log.Warn().Msgf("jobs did not complete within %s, stopping anyway", s.config.drainTimeout())
+	}
+
 	if s.nc != nil {
 		_ = s.nc.Drain()
 	}
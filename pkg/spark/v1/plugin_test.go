@@ -0,0 +1,174 @@
+package sparkv1
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1/util"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingWorkflow holds every job until release is closed and records the number of concurrent jobs
+type blockingWorkflow struct {
+	JobWorkflow
+	release   chan struct{}
+	running   int32
+	maxActive int32
+	completed int32
+}
+
+func (w *blockingWorkflow) Run(msg jetstream.Msg) {
+	active := atomic.AddInt32(&w.running, 1)
+	for {
+		max := atomic.LoadInt32(&w.maxActive)
+		if active <= max || atomic.CompareAndSwapInt32(&w.maxActive, max, active) {
+			break
+		}
+	}
+
+	<-w.release
+
+	atomic.AddInt32(&w.running, -1)
+	atomic.AddInt32(&w.completed, 1)
+	_ = msg.Ack()
+}
+
+func TestJobPoolDrain(t *testing.T) {
+	p := newJobPool(1, time.Second)
+	assert.True(t, p.drain(time.Second))
+
+	require.Equal(t, 1, p.reserve(context.Background()))
+	assert.False(t, p.run(nil, func(_ jetstream.Msg) {}), "no job must be started once the pool is drained")
+}
+
+func TestJobPoolReserve(t *testing.T) {
+	p := newJobPool(3, time.Second)
+
+	assert.Equal(t, 3, p.reserve(context.Background()), "all free slots must be reserved")
+	p.release(2)
+	assert.Equal(t, 2, p.reserve(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, 0, p.reserve(ctx), "no slot must be reserved once the context is done")
+}
+
+func TestPluginBoundsConcurrentJobs(t *testing.T) {
+	port, err := util.GetFreeTCPPort()
+	require.NoError(t, err)
+
+	s, err := util.RunServerOnPort(port, t.TempDir())
+	require.NoError(t, err)
+	defer s.Shutdown()
+	s.Start()
+
+	nc, js := util.GetNatsClient(port)
+
+	_, err = js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:      "REQUESTS",
+		Retention: jetstream.WorkQueuePolicy,
+		Subjects:  []string{"requests.>"},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err := js.Publish(context.Background(), fmt.Sprintf("requests.%d", i), []byte("{}"))
+		require.NoError(t, err)
+	}
+
+	p := newSparkPlugin(context.Background(), &Config{
+		Id:                    "pool",
+		NatsRequestSubject:    "requests.>",
+		NatsRequestStreamName: "REQUESTS",
+		MaxConcurrentJobs:     2,
+	}, nil, nil)
+	p.nc = nc
+
+	wf := &blockingWorkflow{release: make(chan struct{})}
+	require.NoError(t, p.createEventConsumer(js, wf))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&wf.running) == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	// no more jobs are started while the pool is full
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&wf.maxActive))
+
+	close(wf.release)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&wf.completed) == 5
+	}, time.Second*10, time.Millisecond*10)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&wf.maxActive))
+
+	var stopped sync.WaitGroup
+	stopped.Add(1)
+	go func() {
+		defer stopped.Done()
+		p.stop()
+	}()
+	assert.False(t, WaitTimeout(&stopped, time.Second*5), "stop must not wait for the consumer to finish fetching")
+}
+
+func TestPluginStopWaitsForRunningJobs(t *testing.T) {
+	port, err := util.GetFreeTCPPort()
+	require.NoError(t, err)
+
+	s, err := util.RunServerOnPort(port, t.TempDir())
+	require.NoError(t, err)
+	defer s.Shutdown()
+	s.Start()
+
+	nc, js := util.GetNatsClient(port)
+
+	_, err = js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:      "REQUESTS",
+		Retention: jetstream.WorkQueuePolicy,
+		Subjects:  []string{"requests.>"},
+	})
+	require.NoError(t, err)
+
+	_, err = js.Publish(context.Background(), "requests.1", []byte("{}"))
+	require.NoError(t, err)
+
+	p := newSparkPlugin(context.Background(), &Config{
+		Id:                    "drain",
+		NatsRequestSubject:    "requests.>",
+		NatsRequestStreamName: "REQUESTS",
+		MaxConcurrentJobs:     1,
+	}, nil, nil)
+	p.nc = nc
+
+	wf := &blockingWorkflow{release: make(chan struct{})}
+	require.NoError(t, p.createEventConsumer(js, wf))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&wf.running) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		p.stop()
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("stop must wait for the running job")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	close(wf.release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 5):
+		t.Fatal("stop must return once the running job returned")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&wf.completed))
+}
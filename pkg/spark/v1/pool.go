@@ -0,0 +1,126 @@
+package sparkv1
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// jobPool bounds the number of jobs that run concurrently
+type jobPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+	// closed is set once the pool is drained, no new jobs are started afterwards
+	closed bool
+	mu     sync.Mutex
+	// heartbeat is the interval in which running jobs are marked as in progress
+	heartbeat time.Duration
+}
+
+/************************************************************************/
+// POOL
+/************************************************************************/
+
+// reserve blocks until at least one slot is free and then reserves every free slot, the number of reserved
+// slots is returned, 0 is returned if the context is done before a slot became free
+func (p *jobPool) reserve(ctx context.Context) int {
+	select {
+	case <-ctx.Done():
+		return 0
+	case p.slots <- struct{}{}:
+	}
+
+	n := 1
+	for n < cap(p.slots) {
+		select {
+		case p.slots <- struct{}{}:
+			n++
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// release frees slots that were reserved but not used
+func (p *jobPool) release(n int) {
+	for i := 0; i < n; i++ {
+		<-p.slots
+	}
+}
+
+// run executes the job in a reserved slot, the slot is released once the job returned, the message is marked as
+// in progress while the job runs so it is not redelivered, returns false if the pool is drained
+func (p *jobPool) run(msg jetstream.Msg, fn func(msg jetstream.Msg)) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer p.release(1)
+
+		done := make(chan struct{})
+		defer close(done)
+		go p.keepInProgress(msg, done)
+
+		fn(msg)
+	}()
+	return true
+}
+
+// keepInProgress resets the ack wait of the message until done is closed
+func (p *jobPool) keepInProgress(msg jetstream.Msg, done <-chan struct{}) {
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := msg.InProgress(); err != nil {
+				log.Warn().Err(err).Msgf("unable to mark job request as in progress")
+			}
+		}
+	}
+}
+
+// drain stops the pool from starting new jobs and waits for the running jobs to return, returns false if they did
+// not return within the timeout
+func (p *jobPool) drain(timeout time.Duration) bool {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.wg.Wait()
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+/************************************************************************/
+// FACTORY
+/************************************************************************/
+
+func newJobPool(size int, heartbeat time.Duration) *jobPool {
+	return &jobPool{slots: make(chan struct{}, size), heartbeat: heartbeat}
+}
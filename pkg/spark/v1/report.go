@@ -30,10 +30,37 @@ func generateReportForChain(n *SparkChain) ChainReport {
 	}
 
 	generateReportForChainRecursively(&r, n.RootNode)
+	generateReportForReferences(&r, n.RootNode, map[string]bool{})
+	generateReportForSchema(&r, n.schema)
 
 	return r
 }
 
+// generateReportForSchema captures the declarations that failed and variables that are declared twice
+func generateReportForSchema(r *ChainReport, s *SparkSchema) {
+	if s == nil {
+		return
+	}
+
+	for _, declared := range []struct {
+		kind      string
+		variables []*VariableSchema
+	}{{"input", s.Inputs}, {"output", s.Outputs}} {
+		kind, names := declared.kind, map[string]bool{}
+		for _, v := range declared.variables {
+			switch {
+			case v.Name == "":
+				r.Errors = append(r.Errors, fmt.Errorf("%s Name can not be empty", kind))
+			case names[v.Name]:
+				r.Errors = append(r.Errors, fmt.Errorf("duplicate %s names are not permitted [Name]: %s", kind, v.Name))
+			case v.err != nil:
+				r.Errors = append(r.Errors, fmt.Errorf("invalid %s schema [Name]: %s: %w", kind, v.Name, v.err))
+			}
+			names[v.Name] = true
+		}
+	}
+}
+
 func generateReportForChainRecursively(r *ChainReport, n *Node) {
 	// must not have an empty Name
 	if n.Name == "" {
@@ -41,7 +68,8 @@ func generateReportForChainRecursively(r *ChainReport, n *Node) {
 	}
 
 	// SparkChain Node Name must be unique
-	if _, ok := r.NodeMap[n.Name]; ok {
+	_, duplicate := r.NodeMap[n.Name]
+	if duplicate {
 		r.Errors = append(r.Errors, fmt.Errorf("duplicate SparkChain names are not permitted [Name]: %s [at]: %s",
 			n.Name, n.breadcrumb))
 	} else {
@@ -53,25 +81,62 @@ func generateReportForChainRecursively(r *ChainReport, n *Node) {
 		}
 	}
 
+	if len(n.Stages) == 0 {
+		r.Errors = append(r.Errors, fmt.Errorf("SparkChain must have at least 1 Stage [Name]: %s [at]: %s",
+			n.Name, n.breadcrumb))
+	}
+
 	// first flat map all the Stages and capture validation errors
 	for _, s := range n.Stages {
-		if s.Name == "" {
-			r.Errors = append(r.Errors, fmt.Errorf("Stage Name can not be empty [at]: %s", n.breadcrumb))
+		generateReportForStage(r, s, n.breadcrumb)
+
+		if !s.IsParallel() {
 			continue
 		}
 
-		if _, ok := r.StageMap[s.Name]; ok {
-			r.Errors = append(r.Errors, fmt.Errorf("duplicate Stage names are not permitted [SparkChain]: %s [at]: %s",
+		if len(s.parallel) == 0 {
+			r.Errors = append(r.Errors, fmt.Errorf("parallel Stage group must have at least 1 Stage [Name]: %s [at]: %s",
 				s.Name, n.breadcrumb))
-		} else {
-			r.StageMap[s.Name] = ChainReportStage{
-				Name:  s.Name,
-				Crumb: n.breadcrumb,
-			}
+		}
+
+		for _, ps := range s.parallel {
+			generateReportForStage(r, ps, fmt.Sprintf("%s > %s", n.breadcrumb, s.Name))
 		}
 	}
 
+	for _, b := range n.Branches {
+		if b.selector == nil {
+			r.Errors = append(r.Errors, fmt.Errorf("branch must have a selector [Name]: %s [at]: %s",
+				b.Name, n.breadcrumb))
+		}
+
+		if len(b.Nodes) == 0 {
+			r.Errors = append(r.Errors, fmt.Errorf("branch must have at least 1 SparkChain [Name]: %s [at]: %s",
+				b.Name, n.breadcrumb))
+		}
+
+		for _, key := range b.Keys() {
+			generateReportForChainRecursively(r, b.Nodes[key])
+		}
+	}
+
+	// complete Stages share the names of the Stages as their results and statuses are stored by name, the name
+	// is derived from the SparkChain so it is already reported for duplicate SparkChain names
+	if n.HasCompletionStage() {
+		if !duplicate {
+			generateReportForStage(r, &Stage{Name: n.Complete.Name}, n.breadcrumb)
+		}
+	} else {
+		r.Errors = append(r.Errors, fmt.Errorf("SparkChain must have a Complete Stage [Name]: %s [at]: %s",
+			n.Name, n.breadcrumb))
+	}
+
 	if n.HasCompensationStage() {
+		// the stages of the compensation chain undo the stages of the node at the same index
+		if len(n.Stages) > 0 && len(n.Compensate.Stages) > len(n.Stages) {
+			r.Errors = append(r.Errors, fmt.Errorf("compensation SparkChain must not have more Stages than the "+
+				"SparkChain it compensates [Name]: %s [at]: %s", n.Compensate.Name, n.breadcrumb))
+		}
 		generateReportForChainRecursively(r, n.Compensate)
 	}
 
@@ -79,3 +144,101 @@ func generateReportForChainRecursively(r *ChainReport, n *Node) {
 		generateReportForChainRecursively(r, n.Cancel)
 	}
 }
+
+func generateReportForStage(r *ChainReport, s *Stage, crumb string) {
+	if s.Name == "" {
+		r.Errors = append(r.Errors, fmt.Errorf("Stage Name can not be empty [at]: %s", crumb))
+		return
+	}
+
+	if _, ok := r.StageMap[s.Name]; ok {
+		r.Errors = append(r.Errors, fmt.Errorf("duplicate Stage names are not permitted [SparkChain]: %s [at]: %s",
+			s.Name, crumb))
+		return
+	}
+
+	r.StageMap[s.Name] = ChainReportStage{
+		Name:  s.Name,
+		Crumb: crumb,
+	}
+}
+
+// generateReportForReferences captures stage options that read the status or result of a Stage that does not run
+// before the Stage, earlier holds the Stages that ran before the Node. Stages of all branches run before the
+// step after a branch and compensation and cancellation chains can see every Stage of their parent. The references
+// are taken from the manifest of the options, which evaluates them against an empty job, so references that an
+// option only reaches under a condition, e.g. after checking an input or another stage result, are not seen and
+// are not reported
+func generateReportForReferences(r *ChainReport, n *Node, earlier map[string]bool) {
+	visible := make(map[string]bool, len(earlier))
+	for name := range earlier {
+		visible[name] = true
+	}
+
+	for _, s := range n.Stages {
+		generateReportForStageReferences(r, s.Name, s.so, visible, n.breadcrumb)
+		for _, ps := range s.parallel {
+			generateReportForStageReferences(r, ps.Name, ps.so, visible, fmt.Sprintf("%s > %s", n.breadcrumb, s.Name))
+		}
+		if s.IsBranch() {
+			for _, key := range s.branch.Keys() {
+				generateReportForReferences(r, s.branch.Nodes[key], visible)
+			}
+		}
+
+		visible[s.Name] = true
+		for _, ps := range s.parallel {
+			visible[ps.Name] = true
+		}
+		if s.IsBranch() {
+			for _, key := range s.branch.Keys() {
+				addStageNames(visible, s.branch.Nodes[key])
+			}
+		}
+	}
+
+	if n.HasCompletionStage() {
+		generateReportForStageReferences(r, n.Complete.Name, n.Complete.so, visible, n.breadcrumb)
+	}
+
+	if n.HasCompensationStage() {
+		generateReportForReferences(r, n.Compensate, visible)
+	}
+
+	if n.HasCancellationStage() {
+		generateReportForReferences(r, n.Cancel, visible)
+	}
+}
+
+func generateReportForStageReferences(r *ChainReport, name string, options []StageOption, visible map[string]bool, crumb string) {
+	m := describeOptions(name, options)
+	if m == nil {
+		return
+	}
+
+	for _, ref := range append(append([]string{}, m.StageStatuses...), m.StageResults...) {
+		if !visible[ref] {
+			r.Errors = append(r.Errors, fmt.Errorf("Stage reference must point to an earlier Stage [Name]: %s [Reference]: %s [at]: %s",
+				name, ref, crumb))
+		}
+	}
+}
+
+// addStageNames adds the Stages of the Node and its branches, compensation and cancellation chains only run
+// when the Node fails so their Stages are never earlier
+func addStageNames(names map[string]bool, n *Node) {
+	for _, s := range n.Stages {
+		names[s.Name] = true
+		for _, ps := range s.parallel {
+			names[ps.Name] = true
+		}
+		if s.IsBranch() {
+			for _, key := range s.branch.Keys() {
+				addStageNames(names, s.branch.Nodes[key])
+			}
+		}
+	}
+	if n.HasCompletionStage() {
+		names[n.Complete.Name] = true
+	}
+}
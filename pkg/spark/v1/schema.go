@@ -0,0 +1,448 @@
+package sparkv1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+var (
+	ErrSchemaValidation  = errors.New("schema validation failed")
+	ErrUnsupportedSchema = errors.New("unsupported schema")
+)
+
+// openApiSchemaKeywords the keywords of JSON schema that OpenAPI 3.0 schemas support
+var openApiSchemaKeywords = map[string]bool{
+	"title": true, "description": true, "default": true, "format": true, "enum": true, "type": true,
+	"multipleOf": true, "maximum": true, "exclusiveMaximum": true, "minimum": true, "exclusiveMinimum": true,
+	"maxLength": true, "minLength": true, "pattern": true, "maxItems": true, "minItems": true, "uniqueItems": true,
+	"maxProperties": true, "minProperties": true, "required": true, "allOf": true, "oneOf": true, "anyOf": true,
+	"not": true, "items": true, "properties": true, "additionalProperties": true, "nullable": true,
+	"readOnly": true, "writeOnly": true, "deprecated": true, "example": true,
+}
+
+// jsonSchemaAnnotations keywords of JSON schema that do not affect validation, they are dropped
+var jsonSchemaAnnotations = map[string]bool{"$schema": true, "$comment": true, "examples": true}
+
+// SparkSchema the inputs a spark expects and the outputs its Complete stage produces, inputs are validated
+// before the first stage runs and outputs once the spark completed. A spark that declares outputs must not
+// produce outputs it did not declare
+type SparkSchema struct {
+	Inputs  []*VariableSchema `json:"inputs,omitempty"`
+	Outputs []*VariableSchema `json:"outputs,omitempty"`
+}
+
+// VariableSchema declares an input or output of a spark, the value of the variable must match the OpenAPI 3.0
+// schema and have one of the mime types, values that are streamed through the variable store are only checked for their
+// presence and mime type
+type VariableSchema struct {
+	Name      string           `json:"name"`
+	Required  bool             `json:"required"`
+	MimeTypes []codec.MimeType `json:"mime_types,omitempty"`
+	// Schema an OpenAPI 3.0 schema object, which is a subset of JSON schema with its own nullable keyword
+	Schema *openapi3.Schema `json:"schema,omitempty"`
+	// err is reported when the chain is validated, the builder can not return errors
+	err error
+}
+
+// SchemaViolation a variable that is missing or does not match its schema, Path points to the invalid part of
+// the value
+type SchemaViolation struct {
+	Variable string `json:"variable"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+/************************************************************************/
+// DECLARATION
+/************************************************************************/
+
+// NewVariableSchema declares a required variable whose value must match the OpenAPI 3.0 schema derived from T, see
+// SchemaFor
+func NewVariableSchema[T any](name string) *VariableSchema {
+	schema, err := SchemaFor[T]()
+	return &VariableSchema{Name: name, Required: true, Schema: schema, err: err}
+}
+
+// NewVariableSchemaFromJSON declares a required variable whose value must match the json encoded JSON schema, the
+// schema is translated to an OpenAPI 3.0 schema: a type list such as ["string","null"] becomes a nullable type or
+// a oneOf of the types and numeric exclusive bounds become bounds with the exclusive flag. Schemas with keywords
+// that can not be translated, e.g. const, $ref or if, are rejected instead of being validated partially
+func NewVariableSchemaFromJSON(name string, schema []byte) *VariableSchema {
+	s := openapi3.NewSchema()
+
+	var raw any
+	err := json.Unmarshal(schema, &raw)
+	if err == nil {
+		raw, err = translateJSONSchema(raw, "")
+	}
+	if err == nil {
+		var b []byte
+		if b, err = json.Marshal(raw); err == nil {
+			err = json.Unmarshal(b, s)
+		}
+	}
+	return &VariableSchema{Name: name, Required: true, Schema: s, err: err}
+}
+
+// Optional allows the variable to be missing, its value is still validated when it is present
+func (v *VariableSchema) Optional() *VariableSchema {
+	v.Required = false
+	return v
+}
+
+// WithMimeTypes only allows values with one of the mime types, a mime type without a sub type, e.g.
+// application/json, also allows its sub types such as application/json+text
+func (v *VariableSchema) WithMimeTypes(mimeTypes ...codec.MimeType) *VariableSchema {
+	v.MimeTypes = append(v.MimeTypes, mimeTypes...)
+	return v
+}
+
+// SchemaFor derives the OpenAPI 3.0 schema of T from its json tags, fields that can be nil are nullable and all other
+// fields without omitempty are required
+func SchemaFor[T any]() (*openapi3.Schema, error) {
+	g := openapi3gen.NewGenerator(
+		openapi3gen.UseAllExportedFields(),
+		openapi3gen.ThrowErrorOnCycle(),
+		openapi3gen.SchemaCustomizer(customizeSchema),
+	)
+	ref, err := g.GenerateSchemaRef(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	// the generator names the schemas by their type, they are inlined as cycles are rejected
+	for r := range g.SchemaRefs {
+		r.Ref = ""
+	}
+	return ref.Value, nil
+}
+
+// translateJSONSchema translates the JSON schema and its sub schemas to OpenAPI 3.0, path points to the schema
+// for errors
+func translateJSONSchema(v any, path string) (any, error) {
+	schema, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: a schema must be an object", ErrUnsupportedSchema, schemaPath(path))
+	}
+
+	for key := range schema {
+		if !openApiSchemaKeywords[key] && !jsonSchemaAnnotations[key] && !strings.HasPrefix(key, "x-") {
+			return nil, fmt.Errorf("%w: %s: unsupported keyword %s", ErrUnsupportedSchema, schemaPath(path), key)
+		}
+	}
+	for _, key := range []string{"$schema", "$comment", "examples"} {
+		delete(schema, key)
+	}
+
+	var err error
+	for _, key := range []string{"items", "not"} {
+		if sub, ok := schema[key]; ok {
+			if schema[key], err = translateJSONSchema(sub, path+"/"+key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if sub, ok := schema["additionalProperties"]; ok {
+		if _, isBool := sub.(bool); !isBool {
+			if schema["additionalProperties"], err = translateJSONSchema(sub, path+"/additionalProperties"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		if sub, ok := schema[key]; ok {
+			subs, ok := sub.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s/%s: must be an array", ErrUnsupportedSchema, schemaPath(path), key)
+			}
+			for i := range subs {
+				if subs[i], err = translateJSONSchema(subs[i], fmt.Sprintf("%s/%s/%d", path, key, i)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if sub, ok := schema["properties"]; ok {
+		props, ok := sub.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/properties: must be an object", ErrUnsupportedSchema, schemaPath(path))
+		}
+		for name := range props {
+			if props[name], err = translateJSONSchema(props[name], path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// JSON schema bounds the value with the exclusive keywords, OpenAPI 3.0 flags the inclusive keywords instead
+	for exclusive, bound := range map[string]string{"exclusiveMinimum": "minimum", "exclusiveMaximum": "maximum"} {
+		if n, ok := schema[exclusive].(float64); ok {
+			schema[bound], schema[exclusive] = n, true
+		}
+	}
+
+	list, ok := schema["type"].([]any)
+	if !ok {
+		return schema, nil
+	}
+	delete(schema, "type")
+
+	var types []any
+	for _, t := range list {
+		if t == "null" {
+			schema["nullable"] = true
+		} else {
+			types = append(types, t)
+		}
+	}
+
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		oneOf := make([]any, 0, len(types))
+		for _, t := range types {
+			oneOf = append(oneOf, map[string]any{"type": t})
+		}
+		allOf, _ := schema["allOf"].([]any)
+		schema["allOf"] = append(allOf, map[string]any{"oneOf": oneOf})
+	}
+	return schema, nil
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func customizeSchema(_ string, t reflect.Type, _ reflect.StructTag, schema *openapi3.Schema) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, omitEmpty := f.Name, false
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				omitEmpty = omitEmpty || opt == "omitempty"
+			}
+		}
+
+		prop, ok := schema.Properties[name]
+		if !ok || prop.Value == nil {
+			continue
+		}
+
+		switch f.Type.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			prop.Value.Nullable = true
+		default:
+			if !omitEmpty {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+	}
+	return nil
+}
+
+/************************************************************************/
+// VALIDATION
+/************************************************************************/
+
+// validateInputs checks the inputs of the job against the declared inputs
+func (s *SparkSchema) validateInputs(io SparkDataIO) StageError {
+	if s == nil {
+		return nil
+	}
+
+	violations := validateVariables(s.Inputs, func(name string) (Bindable, bool) {
+		v, ok := io.GetInputValue(name)
+		return v, ok
+	})
+	return newErrSchemaValidation("", "inputs", violations)
+}
+
+// validateOutputs checks the outputs of the spark against the declared outputs
+func (s *SparkSchema) validateOutputs(stageName string, outputs BindableMap) StageError {
+	if s == nil {
+		return nil
+	}
+
+	violations := validateVariables(s.Outputs, func(name string) (Bindable, bool) {
+		v, ok := outputs[name]
+		return v, ok
+	})
+
+	if len(s.Outputs) > 0 {
+		declared := make(map[string]bool, len(s.Outputs))
+		for _, vs := range s.Outputs {
+			declared[vs.Name] = true
+		}
+		var undeclared []string
+		for name := range outputs {
+			if !declared[name] {
+				undeclared = append(undeclared, name)
+			}
+		}
+		sort.Strings(undeclared)
+		for _, name := range undeclared {
+			violations = append(violations, SchemaViolation{Variable: name, Message: "variable is not declared"})
+		}
+	}
+	return newErrSchemaValidation(stageName, "outputs", violations)
+}
+
+// merge returns a schema with the declarations of both schemas, duplicates are reported by the chain validation
+func (s *SparkSchema) merge(other *SparkSchema) *SparkSchema {
+	if other == nil {
+		return s
+	}
+	if s == nil {
+		return other
+	}
+	return &SparkSchema{
+		Inputs:  append(append([]*VariableSchema{}, s.Inputs...), other.Inputs...),
+		Outputs: append(append([]*VariableSchema{}, s.Outputs...), other.Outputs...),
+	}
+}
+
+func validateVariables(schemas []*VariableSchema, lookup func(name string) (Bindable, bool)) []SchemaViolation {
+	var violations []SchemaViolation
+	for _, vs := range schemas {
+		b, ok := lookup(vs.Name)
+		if !ok || b == nil {
+			if vs.Required {
+				violations = append(violations, SchemaViolation{Variable: vs.Name, Message: "variable is required"})
+			}
+			continue
+		}
+
+		if !mimeTypeAllowed(codec.MimeType(b.GetMimeType()), vs.MimeTypes) {
+			violations = append(violations, SchemaViolation{Variable: vs.Name,
+				Message: fmt.Sprintf("mime type %s is not allowed, expected one of %v", b.GetMimeType(), vs.MimeTypes)})
+			continue
+		}
+
+		// streamed values are not read back from the store to validate them
+		if bv, ok := b.(*BindableValue); ok && bv.Ref != "" {
+			continue
+		}
+		if _, ok := b.(*storedValue); ok || vs.Schema == nil {
+			continue
+		}
+
+		value, err := jsonValue(b)
+		if err != nil {
+			violations = append(violations, SchemaViolation{Variable: vs.Name, Message: err.Error()})
+			continue
+		}
+		if err := vs.Schema.VisitJSON(value, openapi3.MultiErrors()); err != nil {
+			violations = append(violations, schemaViolations(vs.Name, err)...)
+		}
+	}
+	return violations
+}
+
+// mimeTypeAllowed compares the mime types without their parameters, all mime types are allowed if none are declared
+func mimeTypeAllowed(mimeType codec.MimeType, allowed []codec.MimeType) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	normalize := func(mt codec.MimeType) codec.MimeType {
+		v, _, _ := strings.Cut(string(mt), ";")
+		return codec.MimeType(strings.ToLower(strings.TrimSpace(v)))
+	}
+
+	mimeType = normalize(mimeType)
+	for _, a := range allowed {
+		a = normalize(a)
+		if mimeType == a || (!strings.Contains(string(a), "+") && mimeType.BaseType() == a) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValue decodes the value with the codec of its mime type into the types of encoding/json the schema
+// validation expects
+func jsonValue(b Bindable) (any, error) {
+	data, err := b.GetValue()
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+	if err := codec.DecodeAndBind(data, codec.MimeType(b.GetMimeType()), &v); err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(v); err != nil {
+		return nil, err
+	}
+	return v, json.Unmarshal(data, &v)
+}
+
+func schemaViolations(name string, err error) []SchemaViolation {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		var violations []SchemaViolation
+		for _, e := range multi {
+			violations = append(violations, schemaViolations(name, e)...)
+		}
+		return violations
+	}
+
+	var se *openapi3.SchemaError
+	if errors.As(err, &se) {
+		violation := SchemaViolation{Variable: name, Message: se.Reason}
+		if pointer := se.JSONPointer(); len(pointer) > 0 {
+			violation.Path = "/" + strings.Join(pointer, "/")
+		}
+		return []SchemaViolation{violation}
+	}
+	return []SchemaViolation{{Variable: name, Message: err.Error()}}
+}
+
+func newErrSchemaValidation(stageName, kind string, violations []SchemaViolation) StageError {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = fmt.Sprintf("%s%s: %s", v.Variable, v.Path, v.Message)
+	}
+
+	return NewStageErrorWithCode(ErrorCodeValidation,
+		fmt.Errorf("%w: %s: %s", ErrSchemaValidation, kind, strings.Join(messages, "; ")),
+		WithStageName(stageName),
+		WithMetadata(map[string]any{"violations": violations}),
+	)
+}
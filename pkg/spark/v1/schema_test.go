@@ -0,0 +1,151 @@
+package sparkv1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type schemaCustomer struct {
+	Name    string            `json:"name"`
+	Email   string            `json:"email,omitempty"`
+	Age     int               `json:"age"`
+	Address *schemaAddress    `json:"address"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Secret  string            `json:"-"`
+}
+
+type schemaAddress struct {
+	City string `json:"city"`
+}
+
+func TestSparkSchema(t *testing.T) {
+	t.Run("schema for struct", func(t *testing.T) {
+		schema, err := SchemaFor[schemaCustomer]()
+		require.NoError(t, err)
+
+		assert.Equal(t, "object", schema.Type)
+		assert.ElementsMatch(t, []string{"name", "age"}, schema.Required)
+		assert.NotContains(t, schema.Properties, "Secret")
+		assert.True(t, schema.Properties["address"].Value.Nullable)
+		assert.Equal(t, []string{"city"}, schema.Properties["address"].Value.Required)
+		assert.Equal(t, "array", schema.Properties["tags"].Value.Type)
+	})
+
+	t.Run("schema for basic types", func(t *testing.T) {
+		schema, err := SchemaFor[string]()
+		require.NoError(t, err)
+		assert.Equal(t, "string", schema.Type)
+	})
+
+	t.Run("validate variables", func(t *testing.T) {
+		s := &SparkSchema{Inputs: []*VariableSchema{
+			NewVariableSchema[schemaCustomer]("customer"),
+			NewVariableSchemaFromJSON("count", []byte(`{"type":"integer","minimum":1}`)),
+			NewVariableSchema[string]("note").Optional(),
+		}}
+
+		io := newIoDataProvider(nil, NewMemoryVariableStore())
+		io.SetInitialInputs(ExecuteSparkInputs{
+			"customer": {Value: []byte(`{"name":"acme","age":"old","address":{}}`), MimeType: string(codec.MimeTypeJson)},
+			"count":    {Value: []byte("0"), MimeType: string(codec.MimeTypeJson)},
+		})
+
+		err := s.validateInputs(io)
+		require.ErrorIs(t, err, ErrSchemaValidation)
+		assert.Equal(t, ErrorCodeValidation, err.ErrorCode())
+		assert.ElementsMatch(t, []any{
+			map[string]any{"variable": "customer", "path": "/age", "message": "value must be an integer"},
+			map[string]any{"variable": "customer", "path": "/address/city", "message": `property "city" is missing`},
+			map[string]any{"variable": "count", "message": "number must be at least 1"},
+		}, err.Metadata()["violations"])
+
+		io.SetInitialInputs(ExecuteSparkInputs{
+			"customer": {Value: []byte(`{"name":"acme","age":3,"address":null,"tags":null}`), MimeType: string(codec.MimeTypeJson)},
+			"count":    {Value: []byte("1"), MimeType: string(codec.MimeTypeJson)},
+		})
+		assert.Nil(t, s.validateInputs(io))
+	})
+
+	t.Run("validate yaml values", func(t *testing.T) {
+		s := &SparkSchema{Outputs: []*VariableSchema{NewVariableSchema[schemaAddress]("address")}}
+		outputs := BindableMap{"address": NewBindable(Value{Value: []byte("city: Cape Town\n"), MimeType: string(codec.MimeTypeYaml)})}
+		assert.Nil(t, s.validateOutputs("complete", outputs))
+
+		outputs = BindableMap{"address": NewBindable(Value{Value: []byte("town: Cape Town\n"), MimeType: string(codec.MimeTypeYaml)})}
+		err := s.validateOutputs("complete", outputs)
+		require.ErrorIs(t, err, ErrSchemaValidation)
+		assert.Equal(t, "complete", err.StageName())
+	})
+
+	t.Run("translate json schema type lists", func(t *testing.T) {
+		vs := NewVariableSchemaFromJSON("v", []byte(`{"type":"object","properties":{`+
+			`"name":{"type":["string","null"]},"id":{"type":["string","integer"]}}}`))
+		require.NoError(t, vs.err)
+		assert.Equal(t, "string", vs.Schema.Properties["name"].Value.Type)
+		assert.True(t, vs.Schema.Properties["name"].Value.Nullable)
+
+		s := &SparkSchema{Outputs: []*VariableSchema{vs}}
+		for _, value := range []string{`{"name":null,"id":1}`, `{"name":"a","id":"b"}`} {
+			outputs := BindableMap{"v": NewBindable(Value{Value: []byte(value), MimeType: string(codec.MimeTypeJson)})}
+			assert.Nil(t, s.validateOutputs("complete", outputs), value)
+		}
+
+		outputs := BindableMap{"v": NewBindable(Value{Value: []byte(`{"name":1,"id":true}`), MimeType: string(codec.MimeTypeJson)})}
+		err := s.validateOutputs("complete", outputs)
+		require.ErrorIs(t, err, ErrSchemaValidation)
+		assert.Contains(t, err.Metadata()["violations"],
+			map[string]any{"variable": "v", "path": "/name", "message": "value must be a string"})
+		assert.Contains(t, err.Metadata()["violations"],
+			map[string]any{"variable": "v", "path": "/id", "message": "value must be an integer"})
+	})
+
+	t.Run("reject json schema keywords that can not be translated", func(t *testing.T) {
+		for _, schema := range []string{`{"const":1}`, `{"$ref":"#/$defs/x","$defs":{"x":{}}}`,
+			`{"type":"object","properties":{"a":{"if":{},"then":{}}}}`, `{"items":[{"type":"string"}]}`} {
+			vs := NewVariableSchemaFromJSON("v", []byte(schema))
+			assert.ErrorIs(t, vs.err, ErrUnsupportedSchema, schema)
+		}
+
+		vs := NewVariableSchemaFromJSON("v", []byte(`{"$schema":"https://json-schema.org/draft/2020-12/schema",`+
+			`"type":"number","exclusiveMinimum":1}`))
+		require.NoError(t, vs.err)
+		assert.Equal(t, 1.0, *vs.Schema.Min)
+		assert.True(t, vs.Schema.ExclusiveMin)
+	})
+
+	t.Run("streamed values are only checked for their presence", func(t *testing.T) {
+		s := &SparkSchema{Outputs: []*VariableSchema{NewVariableSchema[schemaAddress]("address")}}
+		assert.Nil(t, s.validateOutputs("complete", BindableMap{"address": &BindableValue{Ref: "ref"}}))
+	})
+
+	t.Run("report invalid declarations", func(t *testing.T) {
+		b := NewBuilder().
+			Inputs(NewVariableSchema[string]("a"), NewVariableSchema[string]("a")).
+			Outputs(NewVariableSchemaFromJSON("b", []byte("not json")))
+		b.NewChain("chain").Stage("stage", nil).Complete(CompleteSuccess)
+
+		report := generateReportForChain(b.BuildChain())
+		assert.Len(t, report.Errors, 2)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		b := NewBuilder().Inputs(NewVariableSchema[schemaAddress]("address"))
+		b.NewChain("chain").Stage("stage", nil).Complete(CompleteSuccess)
+
+		out, err := json.Marshal(b.BuildChain().Schema())
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"inputs":[{"name":"address","required":true,"schema":{
+			"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}}]}`, string(out))
+
+		b = NewBuilder()
+		b.NewChain("chain").Stage("stage", nil).Complete(CompleteSuccess)
+		out, err = json.Marshal(b.BuildChain().Schema())
+		require.NoError(t, err)
+		assert.JSONEq(t, `{}`, string(out))
+	})
+}
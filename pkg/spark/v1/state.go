@@ -3,4 +3,8 @@ package sparkv1
 type JobState struct {
 	JobContext   *JobMetadata
 	StageResults map[string]Bindable
+	// StageStatuses the last reported status of every stage that was evaluated
+	StageStatuses map[string]StageStatus
+	// FailedStageError is set while a compensation chain is running
+	FailedStageError *ExecuteSparkError
 }
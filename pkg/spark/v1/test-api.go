@@ -29,25 +29,37 @@ type (
 		AssertStageCancelled(stageName string)
 		AssertStageFailed(stageName string)
 		AssertStageResult(stageName string, expectedStageResult any)
+		AssertStageResultMimeType(stageName string, mimeType codec.MimeType)
 		AssertStageOrder(stageNames ...string)
+		AssertStageAttempts(stageName string, attempts uint)
 	}
 
 	ExecuteStageRequest struct {
-		StageName     string
-		TransactionId string
-		CorrelationId string
-		JobKey        string
-		Inputs        map[string]Bindable
+		StageName        string
+		TransactionId    string
+		CorrelationId    string
+		JobKey           string
+		Inputs           map[string]Bindable
+		FailedStageError *ExecuteSparkError
+		// Attempt the number of the current attempt of the stage, starting at 1
+		Attempt uint
 	}
 
 	ExecuteStageResponse struct {
-		Outputs BindableMap        `json:"outputs,omitempty"`
-		Error   *ExecuteSparkError `json:"error,omitempty"`
+		Outputs      BindableMap               `json:"outputs,omitempty"`
+		Error        *ExecuteSparkError        `json:"error,omitempty"`
+		Compensation *ExecuteSparkCompensation `json:"compensation,omitempty"`
+		Cancellation *ExecuteSparkCancellation `json:"cancellation,omitempty"`
 	}
 
 	Value struct {
 		Value    []byte `json:"value"`
 		MimeType string `json:"mime_type"`
+		// Ref the key of the value in the variable store, set instead of Value for values that are stored
+		// separately so they can be loaded lazily or streamed
+		Ref string `json:"ref,omitempty"`
+		// err the error encoding the value, it is returned when the value is read
+		err error
 	}
 )
 
@@ -59,10 +71,16 @@ const (
 	StageStatus_STAGE_COMPLETED StageStatus = "STAGE_COMPLETED"
 	StageStatus_STAGE_FAILED    StageStatus = "STAGE_FAILED"
 	StageStatus_STAGE_SKIPPED   StageStatus = "STAGE_SKIPPED"
-	StageStatus_STAGE_CANCELED  StageStatus = "CANCELED"
+	StageStatus_STAGE_CANCELLED StageStatus = "STAGE_CANCELLED"
+
+	// Deprecated: stages are no longer reported with this status, cancelled stages are reported with
+	// StageStatus_STAGE_CANCELLED, the value is kept so existing consumers of "CANCELED" still decode
+	StageStatus_STAGE_CANCELED StageStatus = "CANCELED"
 )
 
 type InternalStageTracker interface {
 	SetStageResult(name string, value Bindable)
 	SetStageStatus(name string, status StageStatus)
+	// SetStageAttempt is called before every attempt of a stage, it may be called concurrently for parallel stages
+	SetStageAttempt(name string, attempt uint)
 }
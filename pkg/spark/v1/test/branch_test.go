@@ -0,0 +1,192 @@
+package module_test_runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/************************************************************************/
+// TYPES BRANCH SPARK
+/************************************************************************/
+
+type branchSpark struct {
+	executed map[string]bool
+}
+
+func (s *branchSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *branchSpark) Stop() {
+
+}
+
+func (s *branchSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("chain-1").
+		Stage("classify", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			var route string
+			if err := ctx.Input("route").Bind(&route); err != nil {
+				return nil, sparkv1.NewStageError(err)
+			}
+			return route, nil
+		}).
+		Branch("route", func(ctx sparkv1.StageContext) (string, sparkv1.StageError) {
+			var route string
+			if err := ctx.StageResult("classify").Bind(&route); err != nil {
+				return "", sparkv1.NewStageError(err)
+			}
+			return route, nil
+		}, map[string]sparkv1.Chain{
+			"a": b.NewChain("branch-a").
+				Stage("stage-a", s.stageFn("stage-a")).
+				Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+					if err := ctx.Output(sparkv1.NewVar("branch", codec.MimeTypeJson, "a")); err != nil {
+						return sparkv1.NewStageError(err)
+					}
+					return nil
+				}),
+			"b": b.NewChain("branch-b").
+				Stage("stage-b", s.stageFn("stage-b")).
+				Complete(sparkv1.CompleteSuccess),
+			"fail": b.NewChain("branch-fail").
+				Stage("stage-fail", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+					return nil, sparkv1.NewStageError(errors.New("branch failed"))
+				}).
+				Complete(sparkv1.CompleteSuccess),
+		}).
+		Stage("after", s.stageFn("after")).
+		Compensate(
+			b.NewChain("compensate").
+				Stage("undo", s.stageFn("undo")).
+				Complete(sparkv1.CompleteSuccess),
+		).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			var route string
+			if err := ctx.StageResult("route").Bind(&route); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			if err := ctx.Output(sparkv1.NewVar("route", codec.MimeTypeJson, route)); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+func (s *branchSpark) stageFn(name string) sparkv1.StageDefinitionFn {
+	return func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+		s.executed[name] = true
+		return name, nil
+	}
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestBranchExecution(t *testing.T) {
+	tests := []struct {
+		name        string
+		route       string
+		executed    []string
+		notExecuted []string
+	}{
+		{
+			name:        "should only execute branch a",
+			route:       "a",
+			executed:    []string{"stage-a", "after"},
+			notExecuted: []string{"stage-b", "undo"},
+		},
+		{
+			name:        "should only execute branch b",
+			route:       "b",
+			executed:    []string{"stage-b", "after"},
+			notExecuted: []string{"stage-a", "undo"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spark := &branchSpark{executed: map[string]bool{}}
+			worker, err := NewTestRunner(t, spark)
+			require.NoError(t, err)
+
+			ctx := NewTestJobContext(context.Background(), "branch", "cid", "tid", Inputs{
+				"route": {Value: test.route, MimeType: codec.MimeTypeJson},
+			})
+			result, err := worker.Execute(ctx)
+			require.NoError(t, err)
+
+			for _, stage := range test.executed {
+				assert.True(t, spark.executed[stage], "'%s' not executed", stage)
+				worker.AssertStageCompleted(stage)
+			}
+			for _, stage := range test.notExecuted {
+				assert.False(t, spark.executed[stage], "'%s' should not have executed", stage)
+			}
+			worker.AssertStageCompleted("route")
+
+			var route string
+			assert.NoError(t, result.Bind("route", &route))
+			assert.Equal(t, test.route, route)
+		})
+	}
+
+	t.Run("should return the outputs of the selected branch", func(t *testing.T) {
+		worker, err := NewTestRunner(t, &branchSpark{executed: map[string]bool{}})
+		require.NoError(t, err)
+
+		ctx := NewTestJobContext(context.Background(), "branch", "cid", "tid", Inputs{
+			"route": {Value: "a", MimeType: codec.MimeTypeJson},
+		})
+		result, err := worker.Execute(ctx)
+		require.NoError(t, err)
+
+		var branch string
+		assert.NoError(t, result.Bind("branch", &branch))
+		assert.Equal(t, "a", branch)
+	})
+
+	t.Run("should compensate the parent when the branch fails", func(t *testing.T) {
+		spark := &branchSpark{executed: map[string]bool{}}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		ctx := NewTestJobContext(context.Background(), "branch", "cid", "tid", Inputs{
+			"route": {Value: "fail", MimeType: codec.MimeTypeJson},
+		})
+		result, err := worker.Execute(ctx)
+		require.Error(t, err)
+		require.NotNil(t, result)
+		require.NotNil(t, result.Compensation)
+
+		assert.Equal(t, "compensate", result.Compensation.ChainName)
+		assert.Equal(t, "stage-fail", result.Error.StageName)
+		assert.True(t, spark.executed["undo"])
+		assert.False(t, spark.executed["after"])
+		worker.AssertStageFailed("stage-fail")
+	})
+
+	t.Run("should fail when the selected branch does not exist", func(t *testing.T) {
+		spark := &branchSpark{executed: map[string]bool{}}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		ctx := NewTestJobContext(context.Background(), "branch", "cid", "tid", Inputs{
+			"route": {Value: "unknown", MimeType: codec.MimeTypeJson},
+		})
+		result, err := worker.Execute(ctx)
+		require.Error(t, err)
+		require.NotNil(t, result)
+
+		assert.Equal(t, "route", result.Error.StageName)
+		assert.Contains(t, result.Error.ErrorMessage, sparkv1.ErrBranchNotFound.Error())
+		assert.False(t, spark.executed["after"])
+		worker.AssertStageFailed("route")
+	})
+}
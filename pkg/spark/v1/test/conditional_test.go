@@ -0,0 +1,166 @@
+package module_test_runner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+/************************************************************************/
+// TYPES CONDITIONAL SPARK
+/************************************************************************/
+
+type conditionalSpark struct {
+	executed map[string]bool
+	options  map[string][]sparkv1.StageOption
+}
+
+func (s *conditionalSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *conditionalSpark) Stop() {
+
+}
+
+func (s *conditionalSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("chain-1").
+		Stage("stage1", s.stageFn("stage1"), s.options["stage1"]...).
+		Stage("stage2", s.stageFn("stage2"), s.options["stage2"]...).
+		Stage("stage3", s.stageFn("stage3"), s.options["stage3"]...).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			// skipped stages return an empty result, binding it leaves the target untouched
+			out := "not set"
+			if err := ctx.StageResult("stage2").Bind(&out); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+
+			if err := ctx.Output(sparkv1.NewVar("stage2", codec.MimeTypeJson, out)); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+func (s *conditionalSpark) stageFn(name string) sparkv1.StageDefinitionFn {
+	return func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+		s.executed[name] = true
+		return name, nil
+	}
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestConditionalStageExecution(t *testing.T) {
+	tests := []struct {
+		name     string
+		inputs   Inputs
+		options  map[string][]sparkv1.StageOption
+		executed []string
+		skipped  []string
+		stage2   string
+	}{
+		{
+			name: "should skip the second stage and only execute the first and third stages",
+			options: map[string][]sparkv1.StageOption{
+				"stage2": {sparkv1.WithStageStatus("stage1", sparkv1.StageStatus_STAGE_FAILED)},
+			},
+			executed: []string{"stage1", "stage3"},
+			skipped:  []string{"stage2"},
+			stage2:   "not set",
+		},
+		{
+			name: "should execute first stage and skip remaining 2 stages",
+			options: map[string][]sparkv1.StageOption{
+				"stage2": {sparkv1.WithStageStatus("stage1", sparkv1.StageStatus_STAGE_FAILED)},
+				"stage3": {sparkv1.WithStageStatus("stage2", sparkv1.StageStatus_STAGE_CANCELLED)},
+			},
+			executed: []string{"stage1"},
+			skipped:  []string{"stage2", "stage3"},
+			stage2:   "not set",
+		},
+		{
+			name: "should skip when a previous stage completed",
+			options: map[string][]sparkv1.StageOption{
+				"stage2": {sparkv1.WithSkipOnStageStatus("stage1", sparkv1.StageStatus_STAGE_COMPLETED)},
+			},
+			executed: []string{"stage1", "stage3"},
+			skipped:  []string{"stage2"},
+			stage2:   "not set",
+		},
+		{
+			name: "should skip when the input matches the predicate",
+			inputs: Inputs{
+				"skip": {Value: true, MimeType: codec.MimeTypeJson},
+			},
+			options: map[string][]sparkv1.StageOption{
+				"stage2": {sparkv1.WithSkipOnInput("skip", func(input sparkv1.Input) bool {
+					var skip bool
+					_ = input.Bind(&skip)
+					return skip
+				})},
+			},
+			executed: []string{"stage1", "stage3"},
+			skipped:  []string{"stage2"},
+			stage2:   "not set",
+		},
+		{
+			name: "should execute when the input does not match the predicate",
+			inputs: Inputs{
+				"skip": {Value: false, MimeType: codec.MimeTypeJson},
+			},
+			options: map[string][]sparkv1.StageOption{
+				"stage2": {sparkv1.WithSkipOnInput("skip", func(input sparkv1.Input) bool {
+					var skip bool
+					_ = input.Bind(&skip)
+					return skip
+				})},
+			},
+			executed: []string{"stage1", "stage2", "stage3"},
+			stage2:   "stage2",
+		},
+		{
+			name: "should skip when the result of a skipped stage is required",
+			options: map[string][]sparkv1.StageOption{
+				"stage2": {sparkv1.WithStageStatus("stage1", sparkv1.StageStatus_STAGE_FAILED)},
+				"stage3": {sparkv1.WithSkipOnMissingStageResult("stage2")},
+			},
+			executed: []string{"stage1"},
+			skipped:  []string{"stage2", "stage3"},
+			stage2:   "not set",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spark := &conditionalSpark{executed: map[string]bool{}, options: test.options}
+			worker, err := NewTestRunner(t, spark)
+			assert.Nil(t, err)
+
+			ctx := NewTestJobContext(context.Background(), "conditional", "cid", "tid", test.inputs)
+			result, err := worker.Execute(ctx)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			for _, stage := range test.executed {
+				assert.True(t, spark.executed[stage], "'%s' not executed", stage)
+				worker.AssertStageCompleted(stage)
+			}
+
+			for _, stage := range test.skipped {
+				assert.False(t, spark.executed[stage], "'%s' should not have executed", stage)
+				worker.AssertStageSkipped(stage)
+			}
+
+			var stage2 string
+			assert.NoError(t, result.Bind("stage2", &stage2))
+			assert.Equal(t, test.stage2, stage2)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package module_test_runner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+/************************************************************************/
+// TYPES MIME SPARK
+/************************************************************************/
+
+type mimeSpark struct {
+	observed map[string]string
+}
+
+func (s *mimeSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *mimeSpark) Stop() {
+
+}
+
+func (s *mimeSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("chain-1").
+		Stage("string", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return "hello", nil
+		}).
+		Stage("struct", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return struct{ Foo string }{Foo: "bar"}, nil
+		}).
+		Stage("bytes", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return []byte("raw"), nil
+		}).
+		Stage("image", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return pngHeader, nil
+		}).
+		Stage("typed", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return sparkv1.NewResult([]byte("<svg/>"), codec.MimeTypeImageSvg), nil
+		}).
+		Stage("observe", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			for _, name := range []string{"string", "struct", "bytes", "image", "typed"} {
+				s.observed[name] = ctx.StageResult(name).GetMimeType()
+			}
+			return nil, nil
+		}).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			var image []byte
+			if err := ctx.StageResult("image").Bind(&image); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			if err := ctx.Output(&sparkv1.Var{Name: "image", MimeType: codec.MimeTypeImagePng, Value: image}); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestStageResultMimeTypes(t *testing.T) {
+	spark := &mimeSpark{observed: map[string]string{}}
+	worker, err := NewTestRunner(t, spark)
+	require.NoError(t, err)
+
+	result, err := worker.Execute(NewTestJobContext(context.Background(), "mime", "cid", "tid", Inputs{}))
+	require.NoError(t, err)
+
+	expected := map[string]codec.MimeType{
+		"string": codec.MimeTypeText,
+		"struct": codec.MimeTypeJson,
+		"bytes":  codec.MimeTypeOctetStream,
+		"image":  codec.MimeTypeImagePng,
+		"typed":  codec.MimeTypeImageSvg,
+	}
+	for name, mimeType := range expected {
+		worker.AssertStageResultMimeType(name, mimeType)
+		assert.Equal(t, string(mimeType), spark.observed[name], "stage %s", name)
+	}
+
+	worker.AssertStageResult("bytes", []byte("raw"))
+
+	mimeType, err := result.MimeType("image")
+	require.NoError(t, err)
+	assert.Equal(t, codec.MimeTypeImagePng, mimeType)
+
+	var image []byte
+	assert.NoError(t, result.Bind("image", &image))
+	assert.Equal(t, pngHeader, image)
+}
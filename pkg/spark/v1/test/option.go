@@ -13,6 +13,8 @@ import (
 
 type testOpts struct {
 	configBasePath string
+	store          sparkv1.VariableStore
+	nats           bool
 }
 
 type Option = func(je *testOpts) *testOpts
@@ -24,6 +26,23 @@ func WithBasePath(configBasePath string) Option {
 	}
 }
 
+// WithVariableStore runs the spark with the store, an in-memory store is used by default
+func WithVariableStore(store sparkv1.VariableStore) Option {
+	return func(jw *testOpts) *testOpts {
+		jw.store = store
+		return jw
+	}
+}
+
+// WithNatsServer runs the spark with a JetStream object store of an embedded NATS server instead of an in-memory
+// store
+func WithNatsServer() Option {
+	return func(jw *testOpts) *testOpts {
+		jw.nats = true
+		return jw
+	}
+}
+
 func WithSparkConfigYAML(d []byte) sparkv1.Option {
 	var m map[string]any
 	if err := yaml.Unmarshal(d, &m); err != nil {
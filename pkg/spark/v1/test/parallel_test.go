@@ -0,0 +1,148 @@
+package module_test_runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+/************************************************************************/
+// TYPES PARALLEL SPARK
+/************************************************************************/
+
+type parallelSpark struct {
+	mode    sparkv1.ParallelMode
+	fail    map[string]bool
+	barrier sync.WaitGroup
+}
+
+func (s *parallelSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *parallelSpark) Stop() {
+
+}
+
+func (s *parallelSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	s.barrier.Add(2)
+	return b.NewChain("chain-1").
+		Parallel("fan-out", s.mode,
+			sparkv1.NewStage("call-a", s.call("a")),
+			sparkv1.NewStage("call-b", s.call("b")),
+			sparkv1.NewStage("call-c", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+				if s.fail["c"] {
+					return nil, sparkv1.NewStageError(errors.New("c failed"))
+				}
+				return "c", nil
+			}),
+		).
+		Stage("join", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			var a, b, c string
+			for name, target := range map[string]*string{"call-a": &a, "call-b": &b, "call-c": &c} {
+				if err := ctx.StageResult(name).Bind(target); err != nil {
+					return nil, sparkv1.NewStageError(err)
+				}
+			}
+			return a + b + c, nil
+		}).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			var joined string
+			if err := ctx.StageResult("join").Bind(&joined); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			if err := ctx.Output(sparkv1.NewVar("joined", codec.MimeTypeJson, joined)); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+// call waits for the other call before returning, this only completes when both run concurrently
+func (s *parallelSpark) call(name string) sparkv1.StageDefinitionFn {
+	return func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+		s.barrier.Done()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.barrier.Wait()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			return nil, sparkv1.NewStageError(errors.New("stages did not run concurrently"))
+		}
+
+		if s.fail[name] {
+			return nil, sparkv1.NewStageError(errors.New(name + " failed"))
+		}
+		return name, nil
+	}
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestParallelStages(t *testing.T) {
+	t.Run("should run the stages of a group concurrently", func(t *testing.T) {
+		spark := &parallelSpark{mode: sparkv1.ParallelModeFailFast}
+		worker, err := NewTestRunner(t, spark)
+		assert.Nil(t, err)
+
+		result, err := worker.Execute(NewTestJobContext(context.Background(), "parallel", "cid", "tid", Inputs{}))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var joined string
+		assert.NoError(t, result.Bind("joined", &joined))
+		assert.Equal(t, "abc", joined)
+
+		worker.AssertStageCompleted("fan-out")
+		worker.AssertStageCompleted("call-a")
+		worker.AssertStageCompleted("call-b")
+		worker.AssertStageCompleted("call-c")
+		worker.AssertStageCompleted("join")
+	})
+
+	t.Run("should report the first error in fail-fast mode", func(t *testing.T) {
+		spark := &parallelSpark{mode: sparkv1.ParallelModeFailFast, fail: map[string]bool{"c": true}}
+		worker, err := NewTestRunner(t, spark)
+		assert.Nil(t, err)
+
+		_, err = worker.ExecuteWithoutStageRetryOverride(NewTestJobContext(context.Background(), "parallel", "cid", "tid", Inputs{}))
+		assert.ErrorContains(t, err, "c failed")
+
+		worker.AssertStageFailed("fan-out")
+		worker.AssertStageFailed("call-c")
+	})
+
+	t.Run("should report all errors in collect-all mode", func(t *testing.T) {
+		spark := &parallelSpark{mode: sparkv1.ParallelModeCollectAll, fail: map[string]bool{"a": true, "c": true}}
+		worker, err := NewTestRunner(t, spark)
+		assert.Nil(t, err)
+
+		_, err = worker.ExecuteWithoutStageRetryOverride(NewTestJobContext(context.Background(), "parallel", "cid", "tid", Inputs{}))
+		var se *sparkv1.ExecuteSparkError
+		if !assert.ErrorAs(t, err, &se) {
+			return
+		}
+
+		assert.Equal(t, sparkv1.ErrorCodeParallelStagesFailed, se.ErrorCode)
+		assert.Equal(t, "fan-out", se.StageName)
+		assert.Equal(t, "2 parallel stages failed: call-a: a failed; call-c: c failed", se.ErrorMessage)
+
+		worker.AssertStageFailed("call-a")
+		worker.AssertStageCompleted("call-b")
+		worker.AssertStageFailed("call-c")
+	})
+}
@@ -0,0 +1,149 @@
+package module_test_runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const errorCodeUnavailable sparkv1.ErrorCode = "UNAVAILABLE"
+
+/************************************************************************/
+// TYPES RETRY SPARK
+/************************************************************************/
+
+type retrySpark struct {
+	policy   sparkv1.RetryConfig
+	failures uint
+	code     sparkv1.ErrorCode
+	attempts []uint
+}
+
+func (s *retrySpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *retrySpark) Stop() {
+
+}
+
+func (s *retrySpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("chain-1").
+		Stage("flaky", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			s.attempts = append(s.attempts, ctx.Attempt())
+			if ctx.Attempt() <= s.failures {
+				return nil, sparkv1.NewStageErrorWithCode(s.code, errors.New("unavailable"))
+			}
+			return "done", nil
+		}, sparkv1.WithRetryPolicy(s.policy)).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			var out string
+			if err := ctx.StageResult("flaky").Bind(&out); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			if err := ctx.Output(sparkv1.NewVar("out", codec.MimeTypeJson, out)); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("should retry the stage until it completes", func(t *testing.T) {
+		spark := &retrySpark{
+			policy: sparkv1.RetryConfig{
+				Times:             3,
+				FirstBackoffWait:  time.Millisecond * 10,
+				BackoffMultiplier: 2,
+				MaxBackoffWait:    time.Millisecond * 15,
+				Jitter:            0.1,
+				RetryOn:           []sparkv1.ErrorCode{errorCodeUnavailable},
+			},
+			failures: 2,
+			code:     errorCodeUnavailable,
+		}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		result, err := worker.ExecuteWithoutStageRetryOverride(NewTestJobContext(context.Background(), "retry", "cid", "tid", Inputs{}))
+		require.NoError(t, err)
+
+		var out string
+		assert.NoError(t, result.Bind("out", &out))
+		assert.Equal(t, "done", out)
+		assert.Equal(t, []uint{1, 2, 3}, spark.attempts)
+		worker.AssertStageAttempts("flaky", 3)
+		worker.AssertStageCompleted("flaky")
+	})
+
+	t.Run("should fail once the attempts are exhausted", func(t *testing.T) {
+		spark := &retrySpark{
+			policy:   sparkv1.RetryConfig{Times: 2, FirstBackoffWait: time.Millisecond, BackoffMultiplier: 1},
+			failures: 5,
+			code:     errorCodeUnavailable,
+		}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		_, err = worker.ExecuteWithoutStageRetryOverride(NewTestJobContext(context.Background(), "retry", "cid", "tid", Inputs{}))
+		require.Error(t, err)
+		assert.Equal(t, []uint{1, 2}, spark.attempts)
+		worker.AssertStageAttempts("flaky", 2)
+		worker.AssertStageFailed("flaky")
+	})
+
+	t.Run("should not retry error codes that are not in the retry on list", func(t *testing.T) {
+		spark := &retrySpark{
+			policy: sparkv1.RetryConfig{
+				Times:             3,
+				FirstBackoffWait:  time.Millisecond,
+				BackoffMultiplier: 1,
+				RetryOn:           []sparkv1.ErrorCode{sparkv1.ErrorCodeTimeout},
+			},
+			failures: 1,
+			code:     errorCodeUnavailable,
+		}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		_, err = worker.ExecuteWithoutStageRetryOverride(NewTestJobContext(context.Background(), "retry", "cid", "tid", Inputs{}))
+		require.Error(t, err)
+		assert.Equal(t, []uint{1}, spark.attempts)
+		worker.AssertStageFailed("flaky")
+	})
+
+	t.Run("should stop waiting for the next attempt when the job is cancelled", func(t *testing.T) {
+		spark := &retrySpark{
+			policy:   sparkv1.RetryConfig{Times: 3, FirstBackoffWait: time.Minute, BackoffMultiplier: 1},
+			failures: 5,
+			code:     errorCodeUnavailable,
+		}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		ctx := NewTestJobContext(context.Background(), "retry", "cid", "tid", Inputs{})
+		deadline := time.Now().Add(time.Second * 2)
+		ctx.Metadata.Deadline = &deadline
+
+		start := time.Now()
+		_, err = worker.ExecuteWithoutStageRetryOverride(ctx)
+		require.Error(t, err)
+
+		var se *sparkv1.ExecuteSparkError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, sparkv1.ErrorCodeCancelled, se.ErrorCode)
+		assert.Less(t, time.Since(start), time.Second*30)
+		assert.Equal(t, []uint{1}, spark.attempts)
+		worker.AssertStageCancelled("flaky")
+	})
+}
@@ -0,0 +1,129 @@
+package module_test_runner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/************************************************************************/
+// TYPES SCHEMA SPARK
+/************************************************************************/
+
+type schemaOrder struct {
+	Id     string `json:"id"`
+	Amount int    `json:"amount"`
+}
+
+type schemaReceipt struct {
+	OrderId string `json:"order_id"`
+	Total   int    `json:"total"`
+}
+
+type schemaSpark struct {
+	executed bool
+	// receipt the output the Complete stage produces
+	receipt any
+}
+
+func (s *schemaSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *schemaSpark) Stop() {
+
+}
+
+func (s *schemaSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.
+		Inputs(sparkv1.NewVariableSchema[schemaOrder]("order"), sparkv1.NewVariableSchema[string]("note").Optional()).
+		Outputs(sparkv1.NewVariableSchema[schemaReceipt]("receipt")).
+		NewChain("chain-1").
+		Stage("stage-1", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			s.executed = true
+			return nil, nil
+		}).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			if err := ctx.Output(sparkv1.NewVar("receipt", codec.MimeTypeJson, s.receipt)); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestSparkSchema(t *testing.T) {
+	newContext := func(order any) *sparkv1.JobContext {
+		inputs := Inputs{}
+		if order != nil {
+			inputs["order"] = &Input{Value: order, MimeType: codec.MimeTypeJson}
+		}
+		return NewTestJobContext(context.Background(), "schema", "cid", "tid", inputs)
+	}
+
+	t.Run("should complete when the inputs and outputs match their schema", func(t *testing.T) {
+		spark := &schemaSpark{receipt: schemaReceipt{OrderId: "1", Total: 10}}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		result, err := worker.Execute(newContext(schemaOrder{Id: "1", Amount: 10}))
+		require.NoError(t, err)
+
+		var receipt schemaReceipt
+		require.NoError(t, result.Bind("receipt", &receipt))
+		assert.Equal(t, schemaReceipt{OrderId: "1", Total: 10}, receipt)
+		assert.True(t, spark.executed)
+	})
+
+	t.Run("should fail before the first stage when inputs are invalid", func(t *testing.T) {
+		spark := &schemaSpark{receipt: schemaReceipt{OrderId: "1", Total: 10}}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		_, err = worker.Execute(newContext(map[string]any{"id": 1}))
+		var se *sparkv1.ExecuteSparkError
+		require.ErrorAs(t, err, &se)
+
+		assert.Equal(t, sparkv1.ErrorCodeValidation, se.ErrorCode)
+		assert.Contains(t, se.ErrorMessage, sparkv1.ErrSchemaValidation.Error())
+		assert.Len(t, se.Metadata["violations"], 2)
+		assert.False(t, spark.executed)
+	})
+
+	t.Run("should fail before the first stage when a required input is missing", func(t *testing.T) {
+		spark := &schemaSpark{receipt: schemaReceipt{OrderId: "1", Total: 10}}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		_, err = worker.Execute(newContext(nil))
+		var se *sparkv1.ExecuteSparkError
+		require.ErrorAs(t, err, &se)
+
+		assert.Equal(t, sparkv1.ErrorCodeValidation, se.ErrorCode)
+		assert.Equal(t, []any{map[string]any{"variable": "order", "message": "variable is required"}},
+			se.Metadata["violations"])
+		assert.False(t, spark.executed)
+	})
+
+	t.Run("should fail when the outputs are invalid", func(t *testing.T) {
+		spark := &schemaSpark{receipt: map[string]any{"order_id": "1", "total": "ten"}}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		_, err = worker.Execute(newContext(schemaOrder{Id: "1", Amount: 10}))
+		var se *sparkv1.ExecuteSparkError
+		require.ErrorAs(t, err, &se)
+
+		assert.Equal(t, sparkv1.ErrorCodeValidation, se.ErrorCode)
+		assert.Equal(t, []any{map[string]any{"variable": "receipt", "path": "/total", "message": `value must be an integer`}},
+			se.Metadata["violations"])
+		assert.True(t, spark.executed)
+	})
+}
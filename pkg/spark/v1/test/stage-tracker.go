@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 	"reflect"
+	"sync"
 	"testing"
 
 	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
@@ -22,11 +23,13 @@ type stageTracker struct {
 	results     map[string]*result
 	t           *testing.T
 	resultOrder []string
+	mu          sync.Mutex
 }
 
 type result struct {
 	value       sparkv1.Bindable
 	stageStatus sparkv1.StageStatus
+	attempts    uint
 }
 
 func (st *stageTracker) GetStageResult(name string) (data any, mime codec.MimeType, err sparkv1.StageError) {
@@ -49,24 +52,34 @@ func (st *stageTracker) GetStageResult(name string) (data any, mime codec.MimeTy
 }
 
 func (st *stageTracker) SetStageStatus(name string, status sparkv1.StageStatus) {
-	res, ok := st.results[name]
-	if !ok {
-		res = &result{}
-		st.results[name] = res
-	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
-	res.stageStatus = status
+	st.getOrCreateResult(name).stageStatus = status
 }
 
 func (st *stageTracker) SetStageResult(name string, val sparkv1.Bindable) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.getOrCreateResult(name).value = val
+	st.resultOrder = append(st.resultOrder, name)
+}
+
+func (st *stageTracker) SetStageAttempt(name string, attempt uint) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.getOrCreateResult(name).attempts = attempt
+}
+
+func (st *stageTracker) getOrCreateResult(name string) *result {
 	res, ok := st.results[name]
 	if !ok {
 		res = &result{}
 		st.results[name] = res
 	}
-
-	res.value = val
-	st.resultOrder = append(st.resultOrder, name)
+	return res
 }
 
 func (st *stageTracker) AssertStageCompleted(stageName string) {
@@ -82,7 +95,7 @@ func (st *stageTracker) AssertStageSkipped(stageName string) {
 }
 
 func (st *stageTracker) AssertStageCancelled(stageName string) {
-	st.assertStageStatus(stageName, sparkv1.StageStatus_STAGE_CANCELED)
+	st.assertStageStatus(stageName, sparkv1.StageStatus_STAGE_CANCELLED)
 }
 
 func (st *stageTracker) AssertStageFailed(stageName string) {
@@ -106,6 +119,26 @@ func (st *stageTracker) AssertStageResult(stageName string, expectedStageResult
 	assert.Equal(st.t, expectedStageResult, newVal)
 }
 
+func (st *stageTracker) AssertStageResultMimeType(stageName string, mimeType codec.MimeType) {
+	res, ok := st.results[stageName]
+	if !ok || res.value == nil {
+		st.t.Error(fmt.Errorf("%w: %s", ErrNoStageResult, stageName))
+		return
+	}
+
+	assert.Equal(st.t, string(mimeType), res.value.GetMimeType(), "stage result mime type expected: '%s' got: '%s'", mimeType, res.value.GetMimeType())
+}
+
+func (st *stageTracker) AssertStageAttempts(stageName string, attempts uint) {
+	res, ok := st.results[stageName]
+	if !ok {
+		st.t.Error(fmt.Errorf("%w: %s", ErrNoStageResult, stageName))
+		return
+	}
+
+	assert.Equal(st.t, attempts, res.attempts, "stage attempts expected: '%d' got: '%d'", attempts, res.attempts)
+}
+
 func (st *stageTracker) AssertStageOrder(stageNames ...string) {
 	if len(stageNames) > len(st.resultOrder) {
 		st.t.Fatalf("more stage names provided than were executed")
@@ -0,0 +1,86 @@
+package module_test_runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const streamSize = 1024 * 1024
+
+/************************************************************************/
+// TYPES STREAM SPARK
+/************************************************************************/
+
+type streamSpark struct{}
+
+func (s *streamSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *streamSpark) Stop() {
+
+}
+
+func (s *streamSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("chain-1").
+		Stage("produce", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return sparkv1.NewStreamResult(bytes.NewReader(bytes.Repeat([]byte("a"), streamSize)), codec.MimeTypeOctetStream), nil
+		}).
+		Stage("consume", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			r, err := ctx.StageResult("produce").Reader()
+			if err != nil {
+				return nil, sparkv1.NewStageError(err)
+			}
+			defer r.Close()
+
+			n, err := io.Copy(io.Discard, r)
+			if err != nil {
+				return nil, sparkv1.NewStageError(err)
+			}
+			return n, nil
+		}).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			var size int64
+			if err := ctx.StageResult("consume").Bind(&size); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			if err := ctx.Output(
+				sparkv1.NewVar("size", codec.MimeTypeJson, size),
+				sparkv1.NewStreamVar("data", codec.MimeTypeOctetStream, strings.NewReader("streamed output")),
+			); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestStreamedResults(t *testing.T) {
+	worker, err := NewTestRunner(t, &streamSpark{})
+	require.NoError(t, err)
+
+	result, err := worker.Execute(NewTestJobContext(context.Background(), "stream", "cid", "tid", Inputs{}))
+	require.NoError(t, err)
+
+	worker.AssertStageCompleted("produce")
+	worker.AssertStageCompleted("consume")
+
+	var size int64
+	assert.NoError(t, result.Bind("size", &size))
+	assert.Equal(t, int64(streamSize), size)
+
+	var data []byte
+	assert.NoError(t, result.Bind("data", &data))
+	assert.Equal(t, "streamed output", string(data))
+}
@@ -21,9 +21,14 @@ var (
 	ErrInvalidStageResultMimeType = errors.New("stage result expects mime-type of application/json")
 )
 
+// runnerMaxDeliver the number of times a rejected job is delivered, the same as the consumer of a spark
+const runnerMaxDeliver = 3
+
 // RunnerTest Test Helper
 type RunnerTest interface {
 	sparkv1.StageTracker
+	// Execute runs the spark and returns its outputs, when the job is cancelled the outputs are returned along
+	// with the cancellation error and the interrupted stage is reported as cancelled instead of failed
 	Execute(ctx *sparkv1.JobContext, opts ...sparkv1.Option) (*Outputs, error)
 	ExecuteWithoutStageRetryOverride(ctx *sparkv1.JobContext, opts ...sparkv1.Option) (*Outputs, error)
 }
@@ -50,6 +55,14 @@ func (o *Outputs) Bind(varName string, target any) error {
 	return err
 }
 
+// MimeType returns the mime type of an output
+func (o *Outputs) MimeType(varName string) (codec.MimeType, error) {
+	if o == nil || o.Outputs == nil || o.Outputs[varName] == nil {
+		return "", fmt.Errorf("%w: %s", ErrNoOutput, varName)
+	}
+	return codec.MimeType(o.Outputs[varName].GetMimeType()), nil
+}
+
 type runnerTest struct {
 	sparkv1.StageTracker
 	sparkv1.InternalStageTracker
@@ -67,6 +80,8 @@ type runnerTestOutput struct {
 	Model         string                            `json:"model,omitempty"`
 	Outputs       map[string]*sparkv1.BindableValue `json:"outputs,omitempty"`
 	Error         *sparkv1.ExecuteSparkError        `json:"error,omitempty"`
+	Compensation  *sparkv1.ExecuteSparkCompensation `json:"compensation,omitempty"`
+	Cancellation  *sparkv1.ExecuteSparkCancellation `json:"cancellation,omitempty"`
 }
 
 func (r *runnerTest) Execute(ctx *sparkv1.JobContext, opts ...sparkv1.Option) (*Outputs, error) {
@@ -87,29 +102,9 @@ func (r *runnerTest) execute(ctx *sparkv1.JobContext, addStageOverride bool, opt
 
 	jmd.VariablesKey = jmd.JobKeyValue
 
-	tmpDir := r.t.TempDir()
-	log.Info().Msgf("tmp dir %s %s", ctx.Metadata.JobKeyValue, tmpDir)
-
-	port, err := util.GetFreeTCPPort()
-	if err != nil {
-		return nil, fmt.Errorf("error getting free tcp port: %w", err)
-	}
-
-	s, err := util.RunServerOnPort(port, tmpDir)
-	if err != nil {
-		return nil, fmt.Errorf("error running nats server: %w", err)
-	}
-	defer s.Shutdown()
-	s.Start()
-
-	nc, js := util.GetNatsClient(port)
-	defer nc.Close()
-
-	store, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
-		Bucket: "test",
-	})
+	store, err := r.variableStore()
 	if err != nil {
-		return nil, fmt.Errorf("error creating object store: %w", err)
+		return nil, err
 	}
 
 	//Initialise spark
@@ -130,61 +125,40 @@ func (r *runnerTest) execute(ctx *sparkv1.JobContext, addStageOverride bool, opt
 		}
 	}
 
+	// the result is captured instead of being published to a NATS server
+	var res *runnerTestOutput
+	publish := func(b []byte) error {
+		return json.Unmarshal(b, &res)
+	}
+
 	// Create new workflow
-	wf, _ := sparkv1.NewJobWorkflow(
+	wf, err := sparkv1.NewJobWorkflow(
 		ctx, uuid.NewString(), chain,
 		sparkv1.WithStageTracker(r.InternalStageTracker),
-		sparkv1.WithNatsClient(nc),
-		sparkv1.WithObjectStore(store),
+		sparkv1.WithVariableStore(store),
+		sparkv1.WithResultPublisher(publish),
 		sparkv1.WithInputs(ctx.Metadata.Inputs),
-		sparkv1.WithConfig(&sparkv1.Config{
-			NatsResponseSubject: "agent.v1.job.a.b.test." + ctx.Metadata.JobKeyValue,
-		}),
+		sparkv1.WithConfig(&sparkv1.Config{}),
 		sparkv1.WithStageRetryOverride(stageRetryOverride),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error creating new workflow: %w", err)
 	}
 
-	// start the request consumer
-	requestSubject, err := r.startRequestConsumer(ctx, js, wf)
-	if err != nil {
-		return nil, fmt.Errorf("error starting request consumer: %w", err)
-	}
-
-	// start the response consumer
-	responseConsumer, _, err := r.startResponseConsumer(ctx, js)
-	if err != nil {
-		return nil, fmt.Errorf("error starting response consumer: %w", err)
-	}
-
 	b, err := json.Marshal(ctx.Metadata)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling metadata: %w", err)
 	}
 
-	if _, err := js.Publish(ctx, requestSubject, b); err != nil {
-		return nil, fmt.Errorf("error publishing to a stream: %w", err)
-	}
-
-	msgs, err := responseConsumer.Fetch(1, jetstream.FetchMaxWait(time.Second*120))
-	if err != nil {
-		return nil, fmt.Errorf("error fetching messages: %w", err)
-	}
-
-	var res *runnerTestOutput
-	for msg := range msgs.Messages() {
-		if err := json.Unmarshal(msg.Data(), &res); err != nil {
-			return nil, fmt.Errorf("error unmarshaling message: %w", err)
-		}
+	// a rejected job is delivered again until it was delivered as often as by the spark consumer
+	msg := &runnerMsg{subject: "agent.v1.job.request." + jmd.JobKeyValue, data: b}
+	for !msg.acked && msg.deliveries < runnerMaxDeliver {
+		msg.deliveries++
+		wf.Run(msg)
 	}
 
 	if res == nil {
-		return nil, errors.New("timed out")
-	}
-
-	if res.Error != nil {
-		return nil, res.Error
+		return nil, fmt.Errorf("no result was published for job %s after %d deliveries", jmd.JobKeyValue, msg.deliveries)
 	}
 
 	output := sparkv1.ExecuteSparkOutput{
@@ -194,107 +168,110 @@ func (r *runnerTest) execute(ctx *sparkv1.JobContext, addStageOverride bool, opt
 		CorrelationId: jmd.CorrelationIdValue,
 		TransactionId: jmd.TransactionIdValue,
 		Model:         jmd.Model,
+		Compensation:  res.Compensation,
+		Cancellation:  res.Cancellation,
 	}
 
-	ob, err := store.GetBytes(ctx, res.VariablesKey)
-	if err != nil {
-		if !errors.Is(err, jetstream.ErrObjectNotFound) {
-			return nil, fmt.Errorf("error object not found: %w", err)
-		}
-	} else {
-		if err := json.Unmarshal(ob, &res.Outputs); err != nil {
-			return nil, fmt.Errorf("error unmarshaling output: %w", err)
-		}
+	if res.Error != nil && res.Compensation == nil && res.Cancellation == nil {
+		return nil, res.Error
 	}
 
 	for k, v := range res.Outputs {
 		outputs[k] = v
 	}
 
+	// the job context may already be cancelled, outputs of a cancellation chain must still be readable
+	if err := loadOutputs(store, res.VariablesKey, outputs); err != nil {
+		return nil, err
+	}
 	output.Outputs = outputs
 
+	// the outputs of the compensation chains are kept apart from the outputs of the failed node
+	for c := output.Compensation; c != nil; c = c.Compensation {
+		c.Outputs = make(sparkv1.BindableMap)
+		if err := loadOutputs(store, c.VariablesKey, c.Outputs); err != nil {
+			return nil, err
+		}
+	}
+
+	// the outcome of the compensation or cancellation chain is returned along with the original error
+	if res.Error != nil {
+		return &Outputs{
+			ExecuteSparkOutput: output,
+		}, res.Error
+	}
+
 	return &Outputs{
 		ExecuteSparkOutput: output,
 	}, nil
 }
 
-func (r *runnerTest) startRequestConsumer(ctx *sparkv1.JobContext, js jetstream.JetStream, wf sparkv1.JobWorkflow) (string, error) {
-	subject := fmt.Sprintf("agent.v1.job.request.%s", ctx.Metadata.JobKeyValue)
-
-	_, err := js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
-		Name:      "AGENT_JOB_REQ",
-		Retention: jetstream.WorkQueuePolicy,
-		Subjects: []string{
-			subject,
-		},
-	})
-	if err != nil {
-		return "", err
+// loadOutputs reads the outputs stored under the key into outputs, streamed outputs are stored separately
+func loadOutputs(store sparkv1.VariableStore, key string, outputs sparkv1.BindableMap) error {
+	if key == "" {
+		return nil
 	}
 
-	consumer, err := js.CreateOrUpdateConsumer(context.Background(), "AGENT_JOB_REQ", jetstream.ConsumerConfig{
-		FilterSubject: subject,
-		AckPolicy:     jetstream.AckExplicitPolicy,
-		AckWait:       time.Second * 240,
-		MaxDeliver:    3,
-		MaxAckPending: 3,
-	})
-
+	ob, err := store.Get(context.Background(), key)
+	if errors.Is(err, sparkv1.ErrVariableStoreKeyNotFound) {
+		return nil
+	}
 	if err != nil {
-		return "", err
+		return fmt.Errorf("error reading output: %w", err)
 	}
 
-	go func() {
-	loop:
-		for {
-			select {
-			// on consumer stopped
-			case <-ctx.Done():
-				log.Info().Msgf("stopping consumer")
-				break loop
-			default:
-				batch, err := consumer.Fetch(15, jetstream.FetchMaxWait(time.Second*15))
-				if err != nil {
-					if errors.Is(err, nats.ErrConnectionClosed) {
-						break loop
-					}
-					continue
-				}
-
-				for msg := range batch.Messages() {
-					go func(m jetstream.Msg) {
-						wf.Run(m)
-					}(msg)
-				}
+	var values map[string]*sparkv1.BindableValue
+	if err := json.Unmarshal(ob, &values); err != nil {
+		return fmt.Errorf("error unmarshaling output: %w", err)
+	}
+	for k, v := range values {
+		if v != nil && v.Ref != "" {
+			if v.Value, err = store.Get(context.Background(), v.Ref); err != nil {
+				return fmt.Errorf("error reading output %s: %w", k, err)
 			}
 		}
-	}()
-
-	return subject, nil
+		outputs[k] = v
+	}
+	return nil
 }
 
-func (r *runnerTest) startResponseConsumer(ctx *sparkv1.JobContext, js jetstream.JetStream) (jetstream.Consumer, string, error) {
-	subject := fmt.Sprintf("agent.v1.job.a.b.%s.%s", "test", ctx.Metadata.JobKeyValue)
+// variableStore returns the store of the test options, an in-memory store is used by default so sparks are
+// tested without a NATS server
+func (r *runnerTest) variableStore() (sparkv1.VariableStore, error) {
+	if r.testOpts.store != nil {
+		return r.testOpts.store, nil
+	}
+	if !r.testOpts.nats {
+		return sparkv1.NewMemoryVariableStore(), nil
+	}
 
-	_, err := js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
-		Name:      "AGENT_JOB_RES",
-		Retention: jetstream.WorkQueuePolicy,
-		Subjects: []string{
-			subject,
-		},
-	})
+	tmpDir := r.t.TempDir()
+	log.Info().Msgf("tmp dir %s", tmpDir)
+
+	port, err := util.GetFreeTCPPort()
 	if err != nil {
-		return nil, "", err
+		return nil, fmt.Errorf("error getting free tcp port: %w", err)
 	}
 
-	consumer, err := js.CreateOrUpdateConsumer(ctx, "AGENT_JOB_RES", jetstream.ConsumerConfig{
-		FilterSubject: subject,
-	})
+	s, err := util.RunServerOnPort(port, tmpDir)
 	if err != nil {
-		return nil, "", err
+		return nil, fmt.Errorf("error running nats server: %w", err)
 	}
+	s.Start()
 
-	return consumer, subject, nil
+	nc, js := util.GetNatsClient(port)
+	r.t.Cleanup(func() {
+		nc.Close()
+		s.Shutdown()
+	})
+
+	objectStore, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
+		Bucket: "test",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating object store: %w", err)
+	}
+	return sparkv1.NewJetStreamVariableStore(objectStore), nil
 }
 
 func NewTestRunner(t *testing.T, spark sparkv1.Spark, options ...Option) (RunnerTest, error) {
@@ -323,3 +300,67 @@ func NewTestJobContext(ctx context.Context, jobKey, correlationId, transactionId
 		},
 	}
 }
+
+/************************************************************************/
+// JOB REQUEST MESSAGE
+/************************************************************************/
+
+// runnerMsg the job request the workflow runs, it records how the workflow handled it instead of talking to a
+// NATS server
+type runnerMsg struct {
+	subject    string
+	data       []byte
+	deliveries uint64
+	acked      bool
+}
+
+func (m *runnerMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.deliveries, Timestamp: time.Now()}, nil
+}
+
+func (m *runnerMsg) Data() []byte {
+	return m.data
+}
+
+func (m *runnerMsg) Headers() nats.Header {
+	return nats.Header{}
+}
+
+func (m *runnerMsg) Subject() string {
+	return m.subject
+}
+
+func (m *runnerMsg) Reply() string {
+	return ""
+}
+
+func (m *runnerMsg) Ack() error {
+	m.acked = true
+	return nil
+}
+
+func (m *runnerMsg) DoubleAck(_ context.Context) error {
+	return m.Ack()
+}
+
+func (m *runnerMsg) Nak() error {
+	return nil
+}
+
+// NakWithDelay the job is delivered again straight away, tests do not wait for the delay
+func (m *runnerMsg) NakWithDelay(_ time.Duration) error {
+	return nil
+}
+
+func (m *runnerMsg) InProgress() error {
+	return nil
+}
+
+func (m *runnerMsg) Term() error {
+	m.acked = true
+	return nil
+}
+
+func (m *runnerMsg) TermWithReason(_ string) error {
+	return m.Term()
+}
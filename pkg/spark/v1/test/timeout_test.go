@@ -0,0 +1,116 @@
+package module_test_runner
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/************************************************************************/
+// TYPES TIMEOUT SPARK
+/************************************************************************/
+
+type timeoutSpark struct {
+	// slowAttempts is the number of attempts that exceed the timeout before the stage returns in time
+	slowAttempts int32
+	retry        bool
+	timeout      time.Duration
+	attempts     int32
+}
+
+func (s *timeoutSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *timeoutSpark) Stop() {
+
+}
+
+func (s *timeoutSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("chain-1").
+		Stage("slow", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			if atomic.AddInt32(&s.attempts, 1) > s.slowAttempts {
+				return "done", nil
+			}
+
+			<-ctx.Done()
+			if s.retry {
+				return nil, sparkv1.NewStageError(ctx.Err(), sparkv1.WithRetry(2, 1, time.Millisecond*10))
+			}
+			return nil, sparkv1.NewStageError(ctx.Err())
+		}, sparkv1.WithStageTimeout(s.timeout)).
+		Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+			var out string
+			if err := ctx.StageResult("slow").Bind(&out); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			if err := ctx.Output(sparkv1.NewVar("out", codec.MimeTypeJson, out)); err != nil {
+				return sparkv1.NewStageError(err)
+			}
+			return nil
+		})
+}
+
+/************************************************************************/
+// TESTS
+/************************************************************************/
+
+func TestStageTimeout(t *testing.T) {
+	t.Run("should fail the stage with a timeout error code", func(t *testing.T) {
+		spark := &timeoutSpark{slowAttempts: 1, timeout: time.Millisecond * 50}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		_, err = worker.Execute(NewTestJobContext(context.Background(), "timeout", "cid", "tid", Inputs{}))
+		require.Error(t, err)
+
+		var se *sparkv1.ExecuteSparkError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, sparkv1.ErrorCodeTimeout, se.ErrorCode)
+		assert.Equal(t, "slow", se.StageName)
+		assert.Contains(t, se.ErrorMessage, sparkv1.ErrStageTimeout.Error())
+		worker.AssertStageFailed("slow")
+	})
+
+	t.Run("should retry a timed out stage", func(t *testing.T) {
+		spark := &timeoutSpark{slowAttempts: 1, retry: true, timeout: time.Millisecond * 50}
+		worker, err := NewTestRunner(t, spark)
+		require.NoError(t, err)
+
+		result, err := worker.Execute(NewTestJobContext(context.Background(), "timeout", "cid", "tid", Inputs{}))
+		require.NoError(t, err)
+
+		var out string
+		assert.NoError(t, result.Bind("out", &out))
+		assert.Equal(t, "done", out)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&spark.attempts))
+		worker.AssertStageCompleted("slow")
+	})
+}
+
+func TestJobDeadline(t *testing.T) {
+	// the stage has no timeout of its own so it only returns once the deadline of the job is exceeded
+	spark := &timeoutSpark{slowAttempts: 1}
+	worker, err := NewTestRunner(t, spark)
+	require.NoError(t, err)
+
+	ctx := NewTestJobContext(context.Background(), "deadline", "cid", "tid", Inputs{})
+	deadline := time.Now().Add(time.Second * 2)
+	ctx.Metadata.Deadline = &deadline
+
+	_, err = worker.Execute(ctx)
+	require.Error(t, err)
+
+	var se *sparkv1.ExecuteSparkError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, sparkv1.ErrorCodeCancelled, se.ErrorCode)
+	assert.Equal(t, sparkv1.ErrJobTimeout.Error(), se.ErrorMessage)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&spark.attempts))
+	worker.AssertStageCancelled("slow")
+}
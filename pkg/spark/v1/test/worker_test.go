@@ -2,6 +2,8 @@ package module_test_runner
 
 import (
 	"context"
+	"errors"
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 	sparkv1 "github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/suite"
@@ -88,6 +90,135 @@ func (s *slowSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
 		})
 }
 
+/************************************************************************/
+// TYPES COMPENSATING SPARK
+/************************************************************************/
+
+type compensatingSpark struct {
+	compensationError *sparkv1.ExecuteSparkError
+	previousResult    string
+	completeCalled    bool
+}
+
+func (s *compensatingSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *compensatingSpark) Stop() {
+
+}
+
+func (s *compensatingSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("test-0").
+		Stage("Stage-0", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return "created", nil
+		}).
+		Stage("Stage-1", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return nil, sparkv1.NewStageErrorWithCode("STAGE_1_FAILED", errors.New("stage 1 failed"))
+		}).
+		Compensate(
+			b.NewChain("test-0-compensate").
+				Stage("Stage-0-undo", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+					s.compensationError = ctx.CompensationError()
+					if err := ctx.StageResult("Stage-0").Bind(&s.previousResult); err != nil {
+						return nil, sparkv1.NewStageError(err)
+					}
+					return "undone", nil
+				}).
+				Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+					if err := ctx.Output(sparkv1.NewVar("compensated", codec.MimeTypeJson, true)); err != nil {
+						return sparkv1.NewStageError(err)
+					}
+					return nil
+				}),
+		).
+		Complete(func(_ sparkv1.CompleteContext) sparkv1.StageError {
+			s.completeCalled = true
+			return nil
+		})
+}
+
+/************************************************************************/
+// TYPES REVERSE COMPENSATING SPARK
+/************************************************************************/
+
+type reverseCompensatingSpark struct {
+	undone []string
+}
+
+func (s *reverseCompensatingSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *reverseCompensatingSpark) Stop() {
+
+}
+
+func (s *reverseCompensatingSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	done := func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+		return "done", nil
+	}
+	undo := func(name string) sparkv1.StageDefinitionFn {
+		return func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			s.undone = append(s.undone, name)
+			return "undone", nil
+		}
+	}
+
+	return b.NewChain("reverse").
+		Stage("Stage-1", done).
+		Stage("Stage-2", done).
+		Stage("Stage-3", func(_ sparkv1.StageContext) (any, sparkv1.StageError) {
+			return nil, sparkv1.NewStageError(errors.New("stage 3 failed"))
+		}).
+		Compensate(
+			b.NewChain("reverse-compensate").
+				Stage("Stage-1-undo", undo("Stage-1-undo")).
+				Stage("Stage-2-undo", undo("Stage-2-undo")).
+				Stage("Stage-3-undo", undo("Stage-3-undo")).
+				Complete(sparkv1.CompleteSuccess),
+		).
+		Complete(sparkv1.CompleteSuccess)
+}
+
+/************************************************************************/
+// TYPES CANCELLABLE SPARK
+/************************************************************************/
+
+type cancellableSpark struct {
+	cancelCtxErr error
+}
+
+func (s *cancellableSpark) Init(ctx sparkv1.InitContext) error {
+	return nil
+}
+
+func (s *cancellableSpark) Stop() {
+
+}
+
+func (s *cancellableSpark) BuildChain(b sparkv1.Builder) sparkv1.Chain {
+	return b.NewChain("test-0").
+		Stage("Stage-0", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+			<-ctx.Done()
+			return nil, nil
+		}).
+		Cancelled(
+			b.NewChain("test-0-cancel").
+				Stage("Stage-0-cancel", func(ctx sparkv1.StageContext) (any, sparkv1.StageError) {
+					s.cancelCtxErr = ctx.Err()
+					return "cleaned up", nil
+				}).
+				Complete(func(ctx sparkv1.CompleteContext) sparkv1.StageError {
+					if err := ctx.Output(sparkv1.NewVar("cancelled", codec.MimeTypeJson, true)); err != nil {
+						return sparkv1.NewStageError(err)
+					}
+					return nil
+				}),
+		).
+		Complete(sparkv1.CompleteSuccess)
+}
+
 /************************************************************************/
 // TESTS
 /************************************************************************/
@@ -107,6 +238,23 @@ func (s *WorkerSuite) Test_Should_Call_BuildChain_On_Registration() {
 	s.Require().Equal(int32(1), atomic.LoadInt32(&spark.completeCalledCount))
 }
 
+func (s *WorkerSuite) Test_Should_Run_With_A_Nats_Object_Store() {
+	jobKey := "run_with_nats"
+	spark := new(compensatingSpark)
+	worker, err := NewTestRunner(s.T(), spark, WithNatsServer())
+	s.Require().NoError(err)
+	ctx := NewTestJobContext(context.Background(), jobKey, "cid", "tid", Inputs{})
+
+	out, err := worker.ExecuteWithoutStageRetryOverride(ctx)
+	s.Require().ErrorContains(err, "stage 1 failed")
+	s.Require().NotNil(out.Compensation)
+
+	var compensated bool
+	s.Require().Contains(out.Compensation.Outputs, "compensated")
+	s.Require().NoError(out.Compensation.Outputs["compensated"].Bind(&compensated))
+	s.True(compensated)
+}
+
 func (s *WorkerSuite) Test_Should_Drain_Running_Stages_During_Shutdown_When_Context_Is_Cancelled() {
 	oc, cancel := context.WithCancel(context.Background())
 	jobKey := "drain_during_shutdown"
@@ -136,6 +284,92 @@ func (s *WorkerSuite) Test_Should_Drain_Running_Stages_During_Shutdown_When_Cont
 	s.Require().Equal(int32(0), atomic.LoadInt32(&spark.completeCalledCount), "completion should not be called")
 }
 
+func (s *WorkerSuite) Test_Should_Run_Compensate_Chain_When_Stage_Fails() {
+	jobKey := "compensate_on_failure"
+	spark := new(compensatingSpark)
+	worker := s.createWorker(spark)
+	ctx := NewTestJobContext(context.Background(), jobKey, "cid", "tid", Inputs{})
+
+	out, err := worker.ExecuteWithoutStageRetryOverride(ctx)
+	s.Require().ErrorContains(err, "stage 1 failed")
+	s.Require().NotNil(out)
+
+	// original failure is reported along with the compensation outcome
+	s.Require().NotNil(out.Error)
+	s.Equal(sparkv1.ErrorCode("STAGE_1_FAILED"), out.Error.ErrorCode)
+	s.Require().NotNil(out.Compensation)
+	s.Equal("test-0-compensate", out.Compensation.ChainName)
+	s.Nil(out.Compensation.Error)
+
+	// outputs of the compensation chain are kept apart from the outputs of the failed node
+	var compensated bool
+	s.Require().Contains(out.Compensation.Outputs, "compensated")
+	s.Require().NoError(out.Compensation.Outputs["compensated"].Bind(&compensated))
+	s.True(compensated)
+	s.ErrorIs(out.Bind("compensated", &compensated), ErrNoOutput)
+
+	// compensating stages have access to the failure and previous stage results
+	s.Require().NotNil(spark.compensationError)
+	s.Equal("stage 1 failed", spark.compensationError.ErrorMessage)
+	s.Equal("created", spark.previousResult)
+	s.False(spark.completeCalled, "completion of the failed node should not be called")
+
+	worker.AssertStageFailed("Stage-1")
+	worker.AssertStageCompleted("Stage-0-undo")
+	worker.AssertStageCompleted("test-0-compensate_complete")
+	worker.AssertStageOrder("Stage-0", "Stage-0-undo")
+}
+
+func (s *WorkerSuite) Test_Should_Compensate_Completed_Stages_In_Reverse_Order() {
+	jobKey := "compensate_in_reverse"
+	spark := new(reverseCompensatingSpark)
+	worker := s.createWorker(spark)
+	ctx := NewTestJobContext(context.Background(), jobKey, "cid", "tid", Inputs{})
+
+	out, err := worker.ExecuteWithoutStageRetryOverride(ctx)
+	s.Require().ErrorContains(err, "stage 3 failed")
+	s.Require().NotNil(out.Compensation)
+	s.Nil(out.Compensation.Error)
+
+	// only the completed stages are undone, newest first
+	s.Equal([]string{"Stage-2-undo", "Stage-1-undo"}, spark.undone)
+	worker.AssertStageFailed("Stage-3")
+	worker.AssertStageCompleted("reverse-compensate_complete")
+}
+
+func (s *WorkerSuite) Test_Should_Run_Cancel_Chain_When_Context_Is_Cancelled() {
+	oc, cancel := context.WithCancel(context.Background())
+	jobKey := "cancel_chain_on_cancel"
+	spark := new(cancellableSpark)
+	worker := s.createWorker(spark)
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+
+	out, err := worker.Execute(NewTestJobContext(oc, jobKey, "cid", "tid", Inputs{}))
+	s.Require().ErrorContains(err, "canceled")
+	s.Require().NotNil(out)
+
+	s.Equal(sparkv1.ErrorCodeCancelled, out.Error.ErrorCode)
+	s.Require().NotNil(out.Cancellation)
+	s.Equal("Stage-0", out.Cancellation.StageName)
+	s.Equal("test-0-cancel", out.Cancellation.ChainName)
+	s.Nil(out.Cancellation.Error)
+
+	var cancelled bool
+	s.Require().NoError(out.Bind("cancelled", &cancelled))
+	s.True(cancelled)
+
+	// the cancel chain runs with a fresh context
+	s.NoError(spark.cancelCtxErr)
+
+	worker.AssertStageCancelled("Stage-0")
+	worker.AssertStageCompleted("Stage-0-cancel")
+	worker.AssertStageCompleted("test-0-cancel_complete")
+}
+
 /************************************************************************/
 // HELPERS
 /************************************************************************/
@@ -0,0 +1,191 @@
+package sparkv1
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// Validator is implemented by values that check themselves once they are bound by InputAs, StageResultAs or a
+// TypedStage, a validation error fails the stage
+type Validator interface {
+	Validate() error
+}
+
+// TypedStageFn a stage that receives its variables bound to In, the value it returns is the result of the stage
+type TypedStageFn[In, Out any] func(ctx StageContext, in In) (Out, StageError)
+
+// inputLookup is implemented by stage contexts that can tell missing inputs apart from empty ones
+type inputLookup interface {
+	lookupInput(name string) (Input, error)
+}
+
+// typedField a field of the In struct of a TypedStage and the variable it is bound to
+type typedField struct {
+	index    int
+	name     string
+	result   bool
+	optional bool
+}
+
+/************************************************************************/
+// HELPERS
+/************************************************************************/
+
+// InputAs binds the input with the name to a value of T, it returns an error that wraps ErrVariableNotFound if
+// the job has no input with the name and ErrInvalidVariable if the value does not pass its Validator
+func InputAs[T any](ctx StageContext, name string) (T, error) {
+	var v T
+	in, err := lookupInput(ctx, name)
+	if err != nil {
+		return v, err
+	}
+	if err := bindAs(in, &v); err != nil {
+		return v, fmt.Errorf("unable to bind input (%s): %w", name, err)
+	}
+	return v, nil
+}
+
+// StageResultAs binds the result of the stage with the name to a value of T, it returns an error that wraps
+// ErrVariableNotFound if the stage has no result and ErrInvalidVariable if the value does not pass its Validator
+func StageResultAs[T any](ctx StageContext, name string) (T, error) {
+	var v T
+	if err := bindAs(ctx.StageResult(name), &v); err != nil {
+		return v, fmt.Errorf("unable to bind stage result (%s): %w", name, err)
+	}
+	return v, nil
+}
+
+func lookupInput(ctx StageContext, name string) (Input, error) {
+	if l, ok := ctx.(inputLookup); ok {
+		return l.lookupInput(name)
+	}
+	return ctx.Input(name), nil
+}
+
+// bindAs binds the value and validates it if it, or a pointer to it, implements Validator
+func bindAs(b Bindable, v any) error {
+	if err := b.Bind(v); err != nil {
+		return err
+	}
+	return validate(v)
+}
+
+func validate(v any) error {
+	validator, ok := v.(Validator)
+	if !ok {
+		// values bound to pointers of pointers, e.g. InputAs[*T], implement Validator on the element
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && !rv.IsNil() {
+			validator, ok = rv.Elem().Interface().(Validator)
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if rv := reflect.ValueOf(validator); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil
+	}
+
+	if err := validator.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidVariable, err)
+	}
+	return nil
+}
+
+/************************************************************************/
+// TYPED STAGE
+/************************************************************************/
+
+// TypedStage creates a StageDefinitionFn that binds the variables of the stage to the fields of In before it
+// calls fn, In must be a struct or a pointer to one. Fields with an `input:"name"` tag are bound to the input and
+// fields with a `result:"stage"` tag to the result of the stage, add `,optional` to the tag to keep the zero value
+// of the field when the variable is missing. Fields and In are validated once they are bound if they implement
+// Validator
+func TypedStage[In, Out any](fn TypedStageFn[In, Out]) StageDefinitionFn {
+	return func(ctx StageContext) (any, StageError) {
+		var in In
+		if err := bindTyped(ctx, &in); err != nil {
+			return nil, NewStageError(err)
+		}
+
+		out, serr := fn(ctx, in)
+		if serr != nil {
+			return nil, serr
+		}
+		return out, nil
+	}
+}
+
+func bindTyped(ctx StageContext, in any) error {
+	rv := reflect.ValueOf(in).Elem()
+	if rv.Kind() == reflect.Pointer {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("typed stage (%s) expects a struct of variables, got %s", ctx.Name(), rv.Type())
+	}
+
+	for _, f := range typedFields(rv.Type()) {
+		var (
+			b   Bindable
+			err error
+		)
+		if f.result {
+			b = ctx.StageResult(f.name)
+			_, err = b.GetValue()
+		} else {
+			b, err = lookupInput(ctx, f.name)
+		}
+
+		if err != nil {
+			if f.optional && errors.Is(err, ErrVariableNotFound) {
+				continue
+			}
+			return err
+		}
+
+		if err := bindAs(b, rv.Field(f.index).Addr().Interface()); err != nil {
+			return fmt.Errorf("unable to bind variable (%s): %w", f.name, err)
+		}
+	}
+
+	return validate(rv.Addr().Interface())
+}
+
+// typedFields returns the fields of the struct that have an input or result tag
+func typedFields(t reflect.Type) []typedField {
+	var fields []typedField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag, result := f.Tag.Lookup("result")
+		if !result {
+			var ok bool
+			if tag, ok = f.Tag.Lookup("input"); !ok {
+				continue
+			}
+		}
+
+		parts := strings.Split(tag, ",")
+		field := typedField{index: i, name: parts[0], result: result}
+		if field.name == "" {
+			field.name = f.Name
+		}
+		for _, opt := range parts[1:] {
+			if opt == "optional" {
+				field.optional = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
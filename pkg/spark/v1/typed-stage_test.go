@@ -0,0 +1,157 @@
+package sparkv1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type typedOrder struct {
+	Id     string `json:"id"`
+	Amount int    `json:"amount"`
+}
+
+func (o typedOrder) Validate() error {
+	if o.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	return nil
+}
+
+type typedStageInputs struct {
+	Order    typedOrder `input:"order"`
+	Customer string     `input:"customer"`
+	Note     string     `input:"note,optional"`
+	Previous string     `result:"previous"`
+	Skipped  string     `result:"skipped,optional"`
+	Ignored  string
+}
+
+func TestTypedStages(t *testing.T) {
+	ctx := context.Background()
+
+	newContext := func(t *testing.T, inputs ExecuteSparkInputs) StageContext {
+		io := newIoDataProvider(ctx, NewMemoryVariableStore())
+		io.SetInitialInputs(inputs)
+		_, err := io.PutStageResult("previous", []byte(`"done"`), string(codec.MimeTypeText))
+		require.NoError(t, err)
+		return NewStageContext(ctx, &ExecuteStageRequest{}, io, "typed", NewLogger(), nil)
+	}
+
+	inputs := func(order string) ExecuteSparkInputs {
+		return ExecuteSparkInputs{
+			"order":    {Value: []byte(order), MimeType: string(codec.MimeTypeJson)},
+			"customer": {Value: []byte(`"acme"`), MimeType: string(codec.MimeTypeText)},
+		}
+	}
+
+	t.Run("input as", func(t *testing.T) {
+		sc := newContext(t, inputs(`{"id":"1","amount":10}`))
+
+		order, err := InputAs[typedOrder](sc, "order")
+		require.NoError(t, err)
+		assert.Equal(t, typedOrder{Id: "1", Amount: 10}, order)
+
+		ptr, err := InputAs[*typedOrder](sc, "order")
+		require.NoError(t, err)
+		assert.Equal(t, &typedOrder{Id: "1", Amount: 10}, ptr)
+
+		customer, err := InputAs[string](sc, "customer")
+		require.NoError(t, err)
+		assert.Equal(t, "acme", customer)
+	})
+
+	t.Run("missing inputs", func(t *testing.T) {
+		sc := newContext(t, inputs(`{"id":"1","amount":10}`))
+
+		_, err := InputAs[string](sc, "missing")
+		assert.ErrorIs(t, err, ErrVariableNotFound)
+
+		// Input stays lenient for existing stages
+		var s string
+		assert.NoError(t, sc.Input("missing").Bind(&s))
+	})
+
+	t.Run("invalid inputs", func(t *testing.T) {
+		sc := newContext(t, inputs(`{"id":"1","amount":0}`))
+
+		_, err := InputAs[typedOrder](sc, "order")
+		assert.ErrorIs(t, err, ErrInvalidVariable)
+
+		_, err = InputAs[*typedOrder](sc, "order")
+		assert.ErrorIs(t, err, ErrInvalidVariable)
+
+		_, err = InputAs[int](sc, "customer")
+		assert.Error(t, err)
+	})
+
+	t.Run("stage result as", func(t *testing.T) {
+		sc := newContext(t, nil)
+
+		previous, err := StageResultAs[string](sc, "previous")
+		require.NoError(t, err)
+		assert.Equal(t, "done", previous)
+
+		_, err = StageResultAs[string](sc, "missing")
+		assert.ErrorIs(t, err, ErrVariableNotFound)
+	})
+
+	t.Run("typed stage", func(t *testing.T) {
+		var received typedStageInputs
+		stage := TypedStage(func(_ StageContext, in typedStageInputs) (string, StageError) {
+			received = in
+			return in.Order.Id, nil
+		})
+
+		out, serr := stage(newContext(t, inputs(`{"id":"1","amount":10}`)))
+		require.Nil(t, serr)
+		assert.Equal(t, "1", out)
+		assert.Equal(t, typedStageInputs{
+			Order:    typedOrder{Id: "1", Amount: 10},
+			Customer: "acme",
+			Previous: "done",
+		}, received)
+	})
+
+	t.Run("typed stage with pointer inputs", func(t *testing.T) {
+		stage := TypedStage(func(_ StageContext, in *typedStageInputs) (int, StageError) {
+			return in.Order.Amount, nil
+		})
+
+		out, serr := stage(newContext(t, inputs(`{"id":"1","amount":10}`)))
+		require.Nil(t, serr)
+		assert.Equal(t, 10, out)
+	})
+
+	t.Run("typed stage fails on missing and invalid inputs", func(t *testing.T) {
+		stage := TypedStage(func(_ StageContext, in typedStageInputs) (string, StageError) {
+			t.Fatal("stage must not run")
+			return "", nil
+		})
+
+		_, serr := stage(newContext(t, ExecuteSparkInputs{}))
+		assert.ErrorIs(t, serr, ErrVariableNotFound)
+
+		_, serr = stage(newContext(t, inputs(`{"id":"1","amount":0}`)))
+		assert.ErrorIs(t, serr, ErrInvalidVariable)
+	})
+
+	t.Run("typed stage errors", func(t *testing.T) {
+		stage := TypedStage(func(_ StageContext, _ typedStageInputs) (string, StageError) {
+			return "", NewStageError(errors.New("failed"))
+		})
+
+		out, serr := stage(newContext(t, inputs(`{"id":"1","amount":10}`)))
+		assert.Nil(t, out)
+		assert.EqualError(t, serr, "failed")
+
+		_, serr = TypedStage(func(_ StageContext, _ string) (string, StageError) {
+			return "", nil
+		})(newContext(t, nil))
+		assert.Error(t, serr)
+	})
+}
@@ -0,0 +1,195 @@
+package sparkv1
+
+import (
+	_ "embed"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	//go:embed testdata/validation/err_msg_name_stage1_not_unique
+	errMsgNameStage1NotUnique string
+	//go:embed testdata/validation/err_msg_no_stage_on_root
+	errMsgNoStagesOnRoot string
+	//go:embed testdata/validation/err_msg_inner_nodes_same_stage_name
+	errMsgInnerNodesSameStageName string
+	//go:embed testdata/validation/err_msg_empty_stage_name
+	errMsgEmptyStageName string
+	//go:embed testdata/validation/err_msg_no_complete_on_sub_chain
+	errMsgNoCompleteOnSubChain string
+	//go:embed testdata/validation/err_msg_complete_stage_name_not_unique
+	errMsgCompleteStageNameNotUnique string
+	//go:embed testdata/validation/err_msg_stage_reference_not_earlier
+	errMsgStageReferenceNotEarlier string
+	//go:embed testdata/validation/err_msg_compensation_more_stages
+	errMsgCompensationMoreStages string
+)
+
+func TestChainValidation(t *testing.T) {
+	tests := []struct {
+		name             string
+		chainFn          func() *SparkChain
+		expectedErrorMsg string
+	}{
+		{
+			name: "should return no validation errors",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Parallel("group", ParallelModeFailFast,
+						NewStage("stage2", noOpStage, WithSkipOnMissingStageResult("stage1")),
+						NewStage("stage3", noOpStage),
+					).
+					Branch("route", noOpSelector, map[string]Chain{
+						"a": b.NewChain("route-a").Stage("route-a-stage", noOpStage,
+							WithStageStatus("stage3", StageStatus_STAGE_COMPLETED)).Complete(CompleteSuccess),
+					}).
+					Stage("stage4", noOpStage, WithSkipOnMissingStageResult("route-a-stage")).
+					Compensate(b.NewChain("compensate").Stage("compensate", noOpStage,
+						WithSkipOnMissingStageResult("stage4")).Complete(CompleteSuccess)).
+					Cancelled(b.NewChain("canceled").Stage("canceled", noOpStage).Complete(CompleteSuccess)).
+					Complete(CompleteSuccess, WithSkipOnStageStatus("stage4", StageStatus_STAGE_FAILED))
+				return b.BuildChain()
+			},
+		},
+		{
+			name: "should return validation error: same name used in two stages",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Stage("stage1", noOpStage).
+					Stage("stage3", noOpStage).
+					Compensate(b.NewChain("compensate").Stage("compensate", noOpStage).Complete(CompleteSuccess)).
+					Cancelled(b.NewChain("canceled").Stage("canceled", noOpStage).Complete(CompleteSuccess)).
+					Complete(CompleteSuccess)
+				return b.BuildChain()
+			},
+			expectedErrorMsg: errMsgNameStage1NotUnique,
+		},
+		{
+			name: "should return validation error: node without stages",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Compensate(b.NewChain("compensate").Stage("compensate", noOpStage).Complete(CompleteSuccess)).
+					Cancelled(b.NewChain("canceled").Stage("canceled", noOpStage).Complete(CompleteSuccess)).
+					Complete(CompleteSuccess)
+				chain := b.BuildChain()
+				chain.RootNode.Stages = nil
+				return chain
+			},
+			expectedErrorMsg: errMsgNoStagesOnRoot,
+		},
+		{
+			name: "should return validation error: multiple inner stages with the same name",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Cancelled(b.NewChain("canceled-1").Stage("canceled", noOpStage).
+						Cancelled(b.NewChain("canceled-2").Stage("canceled", noOpStage).
+							Cancelled(b.NewChain("canceled-3").Stage("canceled", noOpStage).
+								Complete(CompleteSuccess)).
+							Complete(CompleteSuccess)).
+						Complete(CompleteSuccess)).
+					Complete(CompleteSuccess)
+				return b.BuildChain()
+			},
+			expectedErrorMsg: errMsgInnerNodesSameStageName,
+		},
+		{
+			name: "should return validation error: stage with empty name",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Stage("", noOpStage).
+					Stage("stage3", noOpStage).
+					Complete(CompleteSuccess)
+				return b.BuildChain()
+			},
+			expectedErrorMsg: errMsgEmptyStageName,
+		},
+		{
+			name: "should return validation error: sub chain without complete stage",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Compensate(b.NewChain("compensate").Stage("compensate", noOpStage).Complete(CompleteSuccess)).
+					Complete(CompleteSuccess)
+				chain := b.BuildChain()
+				chain.RootNode.Compensate.Complete = nil
+				return chain
+			},
+			expectedErrorMsg: errMsgNoCompleteOnSubChain,
+		},
+		{
+			name: "should return validation error: stage uses the name of a complete stage",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Compensate(b.NewChain("compensate").Stage("main_complete", noOpStage).Complete(CompleteSuccess)).
+					Complete(CompleteSuccess)
+				return b.BuildChain()
+			},
+			expectedErrorMsg: errMsgCompleteStageNameNotUnique,
+		},
+		{
+			name: "should return validation error: stage references a stage that does not run before it",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage, WithSkipOnMissingStageResult("stage3")).
+					Parallel("group", ParallelModeFailFast,
+						NewStage("stage2", noOpStage, WithStageStatus("stage3", StageStatus_STAGE_COMPLETED)),
+						NewStage("stage3", noOpStage),
+					).
+					Branch("route", noOpSelector, map[string]Chain{
+						"a": b.NewChain("route-a").Stage("route-a-stage", noOpStage,
+							WithSkipOnMissingStageResult("route")).Complete(CompleteSuccess),
+					}).
+					Cancelled(b.NewChain("canceled").Stage("canceled", noOpStage,
+						WithSkipOnMissingStageResult("main_complete")).Complete(CompleteSuccess)).
+					Complete(CompleteSuccess, WithSkipOnMissingStageResult("unknown"))
+				return b.BuildChain()
+			},
+			expectedErrorMsg: errMsgStageReferenceNotEarlier,
+		},
+		{
+			name: "should return validation error: compensation chain with more stages than its node",
+			chainFn: func() *SparkChain {
+				b := NewBuilder()
+				b.NewChain("main").
+					Stage("stage1", noOpStage).
+					Compensate(b.NewChain("compensate").
+						Stage("undo1", noOpStage).
+						Stage("undo2", noOpStage).
+						Complete(CompleteSuccess)).
+					Complete(CompleteSuccess)
+				return b.BuildChain()
+			},
+			expectedErrorMsg: errMsgCompensationMoreStages,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := generateReportForChain(test.chainFn())
+
+			var errs []string
+			for _, err := range r.Errors {
+				errs = append(errs, err.Error())
+			}
+			assert.Equal(t, strings.TrimSpace(test.expectedErrorMsg), strings.Join(errs, "\n"))
+		})
+	}
+}
+
+var noOpStage = func(_ StageContext) (any, StageError) { return nil, nil }
+var noOpSelector = func(_ StageContext) (string, StageError) { return "a", nil }
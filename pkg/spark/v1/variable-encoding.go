@@ -0,0 +1,302 @@
+package sparkv1
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// ContentEncoding the compression applied to values before they are written to the variable store
+type ContentEncoding string
+
+const (
+	// ContentEncodingNone values are stored as they are
+	ContentEncodingNone ContentEncoding = ""
+	ContentEncodingGzip ContentEncoding = "gzip"
+	ContentEncodingZstd ContentEncoding = "zstd"
+)
+
+const (
+	// contentEncodingHeader the header that holds the encoding of a value, values without it are stored as they are
+	contentEncodingHeader = "Content-Encoding"
+	// encryptionKeyIdHeader the header that holds the id of the key that sealed the data key of an encrypted value
+	encryptionKeyIdHeader = "Spark-Encryption-Key-Id"
+	// encryptionDataKeyHeader the header that holds the base64 encoded sealed data key of an encrypted value
+	encryptionDataKeyHeader = "Spark-Encryption-Data-Key"
+	// encryptionNonceHeader the header that holds the base64 encoded nonce prefix of an encrypted value
+	encryptionNonceHeader = "Spark-Encryption-Nonce"
+)
+
+var (
+	ErrUnknownContentEncoding      = errors.New("unknown content encoding")
+	ErrInvalidValueHeader          = errors.New("invalid value header")
+	ErrVariableHeadersNotSupported = errors.New("variable store does not support headers")
+)
+
+// valueHeader describes how a value was encoded, it is kept in the headers of the value
+type valueHeader struct {
+	Encoding ContentEncoding
+	// KeyId the id of the key that encrypted DataKey, values without it are not encrypted
+	KeyId string
+	// DataKey the key the value is encrypted with, sealed with the key of KeyId
+	DataKey []byte
+	// Nonce the random prefix of the nonces of the encrypted chunks
+	Nonce []byte
+}
+
+// encodedVariableStore compresses values that are at least threshold bytes large and encrypts all values if it
+// has keys, the encoding of a value is read from its headers so values written with any encoding are decoded
+type encodedVariableStore struct {
+	store     HeaderVariableStore
+	encoding  ContentEncoding
+	threshold int
+	keys      KeyProvider
+}
+
+// encodedReader closes the decoder and the reader of the underlying store
+type encodedReader struct {
+	io.Reader
+	closers []func() error
+}
+
+/************************************************************************/
+// STORE
+/************************************************************************/
+
+func (s *encodedVariableStore) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.GetReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func (s *encodedVariableStore) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, headers, err := s.store.GetReaderWithHeaders(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	r, closeDecoder, err := decodeValue(ctx, s.keys, headers, bufio.NewReader(rc))
+	if err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("unable to decode %s: %w", key, err)
+	}
+	return &encodedReader{Reader: r, closers: []func() error{closeDecoder, rc.Close}}, nil
+}
+
+func (s *encodedVariableStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.PutReader(ctx, key, bytes.NewReader(value))
+}
+
+// PutReader only reads ahead up to the threshold to decide if the value is compressed, larger values are
+// compressed and encrypted while they are written to the underlying store
+func (s *encodedVariableStore) PutReader(ctx context.Context, key string, r io.Reader) error {
+	head := make([]byte, s.threshold)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	head = head[:n]
+
+	header := &valueHeader{Encoding: s.encoding}
+	if n < s.threshold {
+		header.Encoding = ContentEncodingNone
+	}
+
+	if header.Encoding == ContentEncodingNone && s.keys == nil {
+		return s.store.PutReader(ctx, key, io.MultiReader(bytes.NewReader(head), r))
+	}
+
+	var dataKey []byte
+	if s.keys != nil {
+		if dataKey, err = sealDataKey(ctx, s.keys, header); err != nil {
+			return fmt.Errorf("unable to encrypt %s: %w", key, err)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(encodeValue(pw, header, dataKey, io.MultiReader(bytes.NewReader(head), r)))
+	}()
+
+	err = s.store.PutReaderWithHeaders(ctx, key, pr, header.headers())
+	_ = pr.CloseWithError(err)
+	<-done
+	return err
+}
+
+func (s *encodedVariableStore) Delete(ctx context.Context, key string) error {
+	return s.store.Delete(ctx, key)
+}
+
+/************************************************************************/
+// ENCODING
+/************************************************************************/
+
+// headers returns the headers the value is stored with
+func (h *valueHeader) headers() VariableHeaders {
+	headers := VariableHeaders{}
+	if h.Encoding != ContentEncodingNone {
+		headers[contentEncodingHeader] = string(h.Encoding)
+	}
+	if h.KeyId != "" {
+		headers[encryptionKeyIdHeader] = h.KeyId
+		headers[encryptionDataKeyHeader] = base64.StdEncoding.EncodeToString(h.DataKey)
+		headers[encryptionNonceHeader] = base64.StdEncoding.EncodeToString(h.Nonce)
+	}
+	return headers
+}
+
+// parseValueHeader reads the valueHeader from the headers of a value
+func parseValueHeader(headers VariableHeaders) (*valueHeader, error) {
+	header := &valueHeader{
+		Encoding: ContentEncoding(headers[contentEncodingHeader]),
+		KeyId:    headers[encryptionKeyIdHeader],
+	}
+	if header.KeyId == "" {
+		return header, nil
+	}
+
+	var err error
+	if header.DataKey, err = base64.StdEncoding.DecodeString(headers[encryptionDataKeyHeader]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidValueHeader, err)
+	}
+	if header.Nonce, err = base64.StdEncoding.DecodeString(headers[encryptionNonceHeader]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidValueHeader, err)
+	}
+	return header, nil
+}
+
+// encodeValue writes the encoded value to w, the value is compressed before it is encrypted with the data key
+func encodeValue(w io.Writer, header *valueHeader, dataKey []byte, r io.Reader) error {
+	var cw io.WriteCloser = nopWriteCloser{w}
+	if dataKey != nil {
+		var err error
+		if cw, err = newEncryptWriter(w, dataKey, header.Nonce); err != nil {
+			return err
+		}
+	}
+
+	ew, err := newEncoder(cw, header.Encoding)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ew, r); err != nil {
+		_ = ew.Close()
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// decodeValue returns a reader for the value decoded as its headers describe, values without headers are
+// returned as they are and encrypted values are decrypted with the keys
+func decodeValue(ctx context.Context, keys KeyProvider, headers VariableHeaders, r *bufio.Reader) (io.Reader, func() error, error) {
+	noop := func() error { return nil }
+
+	header, err := parseValueHeader(headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.KeyId != "" {
+		dataKey, err := openDataKey(ctx, keys, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		dr, err := newDecryptReader(r, dataKey, header.Nonce)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = bufio.NewReader(dr)
+	}
+
+	switch header.Encoding {
+	case ContentEncodingNone:
+		return r, noop, nil
+	case ContentEncodingGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, gr.Close, nil
+	case ContentEncodingZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, func() error { zr.Close(); return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownContentEncoding, header.Encoding)
+	}
+}
+
+func newEncoder(w io.Writer, encoding ContentEncoding) (io.WriteCloser, error) {
+	switch encoding {
+	case ContentEncodingNone:
+		return nopWriteCloser{w}, nil
+	case ContentEncodingGzip:
+		return gzip.NewWriter(w), nil
+	case ContentEncodingZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentEncoding, encoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (r *encodedReader) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		errs = append(errs, c())
+	}
+	return errors.Join(errs...)
+}
+
+/************************************************************************/
+// FACTORY
+/************************************************************************/
+
+// NewEncodedVariableStore wraps a HeaderVariableStore so values of at least threshold bytes are compressed with the
+// encoding and all values are encrypted with the keys unless they are nil, values written with any encoding are
+// decoded when they are read, stores without headers are returned as they are if there is nothing to encode
+func NewEncodedVariableStore(store VariableStore, encoding ContentEncoding, threshold int, keys KeyProvider) (VariableStore, error) {
+	switch encoding {
+	case ContentEncodingNone, ContentEncodingGzip, ContentEncodingZstd:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentEncoding, encoding)
+	}
+
+	hs, ok := store.(HeaderVariableStore)
+	if !ok {
+		if encoding == ContentEncodingNone && keys == nil {
+			return store, nil
+		}
+		return nil, ErrVariableHeadersNotSupported
+	}
+	return &encodedVariableStore{store: hs, encoding: encoding, threshold: threshold, keys: keys}, nil
+}
@@ -0,0 +1,127 @@
+package sparkv1
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodedVariableStore(t *testing.T) {
+	ctx := context.Background()
+	large := []byte(strings.Repeat(`{"name":"value"},`, 1000))
+	small := []byte(`"small"`)
+
+	for _, encoding := range []ContentEncoding{ContentEncodingNone, ContentEncodingGzip, ContentEncodingZstd} {
+		t.Run("encoding "+string(encoding), func(t *testing.T) {
+			inner := NewMemoryVariableStore()
+			store, err := NewEncodedVariableStore(inner, encoding, 100, nil)
+			require.NoError(t, err)
+
+			t.Run("small values are stored as they are", func(t *testing.T) {
+				require.NoError(t, store.Put(ctx, "small", small))
+
+				raw, err := inner.Get(ctx, "small")
+				require.NoError(t, err)
+				assert.Equal(t, small, raw)
+
+				b, err := store.Get(ctx, "small")
+				require.NoError(t, err)
+				assert.Equal(t, small, b)
+			})
+
+			t.Run("large values are encoded", func(t *testing.T) {
+				require.NoError(t, store.Put(ctx, "large", large))
+
+				r, headers, err := inner.(HeaderVariableStore).GetReaderWithHeaders(ctx, "large")
+				require.NoError(t, err)
+				raw, err := io.ReadAll(r)
+				require.NoError(t, err)
+				if encoding == ContentEncodingNone {
+					assert.Equal(t, large, raw)
+					assert.Empty(t, headers)
+				} else {
+					assert.Equal(t, VariableHeaders{"Content-Encoding": string(encoding)}, headers)
+					assert.Less(t, len(raw), len(large))
+				}
+
+				b, err := store.Get(ctx, "large")
+				require.NoError(t, err)
+				assert.Equal(t, large, b)
+			})
+
+			t.Run("large values are encoded while streaming", func(t *testing.T) {
+				require.NoError(t, store.PutReader(ctx, "stream", bytes.NewReader(large)))
+
+				r, err := store.GetReader(ctx, "stream")
+				require.NoError(t, err)
+				b, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				assert.Equal(t, large, b)
+			})
+
+			t.Run("values written without headers are read as they are", func(t *testing.T) {
+				value := []byte("\x1f\x8b\x08raw")
+				require.NoError(t, inner.Put(ctx, "plain", value))
+
+				b, err := store.Get(ctx, "plain")
+				require.NoError(t, err)
+				assert.Equal(t, value, b)
+			})
+
+			t.Run("missing keys", func(t *testing.T) {
+				_, err := store.Get(ctx, "missing")
+				assert.ErrorIs(t, err, ErrVariableStoreKeyNotFound)
+			})
+		})
+	}
+
+	t.Run("values are decoded regardless of the encoding of the reader", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		writer, err := NewEncodedVariableStore(inner, ContentEncodingGzip, 0, nil)
+		require.NoError(t, err)
+		reader, err := NewEncodedVariableStore(inner, ContentEncodingNone, 0, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, writer.Put(ctx, "key", large))
+		b, err := reader.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, large, b)
+	})
+
+	t.Run("encoded values are plain streams of the encoding", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		store, err := NewEncodedVariableStore(inner, ContentEncodingGzip, 0, nil)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(ctx, "key", large))
+
+		raw, err := inner.GetReader(ctx, "key")
+		require.NoError(t, err)
+		gr, err := gzip.NewReader(raw)
+		require.NoError(t, err)
+		b, err := io.ReadAll(gr)
+		require.NoError(t, err)
+		assert.Equal(t, large, b)
+	})
+
+	t.Run("stores without headers are only used without an encoding", func(t *testing.T) {
+		inner := struct{ VariableStore }{NewMemoryVariableStore()}
+		store, err := NewEncodedVariableStore(inner, ContentEncodingNone, 0, nil)
+		require.NoError(t, err)
+		assert.Equal(t, inner, store)
+
+		_, err = NewEncodedVariableStore(inner, ContentEncodingGzip, 0, nil)
+		assert.ErrorIs(t, err, ErrVariableHeadersNotSupported)
+	})
+
+	t.Run("unknown encodings are rejected", func(t *testing.T) {
+		_, err := NewEncodedVariableStore(NewMemoryVariableStore(), "brotli", 0, nil)
+		assert.ErrorIs(t, err, ErrUnknownContentEncoding)
+	})
+}
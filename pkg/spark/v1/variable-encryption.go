@@ -0,0 +1,263 @@
+package sparkv1
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+var (
+	ErrEncryptionKeyNotFound = errors.New("encryption key not found")
+	ErrInvalidEncryptionKey  = errors.New("encryption keys must be 16, 24 or 32 bytes long")
+	ErrDecryptionFailed      = errors.New("unable to decrypt value")
+)
+
+const (
+	// dataKeySize every value is encrypted with its own AES-256 key
+	dataKeySize = 32
+	// encryptedChunkSize values are encrypted in chunks so they can be streamed, every chunk is authenticated
+	encryptedChunkSize = 64 * 1024
+	// noncePrefixSize the random part of the nonce of a chunk, the rest is the chunk counter and the last chunk flag
+	noncePrefixSize = 7
+)
+
+// staticKeyProvider provides keys that are known up front, e.g. from the config
+type staticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// encryptWriter encrypts the chunks written to it, the last chunk is flagged so truncated values are detected
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// decryptReader decrypts the chunks written by an encryptWriter
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+/************************************************************************/
+// KEY PROVIDER
+/************************************************************************/
+
+func (p *staticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := p.Key(ctx, p.current)
+	return p.current, key, err
+}
+
+func (p *staticKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEncryptionKeyNotFound, id)
+	}
+	return key, nil
+}
+
+/************************************************************************/
+// ENVELOPE
+/************************************************************************/
+
+// sealDataKey creates a random data key for a value and encrypts it with the current key of the provider, the
+// envelope is kept in the headers of the value and is used to open it again
+func sealDataKey(ctx context.Context, keys KeyProvider, header *valueHeader) ([]byte, error) {
+	id, key, err := keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	nonce := make([]byte, aead.NonceSize())
+	prefix := make([]byte, noncePrefixSize)
+	for _, b := range [][]byte{dataKey, nonce, prefix} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+
+	header.KeyId = id
+	header.DataKey = aead.Seal(nonce, nonce, dataKey, []byte(id))
+	header.Nonce = prefix
+	return dataKey, nil
+}
+
+// openDataKey decrypts the data key of the value with the key the header references
+func openDataKey(ctx context.Context, keys KeyProvider, header *valueHeader) ([]byte, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("%w: %s", ErrEncryptionKeyNotFound, header.KeyId)
+	}
+
+	key, err := keys.Key(ctx, header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header.DataKey) < aead.NonceSize() || len(header.Nonce) != noncePrefixSize {
+		return nil, ErrInvalidValueHeader
+	}
+	nonce, sealed := header.DataKey[:aead.NonceSize()], header.DataKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(header.KeyId))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	return dataKey, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEncryptionKey, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce the nonce of a chunk is the random prefix of the value, the counter of the chunk and a flag for the
+// last chunk so chunks can not be reordered, dropped or appended
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := binary.BigEndian.AppendUint32(append([]byte{}, prefix...), counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+/************************************************************************/
+// STREAMS
+/************************************************************************/
+
+func newEncryptWriter(w io.Writer, dataKey, prefix []byte) (*encryptWriter, error) {
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encryptedChunkSize)}, nil
+}
+
+// Write only seals a chunk once more data follows it, the remainder is sealed as the last chunk on Close
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == encryptedChunkSize {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		n := min(encryptedChunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("value is too large to encrypt")
+	}
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func newDecryptReader(r *bufio.Reader, dataKey, prefix []byte) (*decryptReader, error) {
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, prefix: prefix, chunk: make([]byte, encryptedChunkSize+aead.Overhead())}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open decrypts the next chunk, a chunk is the last one if it is short or nothing follows it
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := errors.Is(err, io.ErrUnexpectedEOF)
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: value is truncated", ErrDecryptionFailed)
+	case err != nil && !last:
+		return err
+	case !last:
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	buf, err := d.aead.Open(d.chunk[:0], chunkNonce(d.prefix, d.counter, last), d.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	d.counter++
+	d.buf = buf
+	d.done = last
+	return nil
+}
+
+/************************************************************************/
+// FACTORY
+/************************************************************************/
+
+// NewStaticKeyProvider creates a KeyProvider for the keys by their id, new values are encrypted with the key of
+// currentKeyId, the other keys are only used to read values that were encrypted before the key was rotated
+func NewStaticKeyProvider(currentKeyId string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[currentKeyId]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEncryptionKeyNotFound, currentKeyId)
+	}
+	for id, key := range keys {
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEncryptionKey, id)
+		}
+	}
+	return &staticKeyProvider{current: currentKeyId, keys: keys}, nil
+}
@@ -0,0 +1,172 @@
+package sparkv1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedVariableStore(t *testing.T) {
+	ctx := context.Background()
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 16)
+	small := []byte(`"small"`)
+	large := []byte(strings.Repeat(`{"name":"value"},`, 10000))
+
+	newStore := func(t *testing.T, inner VariableStore, encoding ContentEncoding, current string) VariableStore {
+		keys, err := NewStaticKeyProvider(current, map[string][]byte{"key1": key1, "key2": key2})
+		require.NoError(t, err)
+		store, err := NewEncodedVariableStore(inner, encoding, 100, keys)
+		require.NoError(t, err)
+		return store
+	}
+
+	for _, encoding := range []ContentEncoding{ContentEncodingNone, ContentEncodingGzip, ContentEncodingZstd} {
+		t.Run("encoding "+string(encoding), func(t *testing.T) {
+			inner := NewMemoryVariableStore()
+			store := newStore(t, inner, encoding, "key1")
+
+			for name, value := range map[string][]byte{"small": small, "large": large, "empty": {}} {
+				t.Run(name+" values are encrypted", func(t *testing.T) {
+					require.NoError(t, store.Put(ctx, name, value))
+
+					r, headers, err := inner.(HeaderVariableStore).GetReaderWithHeaders(ctx, name)
+					require.NoError(t, err)
+					raw, err := io.ReadAll(r)
+					require.NoError(t, err)
+					assert.Equal(t, "key1", headers[encryptionKeyIdHeader])
+					assert.NotEmpty(t, headers[encryptionDataKeyHeader])
+					assert.NotEmpty(t, headers[encryptionNonceHeader])
+					if len(value) > 0 {
+						assert.NotContains(t, string(raw), string(value))
+					}
+
+					b, err := store.Get(ctx, name)
+					require.NoError(t, err)
+					assert.Equal(t, value, b)
+				})
+			}
+
+			t.Run("values are decrypted while streaming", func(t *testing.T) {
+				require.NoError(t, store.PutReader(ctx, "stream", bytes.NewReader(large)))
+
+				r, err := store.GetReader(ctx, "stream")
+				require.NoError(t, err)
+				b, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				assert.Equal(t, large, b)
+			})
+		})
+	}
+
+	t.Run("values of whole chunks are decrypted", func(t *testing.T) {
+		store := newStore(t, NewMemoryVariableStore(), ContentEncodingNone, "key1")
+		value := bytes.Repeat([]byte{'a'}, encryptedChunkSize*2)
+
+		require.NoError(t, store.Put(ctx, "key", value))
+		b, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, value, b)
+	})
+
+	t.Run("values encrypted with rotated keys are decrypted", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		require.NoError(t, newStore(t, inner, ContentEncodingNone, "key1").Put(ctx, "old", large))
+
+		rotated := newStore(t, inner, ContentEncodingNone, "key2")
+		require.NoError(t, rotated.Put(ctx, "new", large))
+
+		for _, key := range []string{"old", "new"} {
+			b, err := rotated.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, large, b)
+		}
+	})
+
+	t.Run("unencrypted values are read", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		plain, err := NewEncodedVariableStore(inner, ContentEncodingGzip, 0, nil)
+		require.NoError(t, err)
+		require.NoError(t, plain.Put(ctx, "key", large))
+
+		b, err := newStore(t, inner, ContentEncodingNone, "key1").Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, large, b)
+	})
+
+	t.Run("values can not be read without their key", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		require.NoError(t, newStore(t, inner, ContentEncodingNone, "key1").Put(ctx, "key", small))
+
+		plain, err := NewEncodedVariableStore(inner, ContentEncodingNone, 0, nil)
+		require.NoError(t, err)
+		_, err = plain.Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrEncryptionKeyNotFound)
+
+		other, err := NewStaticKeyProvider("key1", map[string][]byte{"key1": key2})
+		require.NoError(t, err)
+		wrong, err := NewEncodedVariableStore(inner, ContentEncodingNone, 0, other)
+		require.NoError(t, err)
+		_, err = wrong.Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("tampered values are rejected", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		store := newStore(t, inner, ContentEncodingNone, "key1")
+		require.NoError(t, store.Put(ctx, "key", large))
+
+		r, headers, err := inner.(HeaderVariableStore).GetReaderWithHeaders(ctx, "key")
+		require.NoError(t, err)
+		raw, err := io.ReadAll(r)
+		require.NoError(t, err)
+		put := func(key string, value []byte) {
+			require.NoError(t, inner.(HeaderVariableStore).PutReaderWithHeaders(ctx, key, bytes.NewReader(value), headers))
+		}
+
+		tampered := append([]byte{}, raw...)
+		tampered[len(tampered)-1] ^= 1
+		put("tampered", tampered)
+		_, err = store.Get(ctx, "tampered")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+		// drop the last chunk so the value ends on a chunk that is not flagged as the last one
+		put("truncated", raw[:2*(encryptedChunkSize+16)])
+		_, err = store.Get(ctx, "truncated")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("values with a tampered envelope are rejected", func(t *testing.T) {
+		inner := NewMemoryVariableStore()
+		require.NoError(t, newStore(t, inner, ContentEncodingNone, "key1").Put(ctx, "key", small))
+
+		r, headers, err := inner.(HeaderVariableStore).GetReaderWithHeaders(ctx, "key")
+		require.NoError(t, err)
+		raw, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		headers[encryptionKeyIdHeader] = "key2"
+		require.NoError(t, inner.(HeaderVariableStore).PutReaderWithHeaders(ctx, "key", bytes.NewReader(raw), headers))
+		_, err = newStore(t, inner, ContentEncodingNone, "key1").Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+		headers[encryptionDataKeyHeader] = "not base64"
+		require.NoError(t, inner.(HeaderVariableStore).PutReaderWithHeaders(ctx, "key", bytes.NewReader(raw), headers))
+		_, err = newStore(t, inner, ContentEncodingNone, "key1").Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrInvalidValueHeader)
+	})
+
+	t.Run("invalid keys are rejected", func(t *testing.T) {
+		_, err := NewStaticKeyProvider("missing", map[string][]byte{"key1": key1})
+		assert.ErrorIs(t, err, ErrEncryptionKeyNotFound)
+
+		_, err = NewStaticKeyProvider("key1", map[string][]byte{"key1": []byte("short")})
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+	})
+}
@@ -0,0 +1,397 @@
+package sparkv1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+/************************************************************************/
+// TYPES
+/************************************************************************/
+
+// VariableStoreType selects the VariableStore implementation a spark uses
+type VariableStoreType string
+
+const (
+	// VariableStoreTypeJetStream stores variables in the Config.NatsBucket object store, this is the default
+	VariableStoreTypeJetStream VariableStoreType = "jetstream"
+	// VariableStoreTypeFile stores variables as files in a local directory
+	VariableStoreTypeFile VariableStoreType = "file"
+	// VariableStoreTypeMemory keeps variables in memory, they are lost when the spark stops
+	VariableStoreTypeMemory VariableStoreType = "memory"
+)
+
+// fileHeadersDir the directory of the file store the headers of the values are kept in, one json file per key
+const fileHeadersDir = ".headers"
+
+var (
+	ErrVariableStoreKeyNotFound = errors.New("key not found in variable store")
+	ErrInvalidVariableStoreKey  = errors.New("invalid variable store key")
+	ErrUnknownVariableStoreType = errors.New("unknown variable store type")
+	ErrMissingVariableStorePath = errors.New("variable store path is required")
+)
+
+type jetStreamVariableStore struct {
+	store jetstream.ObjectStore
+}
+
+type fileVariableStore struct {
+	dir string
+}
+
+type memoryVariableStore struct {
+	mu     sync.RWMutex
+	values map[string]memoryValue
+}
+
+type memoryValue struct {
+	value   []byte
+	headers VariableHeaders
+}
+
+/************************************************************************/
+// JETSTREAM
+/************************************************************************/
+
+func (s *jetStreamVariableStore) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := s.store.GetBytes(ctx, key)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrVariableStoreKeyNotFound, key)
+	}
+	return b, err
+}
+
+func (s *jetStreamVariableStore) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, _, err := s.GetReaderWithHeaders(ctx, key)
+	return r, err
+}
+
+// GetReaderWithHeaders returns the headers of the object meta along with the value
+func (s *jetStreamVariableStore) GetReaderWithHeaders(ctx context.Context, key string) (io.ReadCloser, VariableHeaders, error) {
+	r, err := s.store.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrVariableStoreKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := r.Info()
+	if err != nil {
+		_ = r.Close()
+		return nil, nil, err
+	}
+
+	var headers VariableHeaders
+	for k := range info.Headers {
+		if headers == nil {
+			headers = make(VariableHeaders)
+		}
+		headers[k] = info.Headers.Get(k)
+	}
+	return r, headers, nil
+}
+
+func (s *jetStreamVariableStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.store.PutBytes(ctx, key, value)
+	return err
+}
+
+// PutReader writes the value in chunks of the object store
+func (s *jetStreamVariableStore) PutReader(ctx context.Context, key string, r io.Reader) error {
+	return s.PutReaderWithHeaders(ctx, key, r, nil)
+}
+
+// PutReaderWithHeaders keeps the headers in the object meta
+func (s *jetStreamVariableStore) PutReaderWithHeaders(ctx context.Context, key string, r io.Reader, headers VariableHeaders) error {
+	meta := jetstream.ObjectMeta{Name: key}
+	for k, v := range headers {
+		if meta.Headers == nil {
+			meta.Headers = make(nats.Header)
+		}
+		meta.Headers.Set(k, v)
+	}
+
+	_, err := s.store.Put(ctx, meta, r)
+	return err
+}
+
+func (s *jetStreamVariableStore) Delete(ctx context.Context, key string) error {
+	if err := s.store.Delete(ctx, key); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+		return err
+	}
+	return nil
+}
+
+/************************************************************************/
+// FILE
+/************************************************************************/
+
+func (s *fileVariableStore) Get(_ context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrVariableStoreKeyNotFound, key)
+	}
+	return b, err
+}
+
+func (s *fileVariableStore) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, _, err := s.GetReaderWithHeaders(ctx, key)
+	return r, err
+}
+
+// GetReaderWithHeaders reads the headers from the headers file of the key
+func (s *fileVariableStore) GetReaderWithHeaders(_ context.Context, key string) (io.ReadCloser, VariableHeaders, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrVariableStoreKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var headers VariableHeaders
+	b, err := os.ReadFile(s.headersPath(key))
+	if err == nil {
+		err = json.Unmarshal(b, &headers)
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return f, headers, nil
+}
+
+func (s *fileVariableStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.PutReader(ctx, key, bytes.NewReader(value))
+}
+
+func (s *fileVariableStore) PutReader(ctx context.Context, key string, r io.Reader) error {
+	return s.PutReaderWithHeaders(ctx, key, r, nil)
+}
+
+// PutReaderWithHeaders writes the value to a temporary file first so a crash never leaves a partially written value
+// behind, the headers are written to their own file right before the value is moved in place
+func (s *fileVariableStore) PutReaderWithHeaders(_ context.Context, key string, r io.Reader, headers VariableHeaders) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := s.putHeaders(key, headers); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// putHeaders replaces the headers file of the key, the file is removed if there are no headers
+func (s *fileVariableStore) putHeaders(key string, headers VariableHeaders) error {
+	if len(headers) == 0 {
+		if err := os.Remove(s.headersPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Join(s.dir, fileHeadersDir), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.headersPath(key))
+}
+
+func (s *fileVariableStore) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.putHeaders(key, nil)
+}
+
+// path returns the file of the key, keys must not point outside the directory of the store
+func (s *fileVariableStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || key == fileHeadersDir || filepath.Base(key) != key {
+		return "", fmt.Errorf("%w: %q", ErrInvalidVariableStoreKey, key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+func (s *fileVariableStore) headersPath(key string) string {
+	return filepath.Join(s.dir, fileHeadersDir, key)
+}
+
+/************************************************************************/
+// MEMORY
+/************************************************************************/
+
+func (s *memoryVariableStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.values[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVariableStoreKeyNotFound, key)
+	}
+	return append([]byte{}, v.value...), nil
+}
+
+func (s *memoryVariableStore) GetReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, _, err := s.GetReaderWithHeaders(ctx, key)
+	return r, err
+}
+
+func (s *memoryVariableStore) GetReaderWithHeaders(_ context.Context, key string) (io.ReadCloser, VariableHeaders, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.values[key]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrVariableStoreKeyNotFound, key)
+	}
+	return io.NopCloser(bytes.NewReader(v.value)), maps.Clone(v.headers), nil
+}
+
+func (s *memoryVariableStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.PutReaderWithHeaders(ctx, key, bytes.NewReader(value), nil)
+}
+
+func (s *memoryVariableStore) PutReader(ctx context.Context, key string, r io.Reader) error {
+	return s.PutReaderWithHeaders(ctx, key, r, nil)
+}
+
+func (s *memoryVariableStore) PutReaderWithHeaders(_ context.Context, key string, r io.Reader, headers VariableHeaders) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = memoryValue{value: b, headers: maps.Clone(headers)}
+	return nil
+}
+
+func (s *memoryVariableStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+	return nil
+}
+
+/************************************************************************/
+// FACTORIES
+/************************************************************************/
+
+// NewJetStreamVariableStore creates a VariableStore backed by a JetStream object store
+func NewJetStreamVariableStore(store jetstream.ObjectStore) VariableStore {
+	return &jetStreamVariableStore{store: store}
+}
+
+// NewFileVariableStore creates a VariableStore that stores every key as a file in the directory and its headers
+// as a file in the .headers directory, the directories are created if they do not exist
+func NewFileVariableStore(dir string) (VariableStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, fileHeadersDir), 0o700); err != nil {
+		return nil, err
+	}
+	return &fileVariableStore{dir: dir}, nil
+}
+
+// NewMemoryVariableStore creates a VariableStore that keeps all values in memory
+func NewMemoryVariableStore() VariableStore {
+	return &memoryVariableStore{values: make(map[string]memoryValue)}
+}
+
+// newVariableStore creates the VariableStore selected in the config, encoded values are always decoded when they
+// are read, values are compressed if the config selects an encoding and encrypted with the keys, or the keys of
+// the config if keys is nil
+func newVariableStore(ctx context.Context, cfg *Config, js jetstream.JetStream, keys KeyProvider) (VariableStore, error) {
+	var (
+		store VariableStore
+		err   error
+	)
+	switch cfg.variableStoreType() {
+	case VariableStoreTypeJetStream:
+		os, err := js.ObjectStore(ctx, cfg.NatsBucket)
+		if err != nil {
+			return nil, err
+		}
+		store = NewJetStreamVariableStore(os)
+	case VariableStoreTypeFile:
+		if cfg.Store.Path == "" {
+			return nil, fmt.Errorf("%w: store type %s", ErrMissingVariableStorePath, cfg.Store.Type)
+		}
+		if store, err = NewFileVariableStore(cfg.Store.Path); err != nil {
+			return nil, err
+		}
+	case VariableStoreTypeMemory:
+		store = NewMemoryVariableStore()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownVariableStoreType, cfg.Store.Type)
+	}
+
+	if keys == nil {
+		if keys, err = cfg.keyProvider(); err != nil {
+			return nil, err
+		}
+	}
+
+	encoding, threshold := cfg.contentEncoding()
+	return NewEncodedVariableStore(store, encoding, threshold, keys)
+}
@@ -0,0 +1,161 @@
+package sparkv1
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1/util"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariableStores(t *testing.T) {
+	port, err := util.GetFreeTCPPort()
+	require.NoError(t, err)
+
+	s, err := util.RunServerOnPort(port, t.TempDir())
+	require.NoError(t, err)
+	defer s.Shutdown()
+	s.Start()
+
+	nc, js := util.GetNatsClient(port)
+	defer nc.Close()
+
+	os, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{Bucket: "test"})
+	require.NoError(t, err)
+
+	fs, err := NewFileVariableStore(t.TempDir())
+	require.NoError(t, err)
+
+	stores := map[string]VariableStore{
+		"jetstream": NewJetStreamVariableStore(os),
+		"file":      fs,
+		"memory":    NewMemoryVariableStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("get missing key", func(t *testing.T) {
+				_, err := store.Get(ctx, "missing")
+				assert.ErrorIs(t, err, ErrVariableStoreKeyNotFound)
+			})
+
+			t.Run("put and get", func(t *testing.T) {
+				require.NoError(t, store.Put(ctx, "key", []byte("value")))
+				b, err := store.Get(ctx, "key")
+				require.NoError(t, err)
+				assert.Equal(t, []byte("value"), b)
+			})
+
+			t.Run("put overwrites", func(t *testing.T) {
+				require.NoError(t, store.Put(ctx, "key", []byte("other")))
+				b, err := store.Get(ctx, "key")
+				require.NoError(t, err)
+				assert.Equal(t, []byte("other"), b)
+			})
+
+			t.Run("put and get reader", func(t *testing.T) {
+				require.NoError(t, store.PutReader(ctx, "stream", strings.NewReader("streamed value")))
+				r, err := store.GetReader(ctx, "stream")
+				require.NoError(t, err)
+				b, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				assert.Equal(t, []byte("streamed value"), b)
+				require.NoError(t, store.Delete(ctx, "stream"))
+			})
+
+			t.Run("get reader of missing key", func(t *testing.T) {
+				_, err := store.GetReader(ctx, "missing")
+				assert.ErrorIs(t, err, ErrVariableStoreKeyNotFound)
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				require.NoError(t, store.Delete(ctx, "key"))
+				_, err := store.Get(ctx, "key")
+				assert.ErrorIs(t, err, ErrVariableStoreKeyNotFound)
+			})
+
+			t.Run("delete missing key", func(t *testing.T) {
+				assert.NoError(t, store.Delete(ctx, "missing"))
+			})
+
+			t.Run("put and get headers", func(t *testing.T) {
+				hs := store.(HeaderVariableStore)
+				headers := VariableHeaders{"Content-Encoding": "gzip"}
+				require.NoError(t, hs.PutReaderWithHeaders(ctx, "headers", strings.NewReader("value"), headers))
+
+				r, got, err := hs.GetReaderWithHeaders(ctx, "headers")
+				require.NoError(t, err)
+				b, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				assert.Equal(t, []byte("value"), b)
+				assert.Equal(t, headers, got)
+
+				require.NoError(t, hs.PutReaderWithHeaders(ctx, "headers", strings.NewReader("other"), nil))
+				r, got, err = hs.GetReaderWithHeaders(ctx, "headers")
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				assert.Empty(t, got)
+				require.NoError(t, store.Delete(ctx, "headers"))
+			})
+		})
+	}
+
+	t.Run("file store rejects keys outside its directory", func(t *testing.T) {
+		for _, key := range []string{"", ".", "..", ".headers", "../escape", "a/b"} {
+			assert.ErrorIs(t, fs.Put(context.Background(), key, []byte("x")), ErrInvalidVariableStoreKey, key)
+		}
+	})
+
+	t.Run("selects the store from the config", func(t *testing.T) {
+		ctx := context.Background()
+
+		vs, err := newVariableStore(ctx, &Config{NatsBucket: "test"}, js, nil)
+		require.NoError(t, err)
+		assert.IsType(t, &jetStreamVariableStore{}, vs.(*encodedVariableStore).store)
+
+		vs, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeFile, Path: t.TempDir()}}, js, nil)
+		require.NoError(t, err)
+		assert.IsType(t, &fileVariableStore{}, vs.(*encodedVariableStore).store)
+
+		vs, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeMemory}}, js, nil)
+		require.NoError(t, err)
+		assert.IsType(t, &memoryVariableStore{}, vs.(*encodedVariableStore).store)
+
+		_, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeFile}}, js, nil)
+		assert.ErrorIs(t, err, ErrMissingVariableStorePath)
+
+		_, err = newVariableStore(ctx, &Config{Store: &configStore{Type: "unknown"}}, js, nil)
+		assert.ErrorIs(t, err, ErrUnknownVariableStoreType)
+
+		vs, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeMemory, Encoding: ContentEncodingZstd, EncodingThreshold: 10}}, js, nil)
+		require.NoError(t, err)
+		assert.Equal(t, ContentEncodingZstd, vs.(*encodedVariableStore).encoding)
+		assert.Equal(t, 10, vs.(*encodedVariableStore).threshold)
+
+		_, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeMemory, Encoding: "brotli"}}, js, nil)
+		assert.ErrorIs(t, err, ErrUnknownContentEncoding)
+
+		encryption := &configEncryption{KeyId: "key1", Keys: map[string]string{"key1": "AAAAAAAAAAAAAAAAAAAAAA=="}}
+		vs, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeMemory, Encryption: encryption}}, js, nil)
+		require.NoError(t, err)
+		assert.NotNil(t, vs.(*encodedVariableStore).keys)
+
+		encryption = &configEncryption{KeyId: "key1", Keys: map[string]string{"key1": "not base64"}}
+		_, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeMemory, Encryption: encryption}}, js, nil)
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+
+		keys, err := NewStaticKeyProvider("key", map[string][]byte{"key": make([]byte, 32)})
+		require.NoError(t, err)
+		vs, err = newVariableStore(ctx, &Config{Store: &configStore{Type: VariableStoreTypeMemory}}, js, keys)
+		require.NoError(t, err)
+		assert.Equal(t, keys, vs.(*encodedVariableStore).keys)
+	})
+}
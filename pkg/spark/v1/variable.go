@@ -1,6 +1,8 @@
 package sparkv1
 
 import (
+	"io"
+
 	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
 )
 
@@ -8,9 +10,62 @@ type Var struct {
 	Name     string
 	MimeType codec.MimeType
 	Value    []byte
+	// Reader streams the value to the variable store instead of holding it in Value
+	Reader io.Reader
+	// err the error encoding the value, it is returned by CompleteContext.Output
+	err error
 }
 
+// NewVar creates an output, the value is encoded with the codec of the mime type, an encoding error is returned
+// when the output is set with CompleteContext.Output
 func NewVar(name string, mimeType codec.MimeType, value any) *Var {
-	val, _ := codec.Encode(value)
-	return &Var{name, mimeType, val}
+	val, err := codec.EncodeAs(value, mimeType)
+	return &Var{Name: name, MimeType: mimeType, Value: val, err: err}
+}
+
+// Err returns the error encoding the value of the variable
+func (v *Var) Err() error {
+	return v.err
+}
+
+// NewStreamVar creates an output that is written to the variable store as it is read, use it for values that are
+// too large to hold in memory
+func NewStreamVar(name string, mimeType codec.MimeType, r io.Reader) *Var {
+	return &Var{Name: name, MimeType: mimeType, Reader: r}
+}
+
+// Result a stage result with an explicit mime type, the value is encoded with the codec of the mime type, the mime type of
+// any other value a stage returns is detected with codec.DetectMimeType
+type Result struct {
+	Value    any
+	MimeType codec.MimeType
+}
+
+func NewResult(value any, mimeType codec.MimeType) *Result {
+	return &Result{Value: value, MimeType: mimeType}
+}
+
+// encodeStageResult encodes the value a stage returned and determines its mime type
+func encodeStageResult(out any) ([]byte, codec.MimeType, error) {
+	if r, ok := out.(*Result); ok {
+		out = r.Value
+		if r.MimeType != "" {
+			b, err := codec.EncodeAs(out, r.MimeType)
+			return b, r.MimeType, err
+		}
+	}
+
+	b, err := codec.Encode(out)
+	return b, codec.DetectMimeType(out), err
+}
+
+// StreamResult a stage result that is written to the variable store as it is read instead of being encoded in
+// memory, a stage may also return a plain io.Reader which is stored as codec.MimeTypeOctetStream
+type StreamResult struct {
+	Reader   io.Reader
+	MimeType codec.MimeType
+}
+
+func NewStreamResult(r io.Reader, mimeType codec.MimeType) *StreamResult {
+	return &StreamResult{Reader: r, MimeType: mimeType}
 }
@@ -25,6 +25,36 @@ func (s *VariableSuite) Test_Create_Var_Returns_Valid_Var() {
 	s.Equal(`"testValue"`, string(v.Value))
 }
 
+func (s *VariableSuite) Test_Create_Var_Encodes_With_Codec_Of_Mime_Type() {
+	v := NewVar("test", codec.MimeTypeYaml, map[string]string{"foo": "bar"})
+	s.Equal("foo: bar\n", string(v.Value))
+
+	var out map[string]string
+	s.NoError(NewBindable(Value{Value: v.Value, MimeType: string(v.MimeType)}).Bind(&out))
+	s.Equal(map[string]string{"foo": "bar"}, out)
+}
+
+func (s *VariableSuite) Test_Create_Var_Keeps_Encoding_Error() {
+	v := NewVar("test", codec.MimeTypeJson, make(chan int))
+	s.Error(v.Err())
+
+	cc := &completeContext{}
+	s.ErrorIs(cc.Output(v), v.Err())
+	s.Empty(cc.outputs, "an output that could not be encoded must not be set")
+}
+
+func (s *VariableSuite) Test_Create_Bindable_Value_Keeps_Encoding_Error() {
+	b := NewBindableValue(make(chan int), string(codec.MimeTypeJson))
+
+	_, err := b.GetValue()
+	s.Error(err)
+
+	var out any
+	s.ErrorIs(b.Bind(&out), err)
+	_, rerr := b.Reader()
+	s.ErrorIs(rerr, err)
+}
+
 /************************************************************************/
 // SUITE
 /************************************************************************/
@@ -2,9 +2,13 @@ package sparkv1
 
 import (
 	"context"
-	"github.com/rs/zerolog/log"
+	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 /************************************************************************/
@@ -27,8 +31,20 @@ type sparkWorker struct {
 // Worker IMPLEMENTATION
 /************************************************************************/
 
-// Run runs the worker and waits for kill signals and then gracefully shuts down the worker
+// Run runs the worker and waits for kill signals and then gracefully shuts down the worker, when the process is
+// started with --describe[=json|dot|mermaid] the manifest of the SparkChain is written to stdout instead, the
+// process exits with code 2 if the manifest can not be written
 func (w *sparkWorker) Run() {
+	if format, ok := describeFormat(os.Args[1:]); ok {
+		err := w.chain.Describe().Encode(os.Stdout, format)
+		w.cancel()
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "spark: unable to describe the chain: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+
 	// init the spark
 	w.initIfRequired()
 
@@ -44,12 +60,35 @@ func (w *sparkWorker) Run() {
 	w.opts.log.Info("plugin: stopping")
 	w.plugin.stop()
 	w.opts.log.Info("plugin: stopped")
+
+	w.cancel()
+}
+
+// DeadLetters returns the jobs that were dead-lettered by the running worker, replayed jobs run on the workflow of
+// the worker
+func (w *sparkWorker) DeadLetters() (*DeadLetters, error) {
+	return w.plugin.deadLetterAPI()
 }
 
 /************************************************************************/
 // HELPERS
 /************************************************************************/
 
+// describeFormat returns the manifest format requested by the --describe flag, json if no format is given
+func describeFormat(args []string) (ManifestFormat, bool) {
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "describe" {
+			continue
+		}
+		if !hasValue || value == "" {
+			return ManifestFormatJson, true
+		}
+		return ManifestFormat(value), true
+	}
+	return "", false
+}
+
 func (w *sparkWorker) validate(report ChainReport) error {
 	if w.opts.log == nil {
 		w.opts.log = NewLogger()
@@ -111,13 +150,21 @@ func NewSparkWorker(ctx context.Context, spark Spark, options ...Option) (Worker
 	// load the configuration if available
 	jw.loadConfiguration(jw.opts)
 
-	jw.ctx, jw.cancel = context.WithTimeout(ctx, jw.config.Timeout)
+	// the lifetime of the worker is not bound to Config.Timeout, it only applies to each job
+	jw.ctx, jw.cancel = context.WithCancel(ctx)
 
 	// build the SparkChain
 	builder := NewBuilder()
 	spark.BuildChain(builder)
 	chain := builder.BuildChain()
 
+	// declare the inputs and outputs of the spark.yaml next to the ones declared by the builder
+	schema, err := jw.config.sparkSchema(newBindableConfig(jw.opts))
+	if err != nil {
+		return nil, err
+	}
+	chain.schema = chain.schema.merge(schema)
+
 	// validate the SparkChain
 	report := generateReportForChain(chain)
 
@@ -128,7 +175,7 @@ func NewSparkWorker(ctx context.Context, spark Spark, options ...Option) (Worker
 	}
 
 	jw.opts.log.Info("setting up plugin")
-	jw.plugin = newSparkPlugin(ctx, jw.config, chain)
+	jw.plugin = newSparkPlugin(ctx, jw.config, chain, jw.opts.keyProvider)
 
 	return jw, nil
 }
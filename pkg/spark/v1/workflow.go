@@ -12,6 +12,9 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/rs/zerolog/log"
+	"io"
+	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -21,6 +24,50 @@ type RetryConfig struct {
 	Times             uint          `json:"times" yaml:"times"`
 	FirstBackoffWait  time.Duration `json:"first_backoff_wait" yaml:"first_backoff_wait"`
 	BackoffMultiplier uint          `json:"backoff_multiplier" yaml:"backoff_multiplier"`
+	// MaxBackoffWait caps the wait between attempts, no cap is applied when zero
+	MaxBackoffWait time.Duration `json:"max_backoff_wait,omitempty" yaml:"max_backoff_wait,omitempty"`
+	// Jitter randomises the wait between attempts by up to the given fraction of the wait, e.g. 0.2 for +/-20%
+	Jitter float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// RetryOn limits retries to errors with one of the given codes, errors with any code are retried when empty
+	RetryOn []ErrorCode `json:"retry_on,omitempty" yaml:"retry_on,omitempty"`
+}
+
+// retries returns true if an error with the given code must be retried
+func (r *RetryConfig) retries(code ErrorCode) bool {
+	if len(r.RetryOn) == 0 {
+		return true
+	}
+	for _, c := range r.RetryOn {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the next attempt after the given attempt failed
+func (r *RetryConfig) backoff(attempt uint) time.Duration {
+	wait := r.FirstBackoffWait
+	for i := uint(1); i < attempt; i++ {
+		wait = time.Duration(int64(wait) * int64(r.BackoffMultiplier))
+		if r.MaxBackoffWait > 0 && wait >= r.MaxBackoffWait {
+			break
+		}
+	}
+
+	if r.MaxBackoffWait > 0 && wait > r.MaxBackoffWait {
+		wait = r.MaxBackoffWait
+	}
+
+	if r.Jitter > 0 {
+		delta := float64(wait) * r.Jitter
+		wait += time.Duration(delta * (2*rand.Float64() - 1))
+	}
+
+	if wait < 0 {
+		return 0
+	}
+	return wait
 }
 
 type jobWorkflow struct {
@@ -30,83 +77,356 @@ type jobWorkflow struct {
 	stageTracker       InternalStageTracker
 	cfg                *Config
 	nc                 *nats.Conn
-	store              jetstream.ObjectStore
+	store              VariableStore
 	inputs             ExecuteSparkInputs
 	stageRetryOverride *RetryConfig
+	jobs               sync.Map
+	js                 jetstream.JetStream
+	// publisher publishes the results of the jobs, they are published to Config.NatsResponseSubject if it is nil
+	publisher ResultPublisher
 }
 
 func (w *jobWorkflow) Run(msg jetstream.Msg) {
 	var jmd *JobMetadata
-	if err := json.Unmarshal(msg.Data(), &jmd); err != nil {
-		w.publishError(err)
+	err := json.Unmarshal(msg.Data(), &jmd)
+	if err == nil && jmd == nil {
+		err = ErrMalformedJobRequest
+	}
+	if err != nil {
+		// a malformed job request will never succeed, it is dead-lettered straight away
+		w.deadLetter(msg, numDelivered(msg), err)
+		w.publishError(jmd, err)
+		w.ack(msg)
 		return
 	}
 
+	ctx, cancel := w.newJobContext(jmd)
+	defer cancel(nil)
+
 	state := &JobState{
 		JobContext: jmd,
 	}
 
-	var sparkIO = NewIoDataProvider(w.ctx, w.store)
+	var sparkIO = newIoDataProvider(ctx, w.store)
 	sparkIO.SetInitialInputs(w.inputs)
 	if err := sparkIO.LoadVariables(jmd.VariablesKey); err != nil {
-		w.publishError(err)
+		w.reject(msg, jmd, sparkIO, err)
+		return
+	}
+
+	// resume from the last completed stage if this job was delivered before
+	cp, err := w.loadCheckpoint(ctx, jmd.JobKeyValue, numDelivered(msg) > 1)
+	if err == nil {
+		err = cp.rehydrate(state, sparkIO)
+	}
+	if err != nil {
+		w.reject(msg, jmd, sparkIO, err)
 		return
 	}
 
-	var doNext func(next *Node) *ExecuteStageResponse
+	var (
+		doNext      func(ctx context.Context, next *Node) *ExecuteStageResponse
+		compensate  func(ctx context.Context, failed *Node, out *ExecuteStageResponse) *ExecuteStageResponse
+		cancelled   func(ctx context.Context, n *Node, stageName string) *ExecuteStageResponse
+		runParallel func(ctx context.Context, n *Node, group *Stage) *ExecuteStageResponse
+		runBranch   func(ctx context.Context, n *Node, step *Stage) *ExecuteStageResponse
+		// branchOutputs holds the outputs of the Complete stages of the branches that ran
+		branchOutputs = make(BindableMap)
+	)
+
+	// prepareStage decides if a stage must run and returns its settings, stages that already ran in a previous
+	// delivery or whose conditional options are not met are not executed and return nil settings, an error is
+	// returned if an option failed
+	prepareStage := func(ctx context.Context, stage *Stage) (*stageSettings, StageError) {
+		if cp.isResumable(stage.Name) {
+			w.setStageStatus(state, stage.Name, cp.StageStatuses[stage.Name])
+			return nil, nil
+		}
+
+		settings := &stageSettings{}
+		params := newStageOptionParams(&JobContext{Context: ctx, Metadata: jmd}, stage.Name, state, sparkIO, settings)
+		if err := stage.ApplyConditionalExecutionOptions(params); err != nil {
+			if errors.Is(err, ErrConditionalStageSkipped) {
+				w.checkpoint(ctx, cp, state, stage.Name, w.skipStage(state, stage.Name, sparkIO))
+				return nil, nil
+			}
+
+			w.setStageStatus(state, stage.Name, StageStatus_STAGE_FAILED)
+			return nil, err
+		}
+
+		return settings, nil
+	}
+
+	// completeStage records the result of a stage that completed successfully
+	completeStage := func(ctx context.Context, name string, res Bindable) {
+		w.setStageStatus(state, name, StageStatus_STAGE_COMPLETED)
 
-	doNext = func(next *Node) *ExecuteStageResponse {
+		if state.StageResults == nil {
+			state.StageResults = make(map[string]Bindable)
+		}
+
+		state.StageResults[name] = res
+		if w.stageTracker != nil {
+			w.stageTracker.SetStageResult(name, res)
+		}
+
+		w.checkpoint(ctx, cp, state, name, res)
+	}
+
+	doNext = func(ctx context.Context, next *Node) *ExecuteStageResponse {
 		if next == nil {
 			return nil
 		}
 
 		for _, stage := range next.Stages {
-			select {
-			case <-w.ctx.Done():
-				w.setStageStatus(stage.Name, StageStatus_STAGE_FAILED)
-				return getSparkErrorOutput(errors.New("canceled"))
-			default:
-				w.setStageStatus(stage.Name, StageStatus_STAGE_STARTED)
-				res, err := w.executeStageActivity(w.ctx, stage.Name, state, sparkIO)
-				if err != nil {
-					w.setStageStatus(stage.Name, StageStatus_STAGE_FAILED)
-					return getSparkErrorOutput(err)
+			if ctx.Err() != nil {
+				return cancelled(ctx, next, stage.Name)
+			}
+
+			if stage.IsParallel() {
+				if out := runParallel(ctx, next, stage); out != nil {
+					return out
+				}
+				continue
+			}
+
+			if stage.IsBranch() {
+				if out := runBranch(ctx, next, stage); out != nil {
+					return out
 				}
+				continue
+			}
 
-				select {
-				case <-w.ctx.Done():
-					w.setStageStatus(stage.Name, StageStatus_STAGE_FAILED)
-				default:
-					w.setStageStatus(stage.Name, StageStatus_STAGE_COMPLETED)
+			settings, err := prepareStage(ctx, stage)
+			if err != nil {
+				return compensate(ctx, next, getSparkErrorOutput(err))
+			}
+			if settings == nil {
+				continue
+			}
 
-					if state.StageResults == nil {
-						state.StageResults = make(map[string]Bindable)
-					}
+			w.setStageStatus(state, stage.Name, StageStatus_STAGE_STARTED)
+			res, err2 := w.executeStageActivity(ctx, stage.Name, settings, state, sparkIO)
+			if ctx.Err() != nil {
+				return cancelled(ctx, next, stage.Name)
+			}
 
-					state.StageResults[stage.Name] = res
-					if w.stageTracker != nil {
-						w.stageTracker.SetStageResult(stage.Name, res)
-					}
-				}
+			if err2 != nil {
+				w.setStageStatus(state, stage.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, next, getSparkErrorOutput(err2))
 			}
+
+			completeStage(ctx, stage.Name, res)
 		}
 
 		if next.Complete != nil {
-			w.setStageStatus(next.Complete.Name, StageStatus_STAGE_STARTED)
-			v, err := w.executeCompleteActivity(w.ctx, next.Complete.Name, state, sparkIO)
+			if ctx.Err() != nil {
+				return cancelled(ctx, next, next.Complete.Name)
+			}
+
+			w.setStageStatus(state, next.Complete.Name, StageStatus_STAGE_STARTED)
+			v, err := w.executeCompleteActivity(ctx, next.Complete.Name, state, sparkIO)
 			if err != nil {
-				w.setStageStatus(next.Complete.Name, StageStatus_STAGE_FAILED)
-				return getSparkErrorOutput(err)
+				w.setStageStatus(state, next.Complete.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, next, getSparkErrorOutput(err))
+			}
+
+			if v.Error != nil {
+				w.setStageStatus(state, next.Complete.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, next, v)
 			}
 
-			w.setStageStatus(next.Complete.Name, StageStatus_STAGE_COMPLETED)
+			w.setStageStatus(state, next.Complete.Name, StageStatus_STAGE_COMPLETED)
 			return v
 		}
 
 		return getSparkErrorOutput(module_runner2.ErrChainDoesNotHaveACompleteStage)
 	}
 
-	out := doNext(w.Chain.RootNode)
+	// runParallel executes the stages of a parallel group concurrently, results are recorded once all the
+	// stages returned so the job state is only ever modified by this goroutine, returns nil on success
+	runParallel = func(ctx context.Context, n *Node, group *Stage) *ExecuteStageResponse {
+		var pending []preparedStage
+		for _, stage := range group.parallel {
+			settings, err := prepareStage(ctx, stage)
+			if err != nil {
+				w.setStageStatus(state, group.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, n, getSparkErrorOutput(err))
+			}
+			if settings != nil {
+				pending = append(pending, preparedStage{Stage: stage, settings: settings})
+			}
+		}
+
+		w.setStageStatus(state, group.Name, StageStatus_STAGE_STARTED)
+		for _, stage := range pending {
+			w.setStageStatus(state, stage.Name, StageStatus_STAGE_STARTED)
+		}
+
+		results := w.executeParallelActivity(ctx, pending, group.mode, state, sparkIO)
+
+		var errs []*ExecuteSparkError
+		for _, r := range results {
+			switch {
+			case r.err == nil:
+				completeStage(ctx, r.stage.Name, r.res)
+			case r.cancelled || ctx.Err() != nil:
+				w.setStageStatus(state, r.stage.Name, StageStatus_STAGE_CANCELLED)
+			default:
+				w.setStageStatus(state, r.stage.Name, StageStatus_STAGE_FAILED)
+				errs = append(errs, getSparkErrorOutput(r.err).Error)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return cancelled(ctx, n, group.Name)
+		}
+
+		if len(errs) > 0 {
+			w.setStageStatus(state, group.Name, StageStatus_STAGE_FAILED)
+			return compensate(ctx, n, getParallelErrorOutput(group.Name, errs))
+		}
+
+		w.setStageStatus(state, group.Name, StageStatus_STAGE_COMPLETED)
+		return nil
+	}
+
+	// runBranch executes the branch selected by the selector of the step, the selected key is the result of the
+	// step so a redelivered job resumes in the same branch, returns nil on success
+	runBranch = func(ctx context.Context, n *Node, step *Stage) *ExecuteStageResponse {
+		var key string
+		if cp.isResumable(step.Name) {
+			w.setStageStatus(state, step.Name, cp.StageStatuses[step.Name])
+			err := state.StageResults[step.Name].Bind(&key)
+			if _, ok := step.branch.Nodes[key]; err == nil && !ok {
+				// the chain may have changed since the checkpoint was written
+				err = newErrBranchNotFound(step.Name, key)
+			}
+			if err != nil {
+				w.setStageStatus(state, step.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, n, getSparkErrorOutput(err))
+			}
+		} else {
+			w.setStageStatus(state, step.Name, StageStatus_STAGE_STARTED)
+			k, err := w.executeBranchActivity(ctx, step.branch, state, sparkIO)
+			if ctx.Err() != nil {
+				return cancelled(ctx, n, step.Name)
+			}
+			if err == nil {
+				if _, ok := step.branch.Nodes[k]; !ok {
+					err = newErrBranchNotFound(step.Name, k)
+				}
+			}
+			if err != nil {
+				w.setStageStatus(state, step.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, n, getSparkErrorOutput(err))
+			}
+
+			res, err2 := w.putBranchResult(step.Name, k, sparkIO)
+			if err2 != nil {
+				w.setStageStatus(state, step.Name, StageStatus_STAGE_FAILED)
+				return compensate(ctx, n, getSparkErrorOutput(err2))
+			}
+
+			key = k
+			completeStage(ctx, step.Name, res)
+		}
+
+		out := doNext(ctx, step.branch.Nodes[key])
+		switch {
+		case out.Cancellation != nil && out.Cancellation.ChainName == "":
+			// the branch has no cancellation chain of its own, fall back to the one of the parent
+			return cancelled(ctx, n, out.Cancellation.StageName)
+		case out.Cancellation != nil:
+			return out
+		case out.Error != nil && out.Compensation == nil:
+			// the branch has no compensation chain of its own, fall back to the one of the parent
+			return compensate(ctx, n, out)
+		case out.Error != nil:
+			return out
+		}
+
+		for k, v := range out.Outputs {
+			branchOutputs[k] = v
+		}
+		return nil
+	}
+
+	// compensate runs the compensation chain of the failed node in reverse of the order the stages of the node
+	// completed in, see compensationNode. The original error is always kept on the response so the caller knows
+	// why the spark failed, the outcome of the compensation is attached to it
+	compensate = func(ctx context.Context, failed *Node, out *ExecuteStageResponse) *ExecuteStageResponse {
+		if !failed.HasCompensationStage() {
+			return out
+		}
+
+		prev := state.FailedStageError
+		state.FailedStageError = out.Error
+		co := doNext(ctx, compensationNode(failed, state))
+		state.FailedStageError = prev
+
+		out.Compensation = &ExecuteSparkCompensation{
+			ChainName:    failed.Compensate.Name,
+			Error:        co.Error,
+			Compensation: co.Compensation,
+			Outputs:      co.Outputs,
+		}
+
+		return out
+	}
+
+	// cancelled marks the interrupted stage as cancelled and runs the cancellation chain of the node, the job
+	// context is already done at this point so the cancellation chain gets a fresh context with its own timeout
+	cancelled = func(ctx context.Context, n *Node, stageName string) *ExecuteStageResponse {
+		w.setStageStatus(state, stageName, StageStatus_STAGE_CANCELLED)
+
+		out := getSparkCancelledOutput(stageName, context.Cause(ctx))
+		if !n.HasCancellationStage() {
+			return out
+		}
+
+		cctx, ccancel := w.newDetachedContext()
+		defer ccancel()
+
+		co := doNext(cctx, n.Cancel)
+		out.Cancellation.ChainName = n.Cancel.Name
+		out.Cancellation.Error = co.Error
+		out.Outputs = co.Outputs
+
+		return out
+	}
+
+	var out *ExecuteStageResponse
+	if err := w.Chain.schema.validateInputs(sparkIO); err != nil {
+		out = getSparkErrorOutput(err)
+	} else {
+		out = doNext(ctx, w.Chain.RootNode)
+	}
+
+	// outputs of the branches that ran are returned unless the Complete stage of the root overrides them
+	if out.Error == nil && len(branchOutputs) > 0 {
+		if out.Outputs == nil {
+			out.Outputs = make(BindableMap)
+		}
+		for k, v := range branchOutputs {
+			if _, ok := out.Outputs[k]; !ok {
+				out.Outputs[k] = v
+			}
+		}
+	}
+
+	// outputs of cancellation and compensation chains are not validated, the spark did not complete
+	if out.Error == nil {
+		var stageName string
+		if w.Chain.RootNode.Complete != nil {
+			stageName = w.Chain.RootNode.Complete.Name
+		}
+		if err := w.Chain.schema.validateOutputs(stageName, out.Outputs); err != nil {
+			w.setStageStatus(state, stageName, StageStatus_STAGE_FAILED)
+			out = getSparkErrorOutput(err)
+		}
+	}
 
 	result := &ExecuteSparkOutput{
 		Error:         out.Error,
@@ -115,88 +435,262 @@ func (w *jobWorkflow) Run(msg jetstream.Msg) {
 		CorrelationId: jmd.CorrelationIdValue,
 		TransactionId: jmd.TransactionIdValue,
 		Model:         jmd.Model,
+		Compensation:  out.Compensation,
+		Cancellation:  out.Cancellation,
 	}
 
-	// output
-	if out.Outputs != nil {
-		result.VariablesKey = uuid.NewString()
-		ob, err := json.Marshal(out.Outputs)
-		if err != nil {
-			w.publishError(err)
-			return
-		}
-		if _, err := w.store.PutBytes(w.ctx, result.VariablesKey, ob); err != nil {
-			w.publishError(err)
-			return
-		}
+	// the job context is done when the spark was cancelled but the outputs of the cancellation chain must
+	// still be stored
+	storeCtx, storeCancel := ctx, context.CancelFunc(func() {})
+	if ctx.Err() != nil {
+		storeCtx, storeCancel = w.newDetachedContext()
+	}
+	defer storeCancel()
+
+	// output, the outputs of the compensation chains are stored apart from the outputs of the failed node
+	key, err := w.storeOutputs(storeCtx, out.Outputs)
+	for c := result.Compensation; c != nil && err == nil; c = c.Compensation {
+		c.VariablesKey, err = w.storeOutputs(storeCtx, c.Outputs)
+		c.Outputs = nil
 	}
+	if err != nil {
+		w.publishError(jmd, err)
+		w.finish(msg, jmd, sparkIO, cp)
+		return
+	}
+	result.VariablesKey = key
 
 	// response
 	rb, err := json.Marshal(result)
 	if err != nil {
-		w.publishError(err)
+		w.publishError(jmd, err)
+		w.finish(msg, jmd, sparkIO, cp)
 		return
 	}
 
 	w.publish(rb)
+	w.finish(msg, jmd, sparkIO, cp)
+}
+
+// compensationNode returns the compensation chain of the failed node with the stages that undo the completed stages
+// of the node, newest first. The stage at index i of the compensation chain undoes the stage at index i of the node,
+// stages that did not complete have nothing to undo and the Complete stage of the compensation chain always runs
+func compensationNode(failed *Node, state *JobState) *Node {
+	comp := *failed.Compensate
+	comp.Stages = nil
+	for i := min(len(failed.Stages), len(failed.Compensate.Stages)) - 1; i >= 0; i-- {
+		if state.StageStatuses[failed.Stages[i].Name] == StageStatus_STAGE_COMPLETED {
+			comp.Stages = append(comp.Stages, failed.Compensate.Stages[i])
+		}
+	}
+	return &comp
 }
 
-func (w *jobWorkflow) executeStageActivity(ctx context.Context, stageName string, state *JobState, io SparkDataIO) (Bindable, error) {
+// storeOutputs stores the outputs under a new key and returns the key, an empty key is returned if there are no
+// outputs
+func (w *jobWorkflow) storeOutputs(ctx context.Context, outputs BindableMap) (string, error) {
+	if outputs == nil {
+		return "", nil
+	}
+
+	ob, err := json.Marshal(outputs)
+	if err != nil {
+		return "", err
+	}
+
+	key := uuid.NewString()
+	if err := w.store.Put(ctx, key, ob); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// finish removes the checkpoint and streamed stage results of a job and acknowledges it, the job is only
+// acknowledged once its result has been published, a job that is redelivered before this point resumes from its
+// checkpoint
+func (w *jobWorkflow) finish(msg jetstream.Msg, jmd *JobMetadata, io *ioDataProvider, cp *jobCheckpoint) {
+	dctx, dcancel := w.newDetachedContext()
+	defer dcancel()
+
+	w.deleteCheckpoint(dctx, jmd.JobKeyValue, cp)
+	io.deleteStreams(dctx)
+	w.ack(msg)
+}
+
+// discard removes the checkpoint and the stage results a job streamed to the variable store in this and previous
+// deliveries, it is called when the job is dead-lettered and will not be delivered again
+func (w *jobWorkflow) discard(jmd *JobMetadata, io *ioDataProvider) {
+	dctx, dcancel := w.newDetachedContext()
+	defer dcancel()
+
+	if io != nil {
+		io.deleteStreams(dctx)
+	}
+	if w.store == nil || jmd.JobKeyValue == "" {
+		return
+	}
+
+	cp, err := w.loadCheckpoint(dctx, jmd.JobKeyValue, true)
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to load checkpoint of job %s, streamed stage results are kept", jmd.JobKeyValue)
+	}
+	if cp != nil {
+		for name, value := range cp.StageResults {
+			if value == nil || value.Ref == "" {
+				continue
+			}
+			if err := w.store.Delete(dctx, value.Ref); err != nil {
+				log.Error().Err(err).Msgf("unable to delete result of stage %s", name)
+			}
+		}
+	}
+	w.deleteCheckpoint(dctx, jmd.JobKeyValue, cp)
+}
+
+// Cancel cancels a running job, returns false if the job is not running on this workflow
+func (w *jobWorkflow) Cancel(jobKey string) bool {
+	if cancel, ok := w.jobs.Load(jobKey); ok {
+		cancel.(context.CancelCauseFunc)(ErrJobCancelled)
+		return true
+	}
+	return false
+}
+
+// newJobContext creates the context a single job runs in, the context is done when the workflow stops, the job
+// exceeds the configured timeout or its deadline, or the job is cancelled through Cancel
+func (w *jobWorkflow) newJobContext(jmd *JobMetadata) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(w.ctx)
+	w.jobs.Store(jmd.JobKeyValue, cancel)
+
+	var timeoutCancel context.CancelFunc = func() {}
+	if w.cfg != nil && w.cfg.Timeout > 0 {
+		ctx, timeoutCancel = context.WithTimeoutCause(ctx, w.cfg.Timeout, ErrJobTimeout)
+	}
+
+	// the deadline of the job applies on top of the configured timeout, whichever comes first
+	var deadlineCancel context.CancelFunc = func() {}
+	if jmd.Deadline != nil {
+		ctx, deadlineCancel = context.WithDeadlineCause(ctx, *jmd.Deadline, ErrJobTimeout)
+	}
+
+	return ctx, func(cause error) {
+		w.jobs.Delete(jmd.JobKeyValue)
+		deadlineCancel()
+		timeoutCancel()
+		cancel(cause)
+	}
+}
+
+// newDetachedContext creates a bounded context that is not tied to the job, used to run work that must still
+// happen after a job was cancelled
+func (w *jobWorkflow) newDetachedContext() (context.Context, context.CancelFunc) {
+	timeout := defaultCancelTimeout
+	if w.cfg != nil && w.cfg.CancelTimeout > 0 {
+		timeout = w.cfg.CancelTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// listenForCancellations cancels running jobs when a JobCancelRequest is received on the cancel subject
+func (w *jobWorkflow) listenForCancellations() error {
+	if w.nc == nil || w.cfg == nil || w.cfg.NatsCancelSubject == "" {
+		return nil
+	}
+
+	_, err := w.nc.Subscribe(w.cfg.NatsCancelSubject, func(msg *nats.Msg) {
+		var req JobCancelRequest
+		if err := json.Unmarshal(msg.Data, &req); err != nil {
+			log.Error().Err(err).Msgf("unable to unmarshal job cancel request")
+			return
+		}
+
+		if w.Cancel(req.JobKey) {
+			log.Info().Msgf("job %s cancelled by request", req.JobKey)
+		}
+	})
+
+	return err
+}
+
+func (w *jobWorkflow) executeStageActivity(ctx context.Context, stageName string, settings *stageSettings, state *JobState, io SparkDataIO) (Bindable, error) {
 	var (
 		sr  Bindable // stage result
 		err error
 	)
 
-	var attempts uint = 0
-	var waitTime *time.Duration
-	for {
-		sr, err = w.ExecuteStageActivity(ctx, &ExecuteStageRequest{
-			StageName:     stageName,
-			JobKey:        state.JobContext.JobKeyValue,
-			TransactionId: state.JobContext.TransactionIdValue,
-			CorrelationId: state.JobContext.CorrelationIdValue,
-		}, io)
+	for attempt := uint(1); ; attempt++ {
+		if w.stageTracker != nil {
+			w.stageTracker.SetStageAttempt(stageName, attempt)
+		}
+
+		sr, err = w.executeStageAttempt(ctx, &ExecuteStageRequest{
+			StageName:        stageName,
+			JobKey:           state.JobContext.JobKeyValue,
+			TransactionId:    state.JobContext.TransactionIdValue,
+			CorrelationId:    state.JobContext.CorrelationIdValue,
+			FailedStageError: state.FailedStageError,
+			Attempt:          attempt,
+		}, settings, io)
 		if err != nil {
 			return nil, err
 		}
 
-		if codec.MimeType(sr.GetMimeType()) == codec.MimeTypeJson.WithType("error") {
-			attempts++
-			se := errorWrap{StageName: stageName}
-			if err := sr.Bind(&se); err != nil {
-				return nil, err
-			}
-
-			if se.Retry == nil {
-				// no retries set, exit
-				return nil, se
-			}
+		if codec.MimeType(sr.GetMimeType()) != MimeJsonError {
+			return sr, nil
+		}
 
-			// check if we must override this retry value
-			if w.stageRetryOverride != nil {
-				se.Retry = w.stageRetryOverride
-			}
+		se := errorWrap{StageName: stageName}
+		if err := sr.Bind(&se); err != nil {
+			return nil, err
+		}
 
-			if se.Retry.Times <= attempts {
-				//TODO Compensate()
-				return nil, se
-			}
+		retry := w.getRetryConfig(se, settings)
+		if retry == nil || retry.Times <= attempt {
+			return nil, se
+		}
 
-			if waitTime == nil {
-				waitTime = &se.Retry.FirstBackoffWait
-			} else {
-				d := time.Duration(int64(*waitTime) * int64(se.Retry.BackoffMultiplier))
-				waitTime = &d
-			}
+		waitTime := retry.backoff(attempt)
+		log.Info().Msgf("stage error occurred, waiting %s before retry attempt %d", waitTime, attempt+1)
 
-			log.Info().Msgf("stage error occurred, sleeping %s before retry attempt %d", waitTime, attempts)
-			time.Sleep(*waitTime)
-		} else {
-			return sr, nil
+		// the job was cancelled while waiting, the caller handles the cancellation
+		if !waitForRetry(ctx, waitTime) {
+			return nil, se
 		}
 	}
 }
 
+// getRetryConfig returns the retry config that applies to a stage error, the retry config of the error takes
+// precedence over the retry policy of the stage, nil is returned if the error must not be retried
+func (w *jobWorkflow) getRetryConfig(se errorWrap, settings *stageSettings) *RetryConfig {
+	retry := se.Retry
+	if retry == nil && settings != nil {
+		retry = settings.retry
+	}
+
+	if retry == nil || !retry.retries(se.ErrorCode) {
+		return nil
+	}
+
+	// check if we must override this retry value
+	if w.stageRetryOverride != nil {
+		return w.stageRetryOverride
+	}
+	return retry
+}
+
+// waitForRetry waits before the next attempt of a stage, returns false if the context is done before
+func waitForRetry(ctx context.Context, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 func (w *jobWorkflow) executeCompleteActivity(ctx context.Context, stageName string, state *JobState, io SparkDataIO) (*ExecuteStageResponse, error) {
 	var (
 		out *ExecuteStageResponse
@@ -204,10 +698,11 @@ func (w *jobWorkflow) executeCompleteActivity(ctx context.Context, stageName str
 	)
 
 	out, err = w.ExecuteCompleteActivity(ctx, &ExecuteStageRequest{
-		StageName:     stageName,
-		JobKey:        state.JobContext.JobKeyValue,
-		TransactionId: state.JobContext.TransactionIdValue,
-		CorrelationId: state.JobContext.CorrelationIdValue,
+		StageName:        stageName,
+		JobKey:           state.JobContext.JobKeyValue,
+		TransactionId:    state.JobContext.TransactionIdValue,
+		CorrelationId:    state.JobContext.CorrelationIdValue,
+		FailedStageError: state.FailedStageError,
 	}, io)
 	if err != nil {
 		return nil, err
@@ -216,6 +711,32 @@ func (w *jobWorkflow) executeCompleteActivity(ctx context.Context, stageName str
 	return out, nil
 }
 
+// executeStageAttempt runs a single attempt of a stage, the attempt gets its own context when the stage has a
+// timeout and fails with ErrorCodeTimeout if the timeout was exceeded before the stage returned
+func (w *jobWorkflow) executeStageAttempt(ctx context.Context, req *ExecuteStageRequest, settings *stageSettings, io SparkDataIO) (Bindable, StageError) {
+	if settings == nil || settings.timeout <= 0 {
+		return w.ExecuteStageActivity(ctx, req, io)
+	}
+
+	sctx, cancel := context.WithTimeoutCause(ctx, settings.timeout, ErrStageTimeout)
+	defer cancel()
+
+	sr, err := w.ExecuteStageActivity(sctx, req, io)
+	if err != nil || ctx.Err() != nil || !errors.Is(context.Cause(sctx), ErrStageTimeout) {
+		return sr, err
+	}
+
+	// the result of a stage that returned after its timeout is discarded
+	var retry *RetryConfig
+	if codec.MimeType(sr.GetMimeType()) == MimeJsonError {
+		se := errorWrap{StageName: req.StageName}
+		if err := sr.Bind(&se); err == nil {
+			retry = se.Retry
+		}
+	}
+	return getTransferableError(newErrStageTimeout(req.StageName, settings.timeout, retry)), nil
+}
+
 func (w *jobWorkflow) ExecuteStageActivity(ctx context.Context, req *ExecuteStageRequest, io SparkDataIO) (Bindable, StageError) {
 	fn := w.Chain.GetStageFunc(req.StageName)
 
@@ -237,18 +758,77 @@ func (w *jobWorkflow) ExecuteStageActivity(ctx context.Context, req *ExecuteStag
 		return getTransferableError(err.(error)), nil
 	}
 
-	stageValue, err2 := codec.Encode(out)
+	if res, ok := w.putStageResultStream(req.StageName, out, io); ok {
+		return res, nil
+	}
+
+	stageValue, mimeType, err2 := encodeStageResult(out)
 	if err2 != nil {
 		return getTransferableError(err2), nil
 	}
 
-	res, err2 := io.PutStageResult(req.StageName, stageValue)
+	res, err2 := io.PutStageResult(req.StageName, stageValue, string(mimeType))
 	if err2 != nil {
 		return getTransferableError(err2), nil
 	}
 	return res, nil
 }
 
+// putStageResultStream stores a stage result that is returned as a StreamResult or io.Reader, returns false if the
+// result is not streamed
+func (w *jobWorkflow) putStageResultStream(stageName string, out any, sparkIO SparkDataIO) (Bindable, bool) {
+	var sr *StreamResult
+	switch v := out.(type) {
+	case *StreamResult:
+		sr = v
+	case io.Reader:
+		sr = NewStreamResult(v, codec.MimeTypeOctetStream)
+	default:
+		return nil, false
+	}
+
+	if c, ok := sr.Reader.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	res, err := sparkIO.PutStageResultStream(stageName, sr.Reader, string(sr.MimeType))
+	if err != nil {
+		return getTransferableError(err), true
+	}
+	return res, true
+}
+
+// executeBranchActivity runs the selector of a branch and returns the key of the selected branch
+func (w *jobWorkflow) executeBranchActivity(ctx context.Context, b *Branch, state *JobState, io SparkDataIO) (string, StageError) {
+	sc := NewStageContext(ctx, &ExecuteStageRequest{
+		StageName:        b.Name,
+		JobKey:           state.JobContext.JobKeyValue,
+		TransactionId:    state.JobContext.TransactionIdValue,
+		CorrelationId:    state.JobContext.CorrelationIdValue,
+		FailedStageError: state.FailedStageError,
+	}, io, b.Name, NewLogger(), make(map[string]Bindable))
+
+	var (
+		key string
+		err StageError
+	)
+	_ = w.executeFn(func() (any, StageError) {
+		key, err = b.selector(sc)
+		return nil, err
+	}, &err)
+
+	return key, err
+}
+
+// putBranchResult stores the key of the selected branch as the result of the branch step
+func (w *jobWorkflow) putBranchResult(name, key string, io SparkDataIO) (Bindable, error) {
+	value, err := codec.Encode(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.PutStageResult(name, value, string(codec.DetectMimeType(key)))
+}
+
 func (w *jobWorkflow) ExecuteCompleteActivity(ctx context.Context, req *ExecuteStageRequest, io SparkDataIO) (*ExecuteStageResponse, StageError) {
 	fn := w.Chain.GetStageCompleteFunc(req.StageName)
 	cc := NewCompleteContext(ctx, req, io, req.StageName, NewLogger(), make(map[string]Bindable))
@@ -274,8 +854,17 @@ func (w *jobWorkflow) ExecuteCompleteActivity(ctx context.Context, req *ExecuteS
 		Outputs: map[string]Bindable{},
 	}
 	for _, output := range cc.(*completeContext).outputs {
+		value := &BindableValue{Value: output.Value, MimeType: string(output.MimeType)}
+		if output.Reader != nil {
+			ref, err := w.putOutputStream(ctx, output)
+			if err != nil {
+				return nil, NewStageError(fmt.Errorf("error occured setting output: %w", err))
+			}
+			value = &BindableValue{Ref: ref, MimeType: string(output.MimeType)}
+		}
+
 		var err error
-		if res.Outputs[output.Name], err = io.NewOutput(req.StageName, &BindableValue{Value: output.Value, MimeType: string(output.MimeType)}); err != nil {
+		if res.Outputs[output.Name], err = io.NewOutput(req.StageName, value); err != nil {
 			return nil, NewStageError(fmt.Errorf("error occured setting output: %w", err))
 		}
 	}
@@ -283,6 +872,23 @@ func (w *jobWorkflow) ExecuteCompleteActivity(ctx context.Context, req *ExecuteS
 	return res, err
 }
 
+// putOutputStream writes a streamed output to the variable store, the outputs of the job reference it by the
+// returned key
+func (w *jobWorkflow) putOutputStream(ctx context.Context, output *Var) (string, error) {
+	if c, ok := output.Reader.(io.Closer); ok {
+		defer c.Close()
+	}
+	if w.store == nil {
+		return "", fmt.Errorf("unable to store output %s: no variable store configured", output.Name)
+	}
+
+	ref := uuid.NewString()
+	if err := w.store.PutReader(ctx, ref, output.Reader); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
 func (w *jobWorkflow) executeFn(executor func() (any, StageError), se *StageError) any {
 	var v any
 	defer func() {
@@ -302,16 +908,62 @@ func (w *jobWorkflow) executeFn(executor func() (any, StageError), se *StageErro
 	return v
 }
 
-func (w *jobWorkflow) setStageStatus(name string, status StageStatus) {
+// skipStage marks a stage as skipped, the result of a skipped stage is an empty value so later stages can
+// still request it, binding it is a no-op
+func (w *jobWorkflow) skipStage(state *JobState, name string, io SparkDataIO) Bindable {
+	w.setStageStatus(state, name, StageStatus_STAGE_SKIPPED)
+
+	res, err := io.PutStageResult(name, nil, string(codec.MimeTypeJson))
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to store empty result of skipped stage %s", name)
+		return nil
+	}
+
+	if state.StageResults == nil {
+		state.StageResults = make(map[string]Bindable)
+	}
+	state.StageResults[name] = res
+	return res
+}
+
+// checkpoint records the outcome of a stage so it does not run again if the job is redelivered
+func (w *jobWorkflow) checkpoint(ctx context.Context, cp *jobCheckpoint, state *JobState, name string, res Bindable) {
+	entry, err := cp.record(name, state.StageStatuses[name], res)
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to checkpoint result of stage %s", name)
+		return
+	}
+	w.saveCheckpoint(ctx, state.JobContext.JobKeyValue, name, entry)
+}
+
+func (w *jobWorkflow) ack(msg jetstream.Msg) {
+	if err := msg.Ack(); err != nil {
+		log.Error().Err(err).Msgf("failed to acknowledge job request")
+	}
+}
+
+func (w *jobWorkflow) setStageStatus(state *JobState, name string, status StageStatus) {
+	if state.StageStatuses == nil {
+		state.StageStatuses = make(map[string]StageStatus)
+	}
+	state.StageStatuses[name] = status
+
 	if w.stageTracker != nil {
 		w.stageTracker.SetStageStatus(name, status)
 	}
 }
 
 func (w *jobWorkflow) publish(b []byte) {
+	publish := w.publisher
+	if publish == nil {
+		publish = func(b []byte) error {
+			return w.nc.Publish(w.cfg.NatsResponseSubject, b)
+		}
+	}
+
 	pb := backoff.NewExponentialBackOff()
 	if err := backoff.Retry(func() error {
-		if err := w.nc.Publish(w.cfg.NatsResponseSubject, b); err != nil {
+		if err := publish(b); err != nil {
 			log.Error().Err(err).Msgf("failed to publish result to broker, will retry")
 			return err
 		}
@@ -321,8 +973,18 @@ func (w *jobWorkflow) publish(b []byte) {
 	}
 }
 
-func (w *jobWorkflow) publishError(err error) {
-	result := getSparkErrorOutput(err)
+// publishError publishes the error of a job that could not complete, the job metadata is optional since it is
+// not available when the job request is malformed
+func (w *jobWorkflow) publishError(jmd *JobMetadata, err error) {
+	result := &ExecuteSparkOutput{Error: getSparkErrorOutput(err).Error}
+	if jmd != nil {
+		result.JobPid = jmd.JobPid
+		result.JobKey = jmd.JobKeyValue
+		result.CorrelationId = jmd.CorrelationIdValue
+		result.TransactionId = jmd.TransactionIdValue
+		result.Model = jmd.Model
+	}
+
 	b, err := json.Marshal(result)
 	if err != nil {
 		log.Error().Err(err).Msgf("spark errored but could not marshal error response, result will be lost")
@@ -336,17 +998,53 @@ func NewJobWorkflow(ctx context.Context, sparkId string, chain *SparkChain, opts
 		wo = opt(wo)
 	}
 
-	return &jobWorkflow{
+	wf := &jobWorkflow{
 		ctx:                ctx,
 		SparkId:            sparkId,
 		Chain:              chain,
 		stageTracker:       wo.stageTracker,
 		cfg:                wo.config,
 		nc:                 wo.nc,
-		store:              wo.os,
+		store:              wo.store,
 		inputs:             wo.inputs,
 		stageRetryOverride: wo.stageRetryOverride,
-	}, nil
+		publisher:          wo.publisher,
+	}
+
+	if wf.store == nil && wo.os != nil {
+		encoding, threshold := ContentEncodingNone, defaultEncodingThreshold
+		if wf.cfg != nil {
+			encoding, threshold = wf.cfg.contentEncoding()
+		}
+
+		var keys KeyProvider
+		if wf.cfg != nil {
+			var err error
+			if keys, err = wf.cfg.keyProvider(); err != nil {
+				return nil, err
+			}
+		}
+
+		store, err := NewEncodedVariableStore(NewJetStreamVariableStore(wo.os), encoding, threshold, keys)
+		if err != nil {
+			return nil, err
+		}
+		wf.store = store
+	}
+
+	if wf.nc != nil {
+		js, err := jetstream.New(wf.nc)
+		if err != nil {
+			return nil, err
+		}
+		wf.js = js
+	}
+
+	if err := wf.listenForCancellations(); err != nil {
+		return nil, err
+	}
+
+	return wf, nil
 }
 
 // errorWrap used to marshal errors between workflow and activities
@@ -384,6 +1082,24 @@ func getTransferableError(err error) Bindable {
 	return NewBindable(Value{Value: ew, MimeType: string(MimeJsonError)})
 }
 
+func getSparkCancelledOutput(stageName string, cause error) *ExecuteStageResponse {
+	if cause == nil {
+		cause = context.Canceled
+	}
+
+	return &ExecuteStageResponse{
+		Error: &ExecuteSparkError{
+			StageName:    stageName,
+			ErrorCode:    ErrorCodeCancelled,
+			ErrorMessage: cause.Error(),
+		},
+		Cancellation: &ExecuteSparkCancellation{
+			StageName: stageName,
+			Reason:    cause.Error(),
+		},
+	}
+}
+
 func getSparkErrorOutput(err error) *ExecuteStageResponse {
 	if e, ok := err.(errorWrap); ok {
 		return &ExecuteStageResponse{
@@ -397,6 +1113,18 @@ func getSparkErrorOutput(err error) *ExecuteStageResponse {
 		}
 	}
 
+	if se, ok := err.(StageError); ok {
+		return &ExecuteStageResponse{
+			Error: &ExecuteSparkError{
+				StageName:    se.StageName(),
+				ErrorCode:    se.ErrorCode(),
+				ErrorMessage: se.Error(),
+				Metadata:     se.Metadata(),
+				StackTrace:   getStackTrace(se),
+			},
+		}
+	}
+
 	var stackTrace []StackTraceItem
 	if st, ok := err.(stackTracer); ok {
 		stackTrace = getStackTrace(st)
@@ -0,0 +1,263 @@
+package sparkv1
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/codec"
+	"github.com/azarc-io/vth-faas-sdk-go/pkg/spark/v1/util"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMsg struct {
+	jetstream.Msg
+	data       []byte
+	deliveries uint64
+	acked      bool
+	naked      bool
+	nakDelay   time.Duration
+}
+
+func (m *testMsg) Data() []byte {
+	return m.data
+}
+
+func (m *testMsg) Ack() error {
+	m.acked = true
+	return nil
+}
+
+func (m *testMsg) Nak() error {
+	m.naked = true
+	return nil
+}
+
+func (m *testMsg) NakWithDelay(delay time.Duration) error {
+	m.naked, m.nakDelay = true, delay
+	return nil
+}
+
+func (m *testMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.deliveries}, nil
+}
+
+func (m *testMsg) Subject() string {
+	return "test.request"
+}
+
+func (m *testMsg) Headers() nats.Header {
+	return nats.Header{"Origin": []string{"test"}}
+}
+
+func TestWorkflowResumesFromCheckpoint(t *testing.T) {
+	port, err := util.GetFreeTCPPort()
+	require.NoError(t, err)
+
+	s, err := util.RunServerOnPort(port, t.TempDir())
+	require.NoError(t, err)
+	defer s.Shutdown()
+	s.Start()
+
+	nc, js := util.GetNatsClient(port)
+	defer nc.Close()
+
+	store, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
+		Bucket: "test",
+	})
+	require.NoError(t, err)
+
+	sub, err := nc.SubscribeSync("test.response")
+	require.NoError(t, err)
+
+	var (
+		stage1Calls int32
+		stage2Calls int32
+		crash       int32 = 1
+		stage1Mime  string
+	)
+
+	b := NewBuilder()
+	b.NewChain("chain-1").
+		Stage("stage-1", func(_ StageContext) (any, StageError) {
+			atomic.AddInt32(&stage1Calls, 1)
+			return "one", nil
+		}).
+		Stage("stage-2", func(ctx StageContext) (any, StageError) {
+			atomic.AddInt32(&stage2Calls, 1)
+			if atomic.CompareAndSwapInt32(&crash, 1, 0) {
+				// simulates the spark crashing before the job completed
+				runtime.Goexit()
+			}
+
+			stage1Mime = ctx.StageResult("stage-1").GetMimeType()
+
+			var one string
+			if err := ctx.StageResult("stage-1").Bind(&one); err != nil {
+				return nil, NewStageError(err)
+			}
+			return one + " two", nil
+		}).
+		Complete(func(ctx CompleteContext) StageError {
+			var two string
+			if err := ctx.StageResult("stage-2").Bind(&two); err != nil {
+				return NewStageError(err)
+			}
+			if err := ctx.Output(NewVar("out", codec.MimeTypeJson, two)); err != nil {
+				return NewStageError(err)
+			}
+			return nil
+		})
+
+	wf, err := NewJobWorkflow(context.Background(), "test", b.BuildChain(),
+		WithConfig(&Config{NatsResponseSubject: "test.response"}),
+		WithNatsClient(nc),
+		WithObjectStore(store),
+	)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(&JobMetadata{JobKeyValue: "resume", VariablesKey: "resume"})
+	require.NoError(t, err)
+	msg := &testMsg{data: data, deliveries: 1}
+
+	t.Run("crashed job is not acknowledged", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			wf.Run(msg)
+		}()
+		<-done
+
+		assert.False(t, msg.acked)
+		_, err := store.GetBytes(context.Background(), checkpointKey("resume", "stage-1"))
+		assert.NoError(t, err, "stage-1 must have been checkpointed")
+		_, err = store.GetBytes(context.Background(), checkpointKey("resume", "stage-2"))
+		assert.ErrorIs(t, err, jetstream.ErrObjectNotFound, "stage-2 must not have been checkpointed")
+	})
+
+	t.Run("redelivered job resumes from the last completed stage", func(t *testing.T) {
+		msg.deliveries++
+		wf.Run(msg)
+
+		assert.True(t, msg.acked)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&stage1Calls), "stage-1 must not run again")
+		assert.Equal(t, int32(2), atomic.LoadInt32(&stage2Calls))
+		assert.Equal(t, string(codec.MimeTypeText), stage1Mime, "mime type must be restored from the checkpoint")
+
+		m, err := sub.NextMsg(time.Second * 5)
+		require.NoError(t, err)
+
+		var res struct {
+			Error        *ExecuteSparkError `json:"error"`
+			VariablesKey string             `json:"variables_key"`
+		}
+		require.NoError(t, json.Unmarshal(m.Data, &res))
+		require.Nil(t, res.Error)
+
+		ob, err := store.GetBytes(context.Background(), res.VariablesKey)
+		require.NoError(t, err)
+
+		var outputs map[string]*BindableValue
+		require.NoError(t, json.Unmarshal(ob, &outputs))
+
+		var out string
+		assert.NoError(t, outputs["out"].Bind(&out))
+		assert.Equal(t, "one two", out)
+
+		for _, stage := range []string{"stage-1", "stage-2"} {
+			_, err = store.GetBytes(context.Background(), checkpointKey("resume", stage))
+			assert.ErrorIs(t, err, jetstream.ErrObjectNotFound, "checkpoint must be removed once the job completed")
+		}
+	})
+}
+
+func TestWorkflowResumesBranchThatNoLongerExists(t *testing.T) {
+	port, err := util.GetFreeTCPPort()
+	require.NoError(t, err)
+
+	s, err := util.RunServerOnPort(port, t.TempDir())
+	require.NoError(t, err)
+	defer s.Shutdown()
+	s.Start()
+
+	nc, js := util.GetNatsClient(port)
+	defer nc.Close()
+
+	store, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{
+		Bucket: "test",
+	})
+	require.NoError(t, err)
+
+	sub, err := nc.SubscribeSync("test.response")
+	require.NoError(t, err)
+
+	b := NewBuilder()
+	b.NewChain("chain-1").
+		Branch("route", func(_ StageContext) (string, StageError) {
+			return "a", nil
+		}, map[string]Chain{
+			"a": b.NewChain("branch-a").Stage("stage-a", func(_ StageContext) (any, StageError) {
+				return nil, nil
+			}).Complete(CompleteSuccess),
+		}).
+		Complete(CompleteSuccess)
+
+	wf, err := NewJobWorkflow(context.Background(), "test", b.BuildChain(),
+		WithConfig(&Config{NatsResponseSubject: "test.response"}),
+		WithNatsClient(nc),
+		WithObjectStore(store),
+	)
+	require.NoError(t, err)
+
+	// the checkpoint was written by a version of the chain that had a branch "b"
+	wf.(*jobWorkflow).saveCheckpoint(context.Background(), "resume", "route", &checkpointEntry{
+		Status: StageStatus_STAGE_COMPLETED,
+		Result: NewBindableValue("b", string(codec.MimeTypeJson)),
+	})
+
+	data, err := json.Marshal(&JobMetadata{JobKeyValue: "resume", VariablesKey: "resume"})
+	require.NoError(t, err)
+	msg := &testMsg{data: data, deliveries: 2}
+	wf.Run(msg)
+
+	m, err := sub.NextMsg(time.Second * 5)
+	require.NoError(t, err)
+
+	var res struct {
+		Error *ExecuteSparkError `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(m.Data, &res))
+	require.NotNil(t, res.Error)
+	assert.Contains(t, res.Error.ErrorMessage, ErrBranchNotFound.Error())
+}
+
+func TestRetryConfigBackoff(t *testing.T) {
+	r := &RetryConfig{FirstBackoffWait: time.Second, BackoffMultiplier: 2, MaxBackoffWait: time.Second * 5}
+
+	assert.Equal(t, time.Second, r.backoff(1))
+	assert.Equal(t, time.Second*2, r.backoff(2))
+	assert.Equal(t, time.Second*4, r.backoff(3))
+	assert.Equal(t, time.Second*5, r.backoff(4), "wait must be capped")
+	assert.Equal(t, time.Second*5, r.backoff(64), "wait must not overflow")
+
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		wait := r.backoff(2)
+		assert.GreaterOrEqual(t, wait, time.Second)
+		assert.LessOrEqual(t, wait, time.Second*3)
+	}
+}
+
+func TestRetryConfigRetries(t *testing.T) {
+	assert.True(t, (&RetryConfig{}).retries(ErrorCodeGeneric), "all codes must be retried without a retry on list")
+
+	r := &RetryConfig{RetryOn: []ErrorCode{ErrorCodeTimeout}}
+	assert.True(t, r.retries(ErrorCodeTimeout))
+	assert.False(t, r.retries(ErrorCodeGeneric))
+}